@@ -0,0 +1,502 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vishvananda/netlink"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Kernel does not keep the ID of a tenant, so the tenants adopted by discovery
+// are named after their class ID, <bridge>-<class ID in hex>. Nor does it keep
+// the S-NSSAI of a slice, so the slice classes found are not adopted into the
+// slice registry but kept apart in DiscoveredSliceMap.
+
+// Slice classes found in kernel at startup and not restored, by bridge. They
+// are left in kernel as they are, and still take their bandwidth. Guarded by
+// sliceLock.
+var DiscoveredSliceMap map[string][]*DiscoveredSlice = make(map[string][]*DiscoveredSlice)
+
+// DiscoveredSlice is a slice class found in kernel, with the filters which
+// classify into it, and the uplink class on the ifb device with the reverse
+// matches
+type DiscoveredSlice struct {
+	Bridge         string              `json:"Bridge"`
+	VxlanInterface string              `json:"VxlanInterface"`
+	ClassId        uint16              `json:"ClassId"`
+	Matches        []internal.Match    `json:"Matches"`
+	DownlinkRate   int                 `json:"DownlinkRate"`
+	DownlinkCeil   int                 `json:"DownlinkCeil"`
+	Prio           uint32              `json:"Prio"`
+	Netem          *internal.NetemSpec `json:"Netem,omitempty"`
+	Remark         *internal.Remark    `json:"Remark,omitempty"`
+
+	IfbInterface  string `json:"IfbInterface,omitempty"`
+	UplinkClassId uint16 `json:"UplinkClassId,omitempty"`
+	UplinkRate    int    `json:"UplinkRate,omitempty"`
+	UplinkCeil    int    `json:"UplinkCeil,omitempty"`
+
+	// Tenant the class is nested under, with the class ID of the tenant
+	Tenant        string `json:"Tenant,omitempty"`
+	ParentClassId uint16 `json:"ParentClassId,omitempty"`
+}
+
+// DiscoveryReport reports the state restored from the state store and the
+// kernel state adopted at startup
+type DiscoveryReport struct {
+	Time time.Time `json:"Time"`
+	// Records of the state store found in kernel, <bridge>, veth <name>,
+	// tenant <bridge>/<tenant ID>, slice <bridge>/<S-NSSAI> or e2eslice
+	// <S-NSSAI>
+	Restored []string `json:"Restored"`
+	// Records of the state store not found in kernel, they are dropped
+	Stale []DiscoveryItem `json:"Stale"`
+	// Bridges with a vxlan interface adopted into the bridge registry
+	Bridges []string `json:"Bridges"`
+	// Slice classes found in kernel, kept apart as their S-NSSAI is not
+	// known, see DiscoveredSlice
+	Slices []*DiscoveredSlice `json:"Slices"`
+	// Tenants adopted, <bridge>/<tenant ID>
+	Tenants []string `json:"Tenants"`
+	// Kernel objects which could not be mapped to a bridge, tenant or slice
+	Unmapped []DiscoveryItem `json:"Unmapped"`
+}
+
+// DiscoveryItem is a kernel object discovery could not map, or could map
+// only in part, or a record of the state store not found in kernel
+type DiscoveryItem struct {
+	Link   string `json:"Link"`
+	Object string `json:"Object"` // link, class, filter, or store, bridge, veth, tenant, slice, e2eslice for a record
+	Handle string `json:"Handle"`
+	Reason string `json:"Reason"`
+}
+
+// Report of the discovery at startup, guarded by sliceLock
+var discoveryReport = DiscoveryReport{
+	Restored: []string{},
+	Stale:    []DiscoveryItem{},
+	Bridges:  []string{},
+	Slices:   []*DiscoveredSlice{},
+	Tenants:  []string{},
+	Unmapped: []DiscoveryItem{},
+}
+
+// discover restores the state kept in the state store which is still found
+// in kernel. It then scans the bridges with a vxlan port, and the htb classes
+// and u32 filters of their vxlan interface and ifb device, and adopts those
+// not restored into BridgeMap and TenantMap, and the slice classes into
+// DiscoveredSliceMap. What could not be
+// mapped is recorded in the discovery report, and the result is saved to the
+// state store.
+func discover() DiscoveryReport {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	report := DiscoveryReport{
+		Time:     time.Now(),
+		Restored: []string{},
+		Stale:    []DiscoveryItem{},
+		Bridges:  []string{},
+		Slices:   []*DiscoveredSlice{},
+		Tenants:  []string{},
+		Unmapped: []DiscoveryItem{},
+	}
+
+	ports, err := internal.ListVxlanPorts()
+	if err != nil {
+		report.unmapped("", "link", "", fmt.Sprintf("failed to list link, %s", err))
+		discoveryReport = report
+		return report
+	}
+
+	if stateStore != nil {
+		restoreState(ports, &report)
+	}
+
+	for _, port := range ports {
+		if vxlanIf, ok := BridgeMap[port.Bridge]; ok {
+			if vxlanIf != port.Vxlan {
+				report.unmapped(port.Vxlan, "link", "", fmt.Sprintf("bridge %s already has vxlan interface %s", port.Bridge, vxlanIf))
+				continue
+			}
+			// Restored, adopt the classes not recorded
+			if _, ok := BridgeConfigMap[port.Bridge]; !ok {
+				BridgeConfigMap[port.Bridge] = portConfig(port)
+			}
+			discoverBridge(port, true, &report)
+			continue
+		}
+
+		BridgeMap[port.Bridge] = port.Vxlan
+		BridgeConfigMap[port.Bridge] = portConfig(port)
+		report.Bridges = append(report.Bridges, port.Bridge)
+		discoverBridge(port, false, &report)
+	}
+
+	sort.Strings(report.Bridges)
+	sort.Strings(report.Tenants)
+
+	saveState()
+	discoveryReport = report
+	return report
+}
+
+// portConfig reads back the request which creates the vxlan bridge of port,
+// the first ipv4 address of the bridge is taken
+func portConfig(port internal.VxlanPort) VxlanInterfaceRequest {
+	config := VxlanInterfaceRequest{
+		BindInterface:  port.Underlay,
+		VxlanInterface: port.Vxlan,
+		VxlanId:        fmt.Sprint(port.VxlanId),
+		RemoteIp:       port.Remote,
+	}
+	if addrs := internal.GetLinkStatus(port.Bridge).Addrs; len(addrs) > 0 {
+		config.LocalBridgeIp = addrs[0]
+	}
+	return config
+}
+
+// discoverBridge adopts the tenants and finds the slice classes on vxlan
+// interface of port, except the ones of the bridge restored from the state
+// store. The caller must hold sliceLock.
+func discoverBridge(port internal.VxlanPort, restored bool, report *DiscoveryReport) {
+	tc, err := internal.ListTc(port.Vxlan)
+	if err != nil {
+		report.unmapped(port.Vxlan, "link", "", fmt.Sprintf("failed to read tc, %s", err))
+		return
+	}
+
+	capacity := internal.LinkCapacity(port.Vxlan)
+	if !restored {
+		// Capacity the htb tree was sized to is kept, so the parent class
+		// is not resized when a slice is added
+		capacity = 0
+		if tc != nil {
+			capacity = tc.Capacity
+		}
+		capacity = internal.SetLinkCapacity(port.Vxlan, port.Underlay, capacity)
+		sysLogger.Printf("Discovered bridge %s with vxlan interface %s, capacity %d KB/Sec\n", port.Bridge, port.Vxlan, capacity)
+	}
+
+	if tc == nil {
+		return
+	}
+
+	// Tenants by class ID, the restored ones are looked up as parents but
+	// not adopted again
+	tenants := make(map[uint16]*Tenant)
+	claimed := make(map[uint16]bool)
+	uplinkClaimed := make(map[uint16]bool)
+	for _, tenant := range TenantMap[port.Bridge] {
+		tenants[tenant.ClassId] = tenant
+		claimed[tenant.ClassId] = true
+		uplinkClaimed[tenant.UplinkClassId] = true
+	}
+	for _, slice := range SliceMap[port.Bridge] {
+		claimed[slice.ClassId] = true
+		uplinkClaimed[slice.UplinkClassId] = true
+	}
+
+	tc = withoutClasses(tc, claimed)
+	slices := make(map[uint16]*DiscoveredSlice)
+	filters := classFilters(tc, report)
+
+	for _, class := range tc.Classes {
+		if class.Parent == internal.ParentClassId && len(filters[class.ClassId]) == 0 && hasChildren(tc, class.ClassId) {
+			tenants[class.ClassId] = &Tenant{
+				Id:             fmt.Sprintf("%s-%x", port.Bridge, class.ClassId),
+				Bridge:         port.Bridge,
+				VxlanInterface: port.Vxlan,
+				ClassId:        class.ClassId,
+				DownlinkRate:   class.Rate,
+				DownlinkCeil:   class.Ceil,
+				Prio:           class.Prio,
+				Discovered:     true,
+			}
+		}
+	}
+
+	for _, class := range tc.Classes {
+		if _, ok := tenants[class.ClassId]; ok {
+			continue
+		}
+
+		var tenant *Tenant
+		if class.Parent != internal.ParentClassId {
+			if tenant = tenants[class.Parent]; tenant == nil {
+				report.unmapped(tc.Link, "class", classHandle(class.ClassId), fmt.Sprintf("parent %s is not a tenant class", classHandle(class.Parent)))
+				continue
+			}
+		}
+
+		matches, ok := classMatches(tc, class, filters[class.ClassId], report)
+		if !ok {
+			continue
+		}
+
+		slice := &DiscoveredSlice{
+			Bridge:         port.Bridge,
+			VxlanInterface: port.Vxlan,
+			ClassId:        class.ClassId,
+			Matches:        matches,
+			DownlinkRate:   class.Rate,
+			DownlinkCeil:   class.Ceil,
+			Prio:           class.Prio,
+			Netem:          class.Netem,
+		}
+		for _, filter := range filters[class.ClassId] {
+			if filter.Remark != nil {
+				slice.Remark = filter.Remark
+			}
+		}
+		if tenant != nil {
+			slice.Tenant = tenant.Id
+			slice.ParentClassId = tenant.ClassId
+		}
+		slices[class.ClassId] = slice
+	}
+
+	if ifb := internal.IfbOf(port.Vxlan); ifb != "" {
+		internal.SetLinkCapacity(ifb, "", capacity)
+		discoverUplink(ifb, uplinkClaimed, tenants, slices, report)
+	}
+
+	if len(tenants) > 0 && TenantMap[port.Bridge] == nil {
+		TenantMap[port.Bridge] = make(map[string]*Tenant)
+	}
+	for _, tenant := range tenants {
+		if claimed[tenant.ClassId] {
+			continue
+		}
+		TenantMap[port.Bridge][tenant.Id] = tenant
+		report.Tenants = append(report.Tenants, port.Bridge+"/"+tenant.Id)
+	}
+
+	discovered := []*DiscoveredSlice{}
+	for _, slice := range slices {
+		discovered = append(discovered, slice)
+	}
+	sort.Slice(discovered, func(i, j int) bool {
+		return discovered[i].ClassId < discovered[j].ClassId
+	})
+	if len(discovered) > 0 {
+		DiscoveredSliceMap[port.Bridge] = discovered
+		report.Slices = append(report.Slices, discovered...)
+	}
+}
+
+// discoverUplink adopts the uplink classes on ifb device, except the claimed
+// ones, into the tenants and slice classes of its vxlan interface. An uplink slice
+// class is the one whose filters match the reverse of the downlink filters of
+// a slice.
+func discoverUplink(ifb string, claimed map[uint16]bool, tenants map[uint16]*Tenant, slices map[uint16]*DiscoveredSlice, report *DiscoveryReport) {
+	tc, err := internal.ListTc(ifb)
+	if err != nil {
+		report.unmapped(ifb, "link", "", fmt.Sprintf("failed to read tc, %s", err))
+		return
+	}
+	if tc == nil {
+		return
+	}
+	tc = withoutClasses(tc, claimed)
+
+	filters := classFilters(tc, report)
+	uplinkTenants := make(map[uint16]internal.LinkClass)
+
+	for _, class := range tc.Classes {
+		if class.Parent == internal.ParentClassId && len(filters[class.ClassId]) == 0 && hasChildren(tc, class.ClassId) {
+			uplinkTenants[class.ClassId] = class
+		}
+	}
+
+	for _, class := range tc.Classes {
+		if _, ok := uplinkTenants[class.ClassId]; ok {
+			continue
+		}
+
+		matches, ok := classMatches(tc, class, filters[class.ClassId], report)
+		if !ok {
+			continue
+		}
+
+		var slice *DiscoveredSlice
+		for _, downlink := range slices {
+			if downlink.UplinkClassId == 0 && reflect.DeepEqual(internal.ReverseMatches(downlink.Matches), matches) {
+				slice = downlink
+				break
+			}
+		}
+		if slice == nil {
+			report.unmapped(ifb, "class", classHandle(class.ClassId), "no downlink slice with the reverse matches")
+			continue
+		}
+
+		if class.Parent != internal.ParentClassId {
+			tenantClass, ok := uplinkTenants[class.Parent]
+			tenant := tenants[slice.ParentClassId]
+			if tenant == nil || (tenant.UplinkClassId != class.Parent && (!ok || tenant.UplinkClassId != 0)) {
+				report.unmapped(ifb, "class", classHandle(class.ClassId), fmt.Sprintf("parent %s is not the uplink class of the tenant of slice class %s", classHandle(class.Parent), classHandle(slice.ClassId)))
+				continue
+			}
+			if tenant.UplinkClassId == 0 {
+				tenant.IfbInterface = ifb
+				tenant.UplinkClassId = class.Parent
+				tenant.UplinkRate = tenantClass.Rate
+				tenant.UplinkCeil = tenantClass.Ceil
+			}
+		} else if slice.Tenant != "" {
+			report.unmapped(ifb, "class", classHandle(class.ClassId), fmt.Sprintf("slice class %s of a tenant not under the uplink class of the tenant", classHandle(slice.ClassId)))
+			continue
+		}
+
+		slice.IfbInterface = ifb
+		slice.UplinkClassId = class.ClassId
+		slice.UplinkRate = class.Rate
+		slice.UplinkCeil = class.Ceil
+	}
+
+	for classId := range uplinkTenants {
+		adopted := false
+		for _, tenant := range tenants {
+			adopted = adopted || tenant.UplinkClassId == classId
+		}
+		if !adopted {
+			report.unmapped(ifb, "class", classHandle(classId), "no slice of a tenant under the class")
+		}
+	}
+}
+
+// classFilters groups the filters of tc by the class they classify into.
+// Filters which do not follow the one priority per class layout are reported.
+func classFilters(tc *internal.LinkTc, report *DiscoveryReport) map[uint16][]internal.LinkFilter {
+	classes := make(map[uint16]bool)
+	for _, class := range tc.Classes {
+		classes[class.ClassId] = true
+	}
+
+	filters := make(map[uint16][]internal.LinkFilter)
+	for _, filter := range tc.Filters {
+		switch {
+		case !classes[filter.ClassId]:
+			report.unmapped(tc.Link, "filter", filterHandle(filter), fmt.Sprintf("class %s is not a slice class", classHandle(filter.ClassId)))
+		case filter.Prio != filter.ClassId:
+			report.unmapped(tc.Link, "filter", filterHandle(filter), "priority is not the class ID")
+		default:
+			filters[filter.ClassId] = append(filters[filter.ClassId], filter)
+		}
+	}
+
+	return filters
+}
+
+// withoutClasses returns tc without the claimed classes and their filters
+func withoutClasses(tc *internal.LinkTc, claimed map[uint16]bool) *internal.LinkTc {
+	unclaimed := *tc
+	unclaimed.Classes = nil
+	unclaimed.Filters = nil
+
+	for _, class := range tc.Classes {
+		if !claimed[class.ClassId] {
+			unclaimed.Classes = append(unclaimed.Classes, class)
+		}
+	}
+	for _, filter := range tc.Filters {
+		if !claimed[filter.ClassId] {
+			unclaimed.Filters = append(unclaimed.Filters, filter)
+		}
+	}
+
+	return &unclaimed
+}
+
+// hasChildren reports whether a class of tc is under class 1:classId
+func hasChildren(tc *internal.LinkTc, classId uint16) bool {
+	for _, class := range tc.Classes {
+		if class.Parent == classId {
+			return true
+		}
+	}
+	return false
+}
+
+// classMatches returns the matches of the filters of class, it reports the
+// class and returns false if they can not be adopted as a slice
+func classMatches(tc *internal.LinkTc, class internal.LinkClass, filters []internal.LinkFilter, report *DiscoveryReport) ([]internal.Match, bool) {
+	handle := classHandle(class.ClassId)
+	if len(filters) == 0 {
+		report.unmapped(tc.Link, "class", handle, "no filter classifies into the class")
+		return nil, false
+	}
+	if class.Leaf != "" {
+		report.unmapped(tc.Link, "class", handle, fmt.Sprintf("leaf qdisc %s is not netem", class.Leaf))
+		return nil, false
+	}
+
+	matches := []internal.Match{}
+	for _, filter := range filters {
+		if filter.Match == nil {
+			report.unmapped(tc.Link, "class", handle, fmt.Sprintf("selector of filter %s is not a slice match", filterHandle(filter)))
+			return nil, false
+		}
+		if filter.UnknownActions > 0 {
+			// Filter is adopted, but its DSCP rewrite is not recorded
+			report.unmapped(tc.Link, "filter", filterHandle(filter), "actions other than 802.1p remark are not adopted")
+		}
+		matches = append(matches, *filter.Match)
+	}
+
+	return matches, true
+}
+
+func (report *DiscoveryReport) unmapped(link, object, handle, reason string) {
+	sysLogger.Printf("Discovery: %s %s %s, %s\n", link, object, handle, reason)
+	report.Unmapped = append(report.Unmapped, DiscoveryItem{
+		Link:   link,
+		Object: object,
+		Handle: handle,
+		Reason: reason,
+	})
+}
+
+func (report *DiscoveryReport) restored(record string) {
+	report.Restored = append(report.Restored, record)
+}
+
+func (report *DiscoveryReport) stale(object, name, reason string) {
+	sysLogger.Printf("Restore: %s %s dropped, %s\n", object, name, reason)
+	report.Stale = append(report.Stale, DiscoveryItem{
+		Object: object,
+		Handle: name,
+		Reason: reason,
+	})
+}
+
+func classHandle(classId uint16) string {
+	return netlink.HandleStr(netlink.MakeHandle(1, classId))
+}
+
+func filterHandle(filter internal.LinkFilter) string {
+	return fmt.Sprintf("%s prio %d", netlink.HandleStr(filter.Handle), filter.Prio)
+}
+
+// getDiscovery handles the GET /api/v1/discovery endpoint.
+// It reports the kernel state adopted at startup.
+//
+// @Summary Report discovery
+// @Description Report the bridges, tenants and slices adopted from kernel at startup, and the classes and filters which could not be mapped
+// @Tags discovery
+// @Produce json
+// @Success 200 {object} DiscoveryReport
+// @Router /api/v1/discovery [get]
+func getDiscovery(c *gin.Context) {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	c.JSON(http.StatusOK, discoveryReport)
+}
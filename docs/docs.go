@@ -17,19 +17,42 @@ const docTemplate = `{
     "paths": {
         "/api/v1/bridge": {
             "get": {
-                "description": "Get the current bridge and its connected interface",
+                "description": "List the linux bridges of the host with their ports (vxlan, veth, physical), addresses, MTU, oper state and MAC, and whether TN-Manager owns them",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "bridge"
                 ],
-                "summary": "Get current bridge and connected interface",
+                "summary": "List bridges",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Only the bridges TN-Manager owns",
+                        "name": "managed",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.BridgeResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.BridgeResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid managed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "System error",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
@@ -37,6 +60,7 @@ const docTemplate = `{
         },
         "/api/v1/bridge/{bridge_name}": {
             "get": {
+                "description": "Retrieve the linux bridge of the given name with its ports, addresses, MTU, oper state and MAC, and whether TN-Manager owns it",
                 "consumes": [
                     "application/json"
                 ],
@@ -57,10 +81,10 @@ const docTemplate = `{
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Bridge existed",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.BridgeResponse"
                         }
                     },
                     "404": {
@@ -96,11 +120,17 @@ const docTemplate = `{
                         "name": "bridge_name",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Bridge created successfully",
+                        "description": "Bridge created successfully, or DryRunResponse of dry run",
                         "schema": {
                             "type": "string"
                         }
@@ -114,9 +144,48 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/interface": {
+        "/api/v1/discovery": {
+            "get": {
+                "description": "Report the bridges, tenants and slices adopted from kernel at startup, and the classes and filters which could not be mapped",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "discovery"
+                ],
+                "summary": "Report discovery",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DiscoveryReport"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/e2eslice": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "e2eslice"
+                ],
+                "summary": "List end-to-end slices",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.E2eSliceStatus"
+                            }
+                        }
+                    }
+                }
+            },
             "post": {
-                "description": "Add a new interface between two bridges",
                 "consumes": [
                     "application/json"
                 ],
@@ -124,25 +193,37 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "interface"
+                    "e2eslice"
                 ],
-                "summary": "Add a new interface",
+                "summary": "Add end-to-end slice",
                 "parameters": [
                     {
-                        "description": "Interface request",
+                        "description": "End-to-end slice request",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.InterfaceRequest"
+                            "$ref": "#/definitions/main.E2eSliceRequest"
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Interface added successfully",
+                        "description": "Planned changes of dry run",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.E2eSliceStatus"
                         }
                     },
                     "400": {
@@ -150,81 +231,96 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "409": {
+                        "description": "Slice existed, or not enough bandwidth",
+                        "schema": {
+                            "$ref": "#/definitions/main.AdmissionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to install slice, all hops rolled back",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/api/v1/slice/{bridge_name}": {
-            "post": {
-                "consumes": [
-                    "application/json"
-                ],
+        "/api/v1/e2eslice/{snssai}": {
+            "get": {
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "slice"
+                    "e2eslice"
                 ],
-                "summary": "Add slice on interface",
+                "summary": "Retrieve end-to-end slice",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Bridge name",
-                        "name": "bridge_name",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "Slice request",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.SliceRequest"
-                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Slice Installed",
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.E2eSliceStatus"
+                        }
+                    },
+                    "404": {
+                        "description": "End-to-end slice not found",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            }
-        },
-        "/api/v1/slice/{bridge_name}/{slice_sd}}": {
+            },
             "delete": {
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
                 "tags": [
-                    "slice"
+                    "e2eslice"
                 ],
-                "summary": "Del slice on interface",
+                "summary": "Delete end-to-end slice",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Bridge name",
-                        "name": "bridge_name",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "type": "string",
-                        "description": "Slice SD identifier",
-                        "name": "slice_sd",
-                        "in": "path",
-                        "required": true
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
                     "204": {
-                        "description": "Slice deletion successful",
+                        "description": "End-to-end slice deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "End-to-end slice not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to delete slice, the failed hops are kept",
                         "schema": {
                             "type": "string"
                         }
@@ -232,38 +328,55 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/vxlan/{bridge_name}": {
+        "/api/v1/events": {
             "get": {
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Stream the link, address and neighbor events of the host as Server-Sent Events, e.g. link-down when vxlan100 went down or port-removed when bridge br0 lost port veth3. The event name is the event type and the id is Id, a client reconnecting with Last-Event-ID resumes from the recent events kept.",
                 "produces": [
-                    "application/json"
+                    "text/event-stream"
                 ],
                 "tags": [
-                    "vxlan-bridge"
+                    "events"
                 ],
-                "summary": "Retrieve vxlan bridge",
+                "summary": "Stream events",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Bridge name",
-                        "name": "bridge_name",
-                        "in": "path",
-                        "required": true
+                        "description": "Comma separated event types: link-added, link-removed, link-up, link-down, port-added, port-removed, addr-added, addr-removed, neigh-added, neigh-removed or neigh-failed",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated link names, port events also match the bridge",
+                        "name": "link",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Id of the last event received",
+                        "name": "Last-Event-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "202": {
-                        "description": "Slice Installed",
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Event"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid event filter or Last-Event-ID",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/v1/interface": {
             "post": {
-                "description": "Add a new bridge with the given name",
+                "description": "Add a new interface between two bridges",
                 "consumes": [
                     "application/json"
                 ],
@@ -271,44 +384,64 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "vxlan-bridge"
+                    "interface"
                 ],
-                "summary": "Add a new bridge",
+                "summary": "Add a new interface",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Bridge name",
-                        "name": "bridge_name",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Vxlan Interface request",
+                        "description": "Interface request",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.VxlanInterfaceRequest"
+                            "$ref": "#/definitions/main.InterfaceRequest"
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Bridge created successfully",
+                    "200": {
+                        "description": "Interface added successfully, or DryRunResponse of dry run",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "400": {
-                        "description": "Invalid bridge name",
+                        "description": "Invalid request body",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
+            }
+        },
+        "/api/v1/profile": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "profile"
+                ],
+                "summary": "List slice profiles",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SliceProfile"
+                            }
+                        }
+                    }
+                }
             },
-            "delete": {
-                "description": "Delete a exist bridge with the given name",
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
@@ -316,21 +449,47 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "vxlan-bridge"
+                    "profile"
                 ],
-                "summary": "Delete bridge",
+                "summary": "Add slice profile",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Bridge name",
-                        "name": "bridge_name",
-                        "in": "path",
-                        "required": true
+                        "description": "Slice profile",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SliceProfile"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate the profile without adding it",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Bridge delete successfully",
+                        "description": "No changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.SliceProfile"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid profile",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Profile existed",
                         "schema": {
                             "type": "string"
                         }
@@ -338,81 +497,2528 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/vxlan/{bridge_name}/activate": {
-            "post": {
-                "consumes": [
-                    "application/json"
-                ],
+        "/api/v1/profile/{profile_name}": {
+            "get": {
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "vxlan-bridge"
+                    "profile"
                 ],
-                "summary": "[Deprecated] Activate vxlan bridge",
+                "summary": "Retrieve slice profile",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Bridge name",
-                        "name": "bridge_name",
+                        "description": "Profile name",
+                        "name": "profile_name",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Bridge Activated",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.SliceProfile"
                         }
                     },
-                    "400": {
-                        "description": "Invalid bridge name",
+                    "404": {
+                        "description": "Profile not found",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            }
-        }
-    },
-    "definitions": {
-        "main.BridgeResponse": {
-            "type": "object",
-            "properties": {
-                "bridge": {
-                    "type": "string"
-                },
-                "interface": {
+            },
+            "delete": {
+                "tags": [
+                    "profile"
+                ],
+                "summary": "Delete slice profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Profile name",
+                        "name": "profile_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Check the profile without deleting it",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "No changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "Profile deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Profile not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reconcile": {
+            "get": {
+                "description": "Report the drifts found and the changes made by the last reconcile, periodic or on demand",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reconcile"
+                ],
+                "summary": "Report last reconcile",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReconcileReport"
+                        }
+                    },
+                    "404": {
+                        "description": "Not reconciled yet",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Compare the recorded bridges, tenants and slices with the kernel and re-apply the links, addresses, classes, qdiscs and filters which are missing or changed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reconcile"
+                ],
+                "summary": "Reconcile",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Return the drifts and planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReconcileReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid dryRun",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/schedule": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedule"
+                ],
+                "summary": "List schedule executions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ScheduleOverview"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/schedule/{schedule_id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedule"
+                ],
+                "summary": "Retrieve schedule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Schedule ID",
+                        "name": "schedule_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Schedule"
+                        }
+                    },
+                    "404": {
+                        "description": "Schedule not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "schedule"
+                ],
+                "summary": "Delete schedule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Schedule ID",
+                        "name": "schedule_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Check the schedule without deleting it",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "No changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "Schedule deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Schedule not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/slice": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "List slices",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Slice"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/slice/{bridge_name}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "List slices on bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Slice"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Vxlan bridge not existed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "Add slice on interface",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Slice request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SliceRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Slice Installed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Slice existed, or not enough bandwidth",
+                        "schema": {
+                            "$ref": "#/definitions/main.AdmissionResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/slice/{bridge_name}/{snssai}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "Retrieve slice",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Slice"
+                        }
+                    },
+                    "404": {
+                        "description": "Slice not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "Del slice on interface",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "Slice deletion successful",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Slice not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to delete slice",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "Update slice on interface",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Slice update request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SliceUpdateRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Updated slice, or DryRunResponse of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.Slice"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Slice not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Not enough bandwidth",
+                        "schema": {
+                            "$ref": "#/definitions/main.AdmissionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to update slice",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/slice/{bridge_name}/{snssai}/schedule": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedule"
+                ],
+                "summary": "List slice schedules",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Schedule"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid S-NSSAI",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Activate, update or deactivate the slice at At, and undo it at Until if set",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "schedule"
+                ],
+                "summary": "Schedule slice action",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Schedule request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ScheduleRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate the request without adding the schedule",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "No changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Schedule"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/slice/{bridge_name}/{snssai}/stats": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "slice"
+                ],
+                "summary": "Retrieve slice statistics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name, or interface of end-to-end slice hops",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "S-NSSAI, \u003cSST\u003e-\u003cSD\u003e or \u003cSST\u003e",
+                        "name": "snssai",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.SliceStats"
+                        }
+                    },
+                    "404": {
+                        "description": "Slice not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to read slice statistics",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/subscriptions": {
+            "get": {
+                "description": "List the webhook subscriptions with their delivered, pending and dead lettered notifications",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscription"
+                ],
+                "summary": "List webhook subscriptions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Subscription"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Subscribe a URL to the notifications of bridges and slices: created, deleted, failed or degraded, and slice updated. Each notification is posted as JSON with headers X-TN-Event, X-TN-Delivery holding the notification Id, and X-TN-Signature sha256=\u003chex HMAC-SHA256 of body\u003e if Secret is set. A notification is posted again with backoff until the subscriber answers 2xx, after the last attempt it is kept in the dead letters. The same notification may be delivered more than once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscription"
+                ],
+                "summary": "Add webhook subscription",
+                "parameters": [
+                    {
+                        "description": "Subscription request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SubscriptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Subscription"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body, Url or event",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/subscriptions/{subscription_id}": {
+            "get": {
+                "description": "Retrieve the webhook subscription of the given ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscription"
+                ],
+                "summary": "Retrieve webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "subscription_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Subscription"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete the webhook subscription of the given ID, its pending notifications and dead letters are dropped",
+                "tags": [
+                    "subscription"
+                ],
+                "summary": "Delete webhook subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "subscription_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Subscription deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/subscriptions/{subscription_id}/deadletters": {
+            "get": {
+                "description": "List the notifications of the subscription which could not be delivered after the last attempt, or did not fit in its queue, the oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscription"
+                ],
+                "summary": "List dead letters",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "subscription_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.DeadLetter"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/tenant": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenant"
+                ],
+                "summary": "List tenants",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TenantResponse"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/tenant/{tenant_id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenant"
+                ],
+                "summary": "Retrieve tenant",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenant_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TenantResponse"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Tenant not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/tenant/{tenant_id}/bridge/{bridge_name}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenant"
+                ],
+                "summary": "Add tenant on bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenant_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tenant request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.TenantResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid tenant",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Bridge not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Tenant existed, or not enough bandwidth",
+                        "schema": {
+                            "$ref": "#/definitions/main.AdmissionResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "tenant"
+                ],
+                "summary": "Delete tenant on bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenant_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "Tenant deleted",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Tenant not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Tenant has slices",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/tenant/{tenant_id}/slice/{bridge_name}": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenant"
+                ],
+                "summary": "Add slice of tenant on bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenant_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Slice request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SliceRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Slice Installed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Tenant not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Slice existed, or not enough bandwidth of tenant",
+                        "schema": {
+                            "$ref": "#/definitions/main.AdmissionResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/vxlan/{bridge_name}": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "vxlan-bridge"
+                ],
+                "summary": "Retrieve vxlan bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Slice Installed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Add a new bridge with the given name",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "vxlan-bridge"
+                ],
+                "summary": "Add a new bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Vxlan Interface request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.VxlanInterfaceRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Bridge created successfully",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid bridge name",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a exist bridge with the given name",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "vxlan-bridge"
+                ],
+                "summary": "Delete bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Bridge delete successfully, or DryRunResponse of dry run",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/vxlan/{bridge_name}/activate": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "vxlan-bridge"
+                ],
+                "summary": "[Deprecated] Activate vxlan bridge",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bridge name",
+                        "name": "bridge_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Return the planned changes without making them",
+                        "name": "dryRun",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Planned changes of dry run",
+                        "schema": {
+                            "$ref": "#/definitions/main.DryRunResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "Bridge Activated",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid bridge name",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "internal.BridgePort": {
+            "type": "object",
+            "properties": {
+                "Kind": {
+                    "description": "vxlan, veth, physical, or the link type of other ports, e.g. tuntap",
+                    "type": "string"
+                },
+                "Mac": {
+                    "type": "string"
+                },
+                "Mtu": {
+                    "type": "integer"
+                },
+                "Name": {
+                    "type": "string"
+                },
+                "OperState": {
+                    "type": "string"
+                },
+                "Underlay": {
+                    "description": "Underlay device of vxlan ports, empty if not bound",
+                    "type": "string"
+                },
+                "VxlanId": {
+                    "description": "VNI of vxlan ports",
+                    "type": "integer"
+                }
+            }
+        },
+        "internal.Match": {
+            "type": "object",
+            "properties": {
+                "Dscp": {
+                    "description": "0-63",
+                    "type": "integer"
+                },
+                "DstIP": {
+                    "description": "ipv4 addr or cidr",
+                    "type": "string"
+                },
+                "DstPort": {
+                    "description": "port or port range",
+                    "type": "string"
+                },
+                "Protocol": {
+                    "description": "tcp, udp, sctp, icmp or ip protocol number",
+                    "type": "string"
+                },
+                "SrcIP": {
+                    "description": "ipv4 addr or cidr",
+                    "type": "string"
+                },
+                "SrcPort": {
+                    "description": "port or port range, e.g. 2152 or 8000-8080",
+                    "type": "string"
+                }
+            }
+        },
+        "internal.NetemSpec": {
+            "type": "object",
+            "properties": {
+                "Correlation": {
+                    "description": "%, of delay, loss, duplicate and reorder",
+                    "type": "number"
+                },
+                "Delay": {
+                    "description": "ms",
+                    "type": "number"
+                },
+                "Duplicate": {
+                    "description": "%",
+                    "type": "number"
+                },
+                "Jitter": {
+                    "description": "ms, requires Delay",
+                    "type": "number"
+                },
+                "Limit": {
+                    "description": "packets, computed from ceil and delay if not set",
+                    "type": "integer"
+                },
+                "Loss": {
+                    "description": "%",
+                    "type": "number"
+                },
+                "Reorder": {
+                    "description": "%, requires Delay",
+                    "type": "number"
+                }
+            }
+        },
+        "internal.Op": {
+            "type": "object",
+            "properties": {
+                "Action": {
+                    "description": "add, change, replace, set or delete",
+                    "type": "string"
+                },
+                "Command": {
+                    "type": "string"
+                },
+                "Link": {
+                    "type": "string"
+                },
+                "Object": {
+                    "description": "link, address, qdisc, class or filter",
+                    "type": "string"
+                }
+            }
+        },
+        "internal.Remark": {
+            "type": "object",
+            "properties": {
+                "Dscp": {
+                    "description": "DSCP of the ipv4 header, 0-63. It is copied to the outer header if the\nvxlan interface inherits tos.",
+                    "type": "integer"
+                },
+                "Pcp": {
+                    "description": "802.1p priority, 0-7. It is set as skb priority, which the vlan device\nof the underlay maps to PCP with its egress-qos-map.",
+                    "type": "integer"
+                }
+            }
+        },
+        "internal.TrafficStats": {
+            "type": "object",
+            "properties": {
+                "Backlog": {
+                    "description": "bytes",
+                    "type": "integer"
+                },
+                "ByteRate": {
+                    "type": "integer"
+                },
+                "Bytes": {
+                    "type": "integer"
+                },
+                "Drops": {
+                    "type": "integer"
+                },
+                "Overlimits": {
+                    "type": "integer"
+                },
+                "PacketRate": {
+                    "type": "integer"
+                },
+                "Packets": {
+                    "type": "integer"
+                },
+                "Qlen": {
+                    "description": "packets",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.AdmissionResponse": {
+            "type": "object",
+            "properties": {
+                "allocated": {
+                    "type": "integer"
+                },
+                "capacity": {
+                    "type": "integer"
+                },
+                "direction": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "remaining": {
+                    "type": "integer"
+                },
+                "requested": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.BridgeResponse": {
+            "type": "object",
+            "properties": {
+                "Addrs": {
+                    "description": "ipv4 and ipv6 addresses in cidr",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Mac": {
+                    "type": "string"
+                },
+                "Managed": {
+                    "description": "TN-Manager owns the bridge: a vxlan bridge it created or adopted, a\nbridge created by the bridge api, or a bridge it connected with a veth\npair",
+                    "type": "boolean"
+                },
+                "Mtu": {
+                    "type": "integer"
+                },
+                "Name": {
+                    "type": "string"
+                },
+                "OperState": {
+                    "description": "Operational state, e.g. up, down, lower-layer-down or unknown",
+                    "type": "string"
+                },
+                "Ports": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.BridgePort"
+                    }
+                },
+                "VxlanInterface": {
+                    "description": "Vxlan interface of managed bridges",
+                    "type": "string"
+                }
+            }
+        },
+        "main.DeadLetter": {
+            "type": "object",
+            "properties": {
+                "Attempts": {
+                    "type": "integer"
+                },
+                "LastError": {
+                    "type": "string"
+                },
+                "Notification": {
+                    "$ref": "#/definitions/main.Notification"
+                },
+                "Time": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DiscoveredSlice": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "type": "string"
+                },
+                "ClassId": {
+                    "type": "integer"
+                },
+                "DownlinkCeil": {
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "type": "integer"
+                },
+                "IfbInterface": {
+                    "type": "string"
+                },
+                "Matches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Match"
+                    }
+                },
+                "Netem": {
+                    "$ref": "#/definitions/internal.NetemSpec"
+                },
+                "ParentClassId": {
+                    "type": "integer"
+                },
+                "Prio": {
+                    "type": "integer"
+                },
+                "Remark": {
+                    "$ref": "#/definitions/internal.Remark"
+                },
+                "Tenant": {
+                    "description": "Tenant the class is nested under, with the class ID of the tenant",
+                    "type": "string"
+                },
+                "UplinkCeil": {
+                    "type": "integer"
+                },
+                "UplinkClassId": {
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "type": "integer"
+                },
+                "VxlanInterface": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DiscoveryItem": {
+            "type": "object",
+            "properties": {
+                "Handle": {
+                    "type": "string"
+                },
+                "Link": {
+                    "type": "string"
+                },
+                "Object": {
+                    "description": "link, class, filter, or store, bridge, veth, tenant, slice, e2eslice for a record",
+                    "type": "string"
+                },
+                "Reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DiscoveryReport": {
+            "type": "object",
+            "properties": {
+                "Bridges": {
+                    "description": "Bridges with a vxlan interface adopted into the bridge registry",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Restored": {
+                    "description": "Records of the state store found in kernel, \u003cbridge\u003e, veth \u003cname\u003e,\ntenant \u003cbridge\u003e/\u003ctenant ID\u003e, slice \u003cbridge\u003e/\u003cS-NSSAI\u003e or e2eslice\n\u003cS-NSSAI\u003e",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Slices": {
+                    "description": "Slice classes found in kernel, kept apart as their S-NSSAI is not\nknown, see DiscoveredSlice",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DiscoveredSlice"
+                    }
+                },
+                "Stale": {
+                    "description": "Records of the state store not found in kernel, they are dropped",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DiscoveryItem"
+                    }
+                },
+                "Tenants": {
+                    "description": "Tenants adopted, \u003cbridge\u003e/\u003ctenant ID\u003e",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Time": {
+                    "type": "string"
+                },
+                "Unmapped": {
+                    "description": "Kernel objects which could not be mapped to a bridge, tenant or slice",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DiscoveryItem"
+                    }
+                }
+            }
+        },
+        "main.DryRunResponse": {
+            "type": "object",
+            "properties": {
+                "DryRun": {
+                    "type": "boolean"
+                },
+                "Ops": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Op"
+                    }
+                }
+            }
+        },
+        "main.E2eHop": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "description": "Vxlan bridge, the slice is installed on its vxlan interface",
+                    "type": "string"
+                },
+                "Interface": {
+                    "description": "Interface the slice is installed on",
+                    "type": "string"
+                }
+            }
+        },
+        "main.E2eHopStatus": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "description": "Vxlan bridge, the slice is installed on its vxlan interface",
+                    "type": "string"
+                },
+                "Interface": {
+                    "description": "Interface the slice is installed on",
+                    "type": "string"
+                },
+                "Message": {
+                    "type": "string"
+                },
+                "Slice": {
+                    "$ref": "#/definitions/main.Slice"
+                },
+                "Status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.E2eSliceRequest": {
+            "type": "object",
+            "properties": {
+                "Burst": {
+                    "description": "Burst and cburst (bytes) of slice classes, computed from rate and ceil if not set",
+                    "type": "integer"
+                },
+                "Cburst": {
+                    "type": "integer"
+                },
+                "DownlinkCeil": {
+                    "description": "Downlink ceil (KB/Sec), defaults to downlink rate",
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "description": "Downlink rate (KB/Sec), defaults to FlowRate",
+                    "type": "integer"
+                },
+                "DstIP": {
+                    "type": "string"
+                },
+                "FlowRate": {
+                    "description": "Downlink rate (KB/Sec), kept for compatibility with DownlinkRate",
+                    "type": "integer"
+                },
+                "Matches": {
+                    "description": "Additional match rules sharing the slice class",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Match"
+                    }
+                },
+                "Netem": {
+                    "description": "Latency, jitter and loss emulation of the slice",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.NetemSpec"
+                        }
+                    ]
+                },
+                "Path": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.E2eHop"
+                    }
+                },
+                "Prio": {
+                    "description": "Priority to borrow idle bandwidth, 0 (highest) - 7",
+                    "type": "integer"
+                },
+                "Profile": {
+                    "description": "Name of the slice profile, fields set in the request override the profile",
+                    "type": "string"
+                },
+                "Remark": {
+                    "description": "DSCP / 802.1p rewrite of downlink traffic of the slice",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.Remark"
+                        }
+                    ]
+                },
+                "SST": {
+                    "description": "S-NSSAI of the slice, SST defaults to the SST of Profile, or 1 (eMBB)\nfor the clients of slices before SST",
+                    "type": "integer"
+                },
+                "SliceSD": {
+                    "type": "string"
+                },
+                "SrcIP": {
+                    "type": "string"
+                },
+                "UplinkCeil": {
+                    "description": "Uplink ceil (KB/Sec), defaults to uplink rate",
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "description": "Uplink rate (KB/Sec), uplink is not shaped if not set",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.E2eSliceStatus": {
+            "type": "object",
+            "properties": {
+                "Hops": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.E2eHopStatus"
+                    }
+                },
+                "SST": {
+                    "type": "integer"
+                },
+                "SliceSD": {
+                    "type": "string"
+                },
+                "Status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Event": {
+            "type": "object",
+            "properties": {
+                "Addr": {
+                    "description": "Address in cidr of address events, or ip of neighbor events",
+                    "type": "string"
+                },
+                "Bridge": {
+                    "description": "Bridge of port events",
+                    "type": "string"
+                },
+                "Id": {
+                    "type": "integer"
+                },
+                "Kind": {
+                    "description": "Kind of link, e.g. bridge, vxlan, veth, ifb or device",
+                    "type": "string"
+                },
+                "Link": {
+                    "type": "string"
+                },
+                "Mac": {
+                    "description": "MAC of neighbor events",
+                    "type": "string"
+                },
+                "Message": {
+                    "type": "string"
+                },
+                "Time": {
+                    "type": "string"
+                },
+                "Type": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.InterfaceRequest": {
+            "type": "object",
+            "properties": {
+                "bridge1": {
+                    "type": "string"
+                },
+                "bridge2": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Notification": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "description": "Bridge, or interface of the slices on interfaces",
+                    "type": "string"
+                },
+                "Id": {
+                    "description": "Id of the notification, the same on every delivery attempt",
+                    "type": "string"
+                },
+                "Message": {
+                    "description": "The error of failures, the drift or kernel event of degradations",
+                    "type": "string"
+                },
+                "Snssai": {
+                    "description": "S-NSSAI of slice notifications",
+                    "type": "string"
+                },
+                "Tenant": {
+                    "description": "ID of tenant notifications",
+                    "type": "string"
+                },
+                "Time": {
+                    "type": "string"
+                },
+                "Type": {
+                    "description": "bridge.created, bridge.deleted, bridge.failed, bridge.degraded,\nslice.created, slice.updated, slice.deleted, slice.failed,\nslice.degraded, tenant.created, tenant.deleted, tenant.failed or\ntenant.degraded",
+                    "type": "string"
+                }
+            }
+        },
+        "main.ReconcileAction": {
+            "type": "object",
+            "properties": {
+                "Action": {
+                    "description": "Change made, empty if the drift can not be fixed",
+                    "type": "string"
+                },
+                "Drift": {
+                    "description": "What differs from the recorded state",
+                    "type": "string"
+                },
+                "Error": {
+                    "type": "string"
+                },
+                "Link": {
+                    "type": "string"
+                },
+                "Name": {
+                    "description": "\u003cbridge\u003e, \u003cinterface\u003e, \u003cbridge\u003e/\u003ctenant ID\u003e or \u003cbridge\u003e/\u003cS-NSSAI\u003e",
+                    "type": "string"
+                },
+                "Object": {
+                    "description": "bridge, interface, tenant or slice",
+                    "type": "string"
+                }
+            }
+        },
+        "main.ReconcileReport": {
+            "type": "object",
+            "properties": {
+                "Actions": {
+                    "description": "Drifts found, empty if kernel is in the recorded state",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ReconcileAction"
+                    }
+                },
+                "DryRun": {
+                    "type": "boolean"
+                },
+                "Ops": {
+                    "description": "Planned changes of dry run",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Op"
+                    }
+                },
+                "Time": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Schedule": {
+            "type": "object",
+            "properties": {
+                "Action": {
+                    "$ref": "#/definitions/main.ScheduleAction"
+                },
+                "At": {
+                    "type": "string"
+                },
+                "Bridge": {
+                    "type": "string"
+                },
+                "Executions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScheduleExecution"
+                    }
+                },
+                "Id": {
+                    "type": "string"
+                },
+                "Restore": {
+                    "$ref": "#/definitions/main.SliceRequest"
+                },
+                "RestoreTenant": {
+                    "description": "Tenant the deactivated slice is restored under",
+                    "type": "string"
+                },
+                "Revert": {
+                    "description": "Undo of update and deactivate, captured from the slice at the start",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.SliceUpdateRequest"
+                        }
+                    ]
+                },
+                "SNSSAI": {
+                    "type": "string"
+                },
+                "Slice": {
+                    "$ref": "#/definitions/main.SliceRequest"
+                },
+                "Until": {
+                    "type": "string"
+                },
+                "Update": {
+                    "$ref": "#/definitions/main.SliceUpdateRequest"
+                }
+            }
+        },
+        "main.ScheduleAction": {
+            "type": "string",
+            "enum": [
+                "activate",
+                "update",
+                "deactivate"
+            ],
+            "x-enum-varnames": [
+                "ScheduleActivate",
+                "ScheduleUpdate",
+                "ScheduleDeactivate"
+            ]
+        },
+        "main.ScheduleExecution": {
+            "type": "object",
+            "properties": {
+                "Action": {
+                    "$ref": "#/definitions/main.ScheduleAction"
+                },
+                "Bridge": {
+                    "type": "string"
+                },
+                "Executed": {
+                    "type": "string"
+                },
+                "Message": {
+                    "type": "string"
+                },
+                "Phase": {
+                    "type": "string"
+                },
+                "Planned": {
+                    "type": "string"
+                },
+                "Result": {
+                    "type": "string"
+                },
+                "SNSSAI": {
+                    "type": "string"
+                },
+                "ScheduleId": {
                     "type": "string"
                 }
             }
         },
-        "main.InterfaceRequest": {
+        "main.ScheduleOverview": {
             "type": "object",
             "properties": {
-                "bridge1": {
+                "Past": {
+                    "description": "Sorted by executed time, latest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScheduleExecution"
+                    }
+                },
+                "Upcoming": {
+                    "description": "Sorted by planned time",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScheduleExecution"
+                    }
+                }
+            }
+        },
+        "main.ScheduleRequest": {
+            "type": "object",
+            "properties": {
+                "Action": {
+                    "$ref": "#/definitions/main.ScheduleAction"
+                },
+                "At": {
+                    "description": "Time to apply the action (RFC 3339)",
                     "type": "string"
                 },
-                "bridge2": {
+                "Slice": {
+                    "description": "Slice to install, for activate. SST and SliceSD are taken from path",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.SliceRequest"
+                        }
+                    ]
+                },
+                "Until": {
+                    "description": "Time to undo the action (RFC 3339), kept if not set",
+                    "type": "string"
+                },
+                "Update": {
+                    "description": "Changes of the slice, for update",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.SliceUpdateRequest"
+                        }
+                    ]
+                }
+            }
+        },
+        "main.Slice": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "type": "string"
+                },
+                "Burst": {
+                    "type": "integer"
+                },
+                "Cburst": {
+                    "type": "integer"
+                },
+                "ClassId": {
+                    "type": "integer"
+                },
+                "DownlinkCeil": {
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "type": "integer"
+                },
+                "DstIP": {
+                    "type": "string"
+                },
+                "EndToEnd": {
+                    "description": "S-NSSAI of the end-to-end slice the slice is a hop of",
+                    "type": "string"
+                },
+                "FilterHandles": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "FilterPrio": {
+                    "type": "integer"
+                },
+                "FlowRate": {
+                    "type": "integer"
+                },
+                "IfbInterface": {
+                    "type": "string"
+                },
+                "Matches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Match"
+                    }
+                },
+                "Netem": {
+                    "description": "Latency and impairments of each shaped direction",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.NetemSpec"
+                        }
+                    ]
+                },
+                "ParentClassId": {
+                    "type": "integer"
+                },
+                "Prio": {
+                    "type": "integer"
+                },
+                "Profile": {
+                    "type": "string"
+                },
+                "Remark": {
+                    "description": "DSCP / 802.1p rewrite of downlink traffic",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.Remark"
+                        }
+                    ]
+                },
+                "SST": {
+                    "type": "integer"
+                },
+                "SliceSD": {
+                    "type": "string"
+                },
+                "SrcIP": {
+                    "type": "string"
+                },
+                "Tenant": {
+                    "description": "Tenant the slice is nested under, with the class IDs of the tenant",
+                    "type": "string"
+                },
+                "UplinkCeil": {
+                    "type": "integer"
+                },
+                "UplinkClassId": {
+                    "type": "integer"
+                },
+                "UplinkFilterHandles": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "UplinkFilterPrio": {
+                    "type": "integer"
+                },
+                "UplinkParentClassId": {
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "type": "integer"
+                },
+                "VxlanInterface": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.SliceProfile": {
+            "type": "object",
+            "properties": {
+                "DownlinkCeil": {
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "description": "Rates (KB/Sec), ceil defaults to the rate",
+                    "type": "integer"
+                },
+                "Dscp": {
+                    "description": "Dscp of match rules which do not set one, 0-63",
+                    "type": "integer"
+                },
+                "Name": {
                     "type": "string"
+                },
+                "Netem": {
+                    "$ref": "#/definitions/internal.NetemSpec"
+                },
+                "Prio": {
+                    "description": "Priority to borrow idle bandwidth, 0 (highest) - 7",
+                    "type": "integer"
+                },
+                "SST": {
+                    "description": "Slice/service type, 1-255",
+                    "type": "integer"
+                },
+                "UplinkCeil": {
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "type": "integer"
                 }
             }
         },
         "main.SliceRequest": {
             "type": "object",
             "properties": {
+                "Burst": {
+                    "description": "Burst and cburst (bytes) of slice classes, computed from rate and ceil if not set",
+                    "type": "integer"
+                },
+                "Cburst": {
+                    "type": "integer"
+                },
+                "DownlinkCeil": {
+                    "description": "Downlink ceil (KB/Sec), defaults to downlink rate",
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "description": "Downlink rate (KB/Sec), defaults to FlowRate",
+                    "type": "integer"
+                },
                 "DstIP": {
                     "type": "string"
                 },
                 "FlowRate": {
+                    "description": "Downlink rate (KB/Sec), kept for compatibility with DownlinkRate",
+                    "type": "integer"
+                },
+                "Matches": {
+                    "description": "Additional match rules sharing the slice class",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Match"
+                    }
+                },
+                "Netem": {
+                    "description": "Latency, jitter and loss emulation of the slice",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.NetemSpec"
+                        }
+                    ]
+                },
+                "Prio": {
+                    "description": "Priority to borrow idle bandwidth, 0 (highest) - 7",
+                    "type": "integer"
+                },
+                "Profile": {
+                    "description": "Name of the slice profile, fields set in the request override the profile",
+                    "type": "string"
+                },
+                "Remark": {
+                    "description": "DSCP / 802.1p rewrite of downlink traffic of the slice",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.Remark"
+                        }
+                    ]
+                },
+                "SST": {
+                    "description": "S-NSSAI of the slice, SST defaults to the SST of Profile, or 1 (eMBB)\nfor the clients of slices before SST",
+                    "type": "integer"
+                },
+                "SliceSD": {
+                    "type": "string"
+                },
+                "SrcIP": {
+                    "type": "string"
+                },
+                "UplinkCeil": {
+                    "description": "Uplink ceil (KB/Sec), defaults to uplink rate",
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "description": "Uplink rate (KB/Sec), uplink is not shaped if not set",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.SliceStats": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "type": "string"
+                },
+                "Downlink": {
+                    "$ref": "#/definitions/internal.TrafficStats"
+                },
+                "SST": {
                     "type": "integer"
                 },
                 "SliceSD": {
                     "type": "string"
                 },
+                "Uplink": {
+                    "description": "Not set if uplink is not shaped",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.TrafficStats"
+                        }
+                    ]
+                }
+            }
+        },
+        "main.SliceUpdateRequest": {
+            "type": "object",
+            "properties": {
+                "Burst": {
+                    "type": "integer"
+                },
+                "Cburst": {
+                    "type": "integer"
+                },
+                "DownlinkCeil": {
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "type": "integer"
+                },
+                "DstIP": {
+                    "type": "string"
+                },
+                "Matches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/internal.Match"
+                    }
+                },
+                "Netem": {
+                    "description": "Netem of all zero values removes the emulation",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.NetemSpec"
+                        }
+                    ]
+                },
+                "Prio": {
+                    "type": "integer"
+                },
+                "Remark": {
+                    "description": "Remark without Dscp and Pcp removes the rewrite",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/internal.Remark"
+                        }
+                    ]
+                },
+                "RemoveUplink": {
+                    "description": "Remove the uplink shaping, UplinkRate and UplinkCeil are ignored",
+                    "type": "boolean"
+                },
                 "SrcIP": {
                     "type": "string"
+                },
+                "UplinkCeil": {
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.Subscription": {
+            "type": "object",
+            "properties": {
+                "Bridges": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Created": {
+                    "type": "string"
+                },
+                "DeadLetters": {
+                    "type": "integer"
+                },
+                "Delivered": {
+                    "description": "Notifications delivered, queued and dead lettered",
+                    "type": "integer"
+                },
+                "Events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "Id": {
+                    "type": "string"
+                },
+                "Pending": {
+                    "type": "integer"
+                },
+                "Signed": {
+                    "type": "boolean"
+                },
+                "Url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.SubscriptionRequest": {
+            "type": "object",
+            "properties": {
+                "Bridges": {
+                    "description": "Bridges selected, empty selects all",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "br0"
+                    ]
+                },
+                "Events": {
+                    "description": "Notification types, bridge.*, slice.* or tenant.* selects a group,\nempty selects all",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "bridge.*",
+                        "slice.failed"
+                    ]
+                },
+                "Secret": {
+                    "description": "Key of the X-TN-Signature HMAC-SHA256 of the body, no signature if empty",
+                    "type": "string"
+                },
+                "Url": {
+                    "description": "http or https URL the notifications are posted to",
+                    "type": "string",
+                    "example": "http://10.0.0.5:9000/tn-events"
+                }
+            }
+        },
+        "main.TenantRequest": {
+            "type": "object",
+            "properties": {
+                "DownlinkCeil": {
+                    "description": "Aggregate downlink limit (KB/Sec), defaults to downlink rate",
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "description": "Aggregate guaranteed downlink rate (KB/Sec) of the slices of the tenant",
+                    "type": "integer"
+                },
+                "Prio": {
+                    "description": "Priority to borrow idle bandwidth, 0 (highest) - 7",
+                    "type": "integer"
+                },
+                "UplinkCeil": {
+                    "description": "Aggregate uplink limit (KB/Sec), defaults to uplink rate",
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "description": "Aggregate uplink rate (KB/Sec), uplink of the slices is not shaped if not set",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.TenantResponse": {
+            "type": "object",
+            "properties": {
+                "Bridge": {
+                    "type": "string"
+                },
+                "ClassId": {
+                    "type": "integer"
+                },
+                "Discovered": {
+                    "description": "Adopted from kernel at startup, the ID is named after the class ID",
+                    "type": "boolean"
+                },
+                "DownlinkAllocated": {
+                    "description": "Guaranteed rates of the slices of the tenant, and of the slice classes\nfound under it at startup",
+                    "type": "integer"
+                },
+                "DownlinkCeil": {
+                    "type": "integer"
+                },
+                "DownlinkRate": {
+                    "type": "integer"
+                },
+                "Id": {
+                    "type": "string"
+                },
+                "IfbInterface": {
+                    "type": "string"
+                },
+                "Prio": {
+                    "type": "integer"
+                },
+                "Slices": {
+                    "description": "S-NSSAI of the slices of the tenant",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "UplinkAllocated": {
+                    "type": "integer"
+                },
+                "UplinkCeil": {
+                    "type": "integer"
+                },
+                "UplinkClassId": {
+                    "type": "integer"
+                },
+                "UplinkRate": {
+                    "type": "integer"
+                },
+                "VxlanInterface": {
+                    "type": "string"
                 }
             }
         },
@@ -422,6 +3028,10 @@ const docTemplate = `{
                 "bindInterface": {
                     "type": "string"
                 },
+                "capacity": {
+                    "description": "Capacity (KB/Sec) shared by slices, defaults to -capacity or the link speed of bindInterface",
+                    "type": "integer"
+                },
                 "localBrIp": {
                     "type": "string"
                 },
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// DryRunResponse reports the kernel changes a request would make, in order.
+// Nothing is changed and nothing is recorded.
+type DryRunResponse struct {
+	DryRun bool          `json:"DryRun"`
+	Ops    []internal.Op `json:"Ops"`
+}
+
+// dryRunPlan returns a plan if the request has dryRun=true, or nil. It
+// responds 400 and returns false if dryRun is not a boolean.
+func dryRunPlan(c *gin.Context) (*internal.Plan, bool) {
+	value, ok := c.GetQuery("dryRun")
+	if !ok {
+		return nil, true
+	}
+
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid dryRun, true or false is required")
+		return nil, false
+	}
+	if !dryRun {
+		return nil, true
+	}
+
+	return internal.NewPlan(), true
+}
+
+// kernel returns plan as the kernel to change, or the kernel of the host if
+// plan is nil
+func kernel(plan *internal.Plan) internal.Kernel {
+	if plan == nil {
+		return internal.Live
+	}
+	return plan
+}
+
+// isPlan reports whether k only records the changes of a dry run
+func isPlan(k internal.Kernel) bool {
+	_, ok := k.(*internal.Plan)
+	return ok
+}
+
+// respondPlan responds the changes recorded in plan
+func respondPlan(c *gin.Context, plan *internal.Plan) {
+	c.JSON(http.StatusOK, DryRunResponse{DryRun: true, Ops: plan.Ops})
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vishvananda/netlink"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Status of an end-to-end slice and its hops
+const (
+	e2eInstalled = "installed"
+	e2eDegraded  = "degraded"
+	hopInstalled = "installed"
+	hopMissing   = "missing"
+)
+
+// E2eHop is a hop on the path of an end-to-end slice, either a vxlan bridge or
+// an interface such as the veth pair between bridges
+type E2eHop struct {
+	// Vxlan bridge, the slice is installed on its vxlan interface
+	Bridge string `json:"Bridge,omitempty"`
+	// Interface the slice is installed on
+	Interface string `json:"Interface,omitempty"`
+}
+
+// E2eSliceRequest represents the request body for the addE2eSlice endpoint.
+// The slice fields apply to every hop.
+type E2eSliceRequest struct {
+	SliceRequest
+	Path []E2eHop `json:"Path"`
+}
+
+// E2eSlice records a slice installed on every hop of a path. The slice of each
+// hop is recorded in SliceMap under the hop name.
+type E2eSlice struct {
+	Sst     uint8    `json:"SST"`
+	SliceSd string   `json:"SliceSD,omitempty"`
+	Path    []E2eHop `json:"Path"`
+}
+
+// E2eSliceStatus reports an end-to-end slice with the status of each hop
+type E2eSliceStatus struct {
+	Sst     uint8          `json:"SST"`
+	SliceSd string         `json:"SliceSD,omitempty"`
+	Status  string         `json:"Status"`
+	Hops    []E2eHopStatus `json:"Hops"`
+}
+
+// E2eHopStatus reports the slice of a hop
+type E2eHopStatus struct {
+	E2eHop
+	Status  string `json:"Status"`
+	Slice   *Slice `json:"Slice,omitempty"`
+	Message string `json:"Message,omitempty"`
+}
+
+// Map S-NSSAI to end-to-end slice, guarded by sliceLock
+var E2eSliceMap map[string]*E2eSlice = make(map[string]*E2eSlice)
+
+// name returns the key of the hop in SliceMap
+func (hop E2eHop) name() string {
+	if hop.Bridge != "" {
+		return hop.Bridge
+	}
+	return hop.Interface
+}
+
+// link returns the interface the slice of hop is installed on.
+// The caller must hold sliceLock.
+func (hop E2eHop) link() (string, error) {
+	if (hop.Bridge == "") == (hop.Interface == "") {
+		return "", errors.New("each hop should set one of Bridge and Interface")
+	}
+
+	if hop.Bridge != "" {
+		vxlanInterface, ok := BridgeMap[hop.Bridge]
+		if !ok {
+			return "", fmt.Errorf("vxlan bridge %s not existed", hop.Bridge)
+		}
+		return vxlanInterface, nil
+	}
+
+	if _, err := netlink.LinkByName(hop.Interface); err != nil {
+		return "", fmt.Errorf("interface %s not found", hop.Interface)
+	}
+	if _, ok := BridgeMap[hop.Interface]; ok {
+		return "", fmt.Errorf("%s is a vxlan bridge, set it as Bridge", hop.Interface)
+	}
+	return hop.Interface, nil
+}
+
+// Snssai returns the S-NSSAI of e2e
+func (e2e *E2eSlice) Snssai() Snssai {
+	return Snssai{Sst: e2e.Sst, Sd: e2e.SliceSd}
+}
+
+// status checks the slice of every hop of e2e is recorded and its class is
+// on the interface. The caller must hold sliceLock.
+func (e2e *E2eSlice) status() E2eSliceStatus {
+	status := E2eSliceStatus{
+		Sst:     e2e.Sst,
+		SliceSd: e2e.SliceSd,
+		Status:  e2eInstalled,
+		Hops:    []E2eHopStatus{},
+	}
+
+	for _, hop := range e2e.Path {
+		hopStatus := E2eHopStatus{E2eHop: hop, Status: hopInstalled}
+
+		slice, ok := SliceMap[hop.name()][e2e.Snssai().String()]
+		if !ok {
+			hopStatus.Status, hopStatus.Message = hopMissing, "Slice not recorded"
+		} else if _, err := internal.ClassStats(slice.VxlanInterface, slice.ClassId); err != nil {
+			hopStatus.Status, hopStatus.Message = hopMissing, err.Error()
+		}
+		hopStatus.Slice = slice
+
+		if hopStatus.Status != hopInstalled {
+			status.Status = e2eDegraded
+		}
+		status.Hops = append(status.Hops, hopStatus)
+	}
+
+	return status
+}
+
+// createE2eSlice installs the slice of request on every hop of the path. All
+// hops are admitted before any is installed, and installed hops are removed
+// if a later hop fails, so the slice is on all hops or none. If plan is not
+// nil, the changes are recorded in plan instead.
+func createE2eSlice(request E2eSliceRequest, plan *internal.Plan) (*E2eSlice, error) {
+	k := kernel(plan)
+
+	if len(request.Path) == 0 {
+		return nil, newSliceError(http.StatusBadRequest, "Path is required")
+	}
+
+	var profile *SliceProfile
+	if request.Profile != "" {
+		var ok bool
+		if profile, ok = lookupProfile(request.Profile); !ok {
+			return nil, newSliceError(http.StatusBadRequest, "Profile not found")
+		}
+	}
+
+	template, err := request.newSlice(profile)
+	if err != nil {
+		return nil, newSliceError(http.StatusBadRequest, err.Error())
+	}
+	if len(template.Matches) == 0 {
+		return nil, newSliceError(http.StatusBadRequest, "DstIP, SrcIP or Matches is required")
+	}
+	if err := internal.ValidateMatches(template.Matches); err != nil {
+		return nil, newSliceError(http.StatusBadRequest, fmt.Sprintf("Invalid match: %s", err.Error()))
+	}
+	if err := template.validateRate(); err != nil {
+		return nil, newSliceError(http.StatusBadRequest, err.Error())
+	}
+
+	snssai := template.Snssai().String()
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	if _, exist := E2eSliceMap[snssai]; exist {
+		return nil, newSliceError(http.StatusConflict, "End-to-end slice existed")
+	}
+
+	// Plan the slice of every hop
+	slices := []*Slice{}
+	links := make(map[string]bool)
+	for _, hop := range request.Path {
+		link, err := hop.link()
+		if err != nil {
+			return nil, newSliceError(http.StatusBadRequest, fmt.Sprintf("Invalid hop: %s", err.Error()))
+		}
+		if links[link] {
+			return nil, newSliceError(http.StatusBadRequest, fmt.Sprintf("Invalid hop: %s is on the path twice", link))
+		}
+		links[link] = true
+
+		if _, exist := SliceMap[hop.name()][snssai]; exist {
+			return nil, newSliceError(http.StatusConflict, fmt.Sprintf("Slice existed on %s", hop.name()))
+		}
+
+		slice := *template
+		slice.Matches = append([]internal.Match{}, template.Matches...)
+		slice.Bridge = hop.name()
+		slice.VxlanInterface = link
+		slice.EndToEnd = snssai
+		if err := slice.setTenant(); err != nil {
+			return nil, err
+		}
+
+		if rejected := admitSlice(&slice); rejected != nil {
+			rejected.Message = fmt.Sprintf("%s on %s", rejected.Message, hop.name())
+			sysLogger.Println("Reject end-to-end slice: ", rejected.Message)
+			notifySlice(plan, NotifySliceFailed, hop.name(), snssai, rejected.Message)
+			return nil, &sliceError{status: http.StatusConflict, message: rejected.Message, body: rejected}
+		}
+		slices = append(slices, &slice)
+	}
+
+	for i, slice := range slices {
+		sysLogger.Println("Add end-to-end slice on interface, ", slice.VxlanInterface)
+		if err := installSlice(k, slice); err != nil {
+			sysLogger.Println("Failed to install end-to-end slice: ", err)
+			notifySlice(plan, NotifySliceFailed, slice.Bridge, snssai, fmt.Sprintf("Failed to install slice: %s", err.Error()))
+
+			// Roll back the installed hops
+			for j := i - 1; j >= 0; j-- {
+				if err := removeSlice(k, slices[j]); err != nil {
+					sysLogger.Println("Failed to roll back end-to-end slice on ", slices[j].Bridge, err)
+				}
+			}
+			return nil, newSliceError(http.StatusInternalServerError, fmt.Sprintf("Failed to install slice on %s: %s", slice.Bridge, err.Error()))
+		}
+	}
+
+	e2e := &E2eSlice{
+		Sst:     template.Sst,
+		SliceSd: template.SliceSd,
+		Path:    request.Path,
+	}
+	if plan != nil {
+		return e2e, nil
+	}
+
+	for _, slice := range slices {
+		if SliceMap[slice.Bridge] == nil {
+			SliceMap[slice.Bridge] = make(map[string]*Slice)
+		}
+		SliceMap[slice.Bridge][snssai] = slice
+	}
+	E2eSliceMap[snssai] = e2e
+	saveState()
+	for _, slice := range slices {
+		notifySlice(nil, NotifySliceCreated, slice.Bridge, snssai, "")
+	}
+
+	sysLogger.Println("Install end-to-end slice successful, ", "S-NSSAI", snssai, "Hops", len(slices))
+	return e2e, nil
+}
+
+// deleteE2eSlice removes the slice from every hop. The end-to-end slice is
+// kept if any hop fails to be removed, so the deletion can be retried on the
+// remaining hops. If plan is not nil, the changes are recorded in plan instead.
+func deleteE2eSlice(id string, plan *internal.Plan) error {
+	k := kernel(plan)
+
+	snssai, ok, err := parseSnssai(id)
+	if err != nil || !ok {
+		return newSliceError(http.StatusBadRequest, "Invalid S-NSSAI: <SST>-<SD> or <SST> is required")
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	e2e, exist := E2eSliceMap[snssai.String()]
+	if !exist {
+		return newSliceError(http.StatusNotFound, "End-to-end slice not found")
+	}
+
+	failed := 0
+	for i := len(e2e.Path) - 1; i >= 0; i-- {
+		hop := e2e.Path[i]
+		slice, ok := SliceMap[hop.name()][snssai.String()]
+		if !ok {
+			// gone with its bridge
+			continue
+		}
+
+		if err := removeSlice(k, slice); err != nil {
+			sysLogger.Println("Failed to delete end-to-end slice on ", hop.name(), err)
+			notifySlice(plan, NotifySliceFailed, hop.name(), snssai.String(), fmt.Sprintf("Failed to delete slice: %s", err.Error()))
+			failed++
+			continue
+		}
+		if plan != nil {
+			continue
+		}
+		delete(SliceMap[hop.name()], snssai.String())
+		notifySlice(nil, NotifySliceDeleted, hop.name(), snssai.String(), "")
+	}
+
+	if plan != nil {
+		return nil
+	}
+	if failed > 0 {
+		saveState()
+		return newSliceError(http.StatusInternalServerError, fmt.Sprintf("Failed to delete slice on %d hops", failed))
+	}
+
+	delete(E2eSliceMap, snssai.String())
+	saveState()
+	return nil
+}
+
+// addE2eSlice handles the POST /api/v1/e2eslice endpoint.
+// It installs the slice on every hop of the path, or none of them.
+//
+// @Summary Add end-to-end slice
+// @Description
+// @Tags e2eslice
+// @Accept json
+// @Produce json
+// @Param request body E2eSliceRequest true "End-to-end slice request"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 201 {object} E2eSliceStatus
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 409 {object} AdmissionResponse "Slice existed, or not enough bandwidth"
+// @Failure 500 {string} string "Failed to install slice, all hops rolled back"
+// @Router /api/v1/e2eslice [post]
+func addE2eSlice(c *gin.Context) {
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	var request E2eSliceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	e2e, err := createE2eSlice(request, plan)
+	if err != nil {
+		respondSliceError(c, err)
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	c.JSON(http.StatusCreated, e2e.status())
+}
+
+// listE2eSlice handles the GET /api/v1/e2eslice endpoint.
+// It lists end-to-end slices with the status of each hop.
+//
+// @Summary List end-to-end slices
+// @Description
+// @Tags e2eslice
+// @Produce json
+// @Success 200 {array} E2eSliceStatus
+// @Router /api/v1/e2eslice [get]
+func listE2eSlice(c *gin.Context) {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	statuses := []E2eSliceStatus{}
+	for _, e2e := range E2eSliceMap {
+		statuses = append(statuses, e2e.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return Snssai{statuses[i].Sst, statuses[i].SliceSd}.less(Snssai{statuses[j].Sst, statuses[j].SliceSd})
+	})
+
+	c.JSON(http.StatusOK, statuses)
+}
+
+// retrieveE2eSlice handles the GET /api/v1/e2eslice/:snssai endpoint.
+// It retrieve the end-to-end slice with the status of each hop.
+//
+// @Summary Retrieve end-to-end slice
+// @Description
+// @Tags e2eslice
+// @Produce json
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Success 200 {object} E2eSliceStatus
+// @Failure 404 {string} string "End-to-end slice not found"
+// @Router /api/v1/e2eslice/{snssai} [get]
+func retrieveE2eSlice(c *gin.Context) {
+	snssai, ok, err := parseSnssai(c.Param("snssai"))
+	if err != nil || !ok {
+		c.String(http.StatusBadRequest, "Invalid S-NSSAI: <SST>-<SD> or <SST> is required")
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	e2e, exist := E2eSliceMap[snssai.String()]
+	if !exist {
+		c.String(http.StatusNotFound, "End-to-end slice not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, e2e.status())
+}
+
+// delE2eSlice handles the DELETE /api/v1/e2eslice/:snssai endpoint.
+// It removes the slice from every hop.
+//
+// @Summary Delete end-to-end slice
+// @Description
+// @Tags e2eslice
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 204 {string} string "End-to-end slice deleted"
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 404 {string} string "End-to-end slice not found"
+// @Failure 500 {string} string "Failed to delete slice, the failed hops are kept"
+// @Router /api/v1/e2eslice/{snssai} [delete]
+func delE2eSlice(c *gin.Context) {
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	if err := deleteE2eSlice(c.Param("snssai"), plan); err != nil {
+		respondSliceError(c, err)
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	c.String(http.StatusNoContent, "End-to-end slice deleted")
+}
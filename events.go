@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Recent events kept to resume event streams
+const eventHistory = 256
+
+// Events buffered for a stream, a stream which falls behind is closed and
+// resumes from the recent events when the client reconnects
+const eventStreamBuffer = 64
+
+// Interval of the comments which keep idle event streams open
+const eventKeepalive = 15 * time.Second
+
+// Event is a link, address or neighbor change of the host. Id orders the
+// events since TN-Manager started.
+type Event struct {
+	Id   uint64    `json:"Id"`
+	Time time.Time `json:"Time"`
+	internal.Event
+}
+
+// eventHub keeps the recent events and fans them out to the event streams
+type eventHub struct {
+	lock    sync.Mutex
+	lastId  uint64
+	recent  []Event
+	streams map[chan Event]bool
+}
+
+var events = &eventHub{streams: make(map[chan Event]bool)}
+
+// runEvents publishes the events of kernel, until the process exits
+func runEvents() {
+	ch := make(chan internal.Event, eventStreamBuffer)
+	go internal.WatchEvents(ch, nil)
+
+	for event := range ch {
+		events.publish(event)
+		notifyEvent(event)
+	}
+}
+
+func (hub *eventHub) publish(kernelEvent internal.Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	hub.lastId++
+	event := Event{
+		Id:    hub.lastId,
+		Time:  time.Now(),
+		Event: kernelEvent,
+	}
+
+	hub.recent = append(hub.recent, event)
+	if len(hub.recent) > eventHistory {
+		hub.recent = hub.recent[len(hub.recent)-eventHistory:]
+	}
+
+	for stream := range hub.streams {
+		select {
+		case stream <- event:
+		default:
+			delete(hub.streams, stream)
+			close(stream)
+		}
+	}
+}
+
+// subscribe opens a stream of the events from now on, and returns the recent
+// events after lastId. No recent event is returned if lastId is 0.
+func (hub *eventHub) subscribe(lastId uint64) (chan Event, []Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	backlog := []Event{}
+	for _, event := range hub.recent {
+		if lastId != 0 && event.Id > lastId {
+			backlog = append(backlog, event)
+		}
+	}
+
+	stream := make(chan Event, eventStreamBuffer)
+	hub.streams[stream] = true
+	return stream, backlog
+}
+
+func (hub *eventHub) unsubscribe(stream chan Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	if hub.streams[stream] {
+		delete(hub.streams, stream)
+		close(stream)
+	}
+}
+
+// eventFilter selects events by type and link, an empty list selects all
+type eventFilter struct {
+	types map[string]bool
+	links map[string]bool
+}
+
+// parseEventFilter reads the comma separated type and link query
+func parseEventFilter(c *gin.Context) (eventFilter, error) {
+	filter := eventFilter{
+		types: queryList(c, "type"),
+		links: queryList(c, "link"),
+	}
+
+	for eventType := range filter.types {
+		if !contains(internal.EventTypes, eventType) {
+			return filter, fmt.Errorf("unknown event type %s, one of %s is required", eventType, strings.Join(internal.EventTypes, ", "))
+		}
+	}
+	return filter, nil
+}
+
+func queryList(c *gin.Context, key string) map[string]bool {
+	values := make(map[string]bool)
+	for _, value := range c.QueryArray(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values[item] = true
+			}
+		}
+	}
+	return values
+}
+
+func contains(list []string, item string) bool {
+	for _, value := range list {
+		if value == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (filter eventFilter) match(event Event) bool {
+	if len(filter.types) > 0 && !filter.types[event.Type] {
+		return false
+	}
+	// Port events also match by bridge
+	if len(filter.links) > 0 && !filter.links[event.Link] && !filter.links[event.Bridge] {
+		return false
+	}
+	return true
+}
+
+// streamEvents handles the GET /api/v1/events endpoint.
+// It streams the link, address and neighbor events of the host.
+//
+// @Summary Stream events
+// @Description Stream the link, address and neighbor events of the host as Server-Sent Events, e.g. link-down when vxlan100 went down or port-removed when bridge br0 lost port veth3. The event name is the event type and the id is Id, a client reconnecting with Last-Event-ID resumes from the recent events kept.
+// @Tags events
+// @Produce text/event-stream
+// @Param type query string false "Comma separated event types: link-added, link-removed, link-up, link-down, port-added, port-removed, addr-added, addr-removed, neigh-added, neigh-removed or neigh-failed"
+// @Param link query string false "Comma separated link names, port events also match the bridge"
+// @Param Last-Event-ID header string false "Id of the last event received"
+// @Success 200 {object} Event
+// @Failure 400 {string} string "Invalid event filter or Last-Event-ID"
+// @Router /api/v1/events [get]
+func streamEvents(c *gin.Context) {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var lastId uint64
+	if value := c.GetHeader("Last-Event-ID"); value != "" {
+		if lastId, err = strconv.ParseUint(value, 10, 64); err != nil {
+			c.String(http.StatusBadRequest, "Invalid Last-Event-ID, event Id is required")
+			return
+		}
+	}
+
+	stream, backlog := events.subscribe(lastId)
+	defer events.unsubscribe(stream)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range backlog {
+		if filter.match(event) {
+			renderEvent(c, event)
+		}
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				// Fell behind, the client resumes with Last-Event-ID
+				return false
+			}
+			if filter.match(event) {
+				renderEvent(c, event)
+			}
+			return true
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func renderEvent(c *gin.Context, event Event) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(event.Id, 10),
+		Event: event.Type,
+		Data:  event,
+	})
+}
@@ -2,11 +2,12 @@ package internal
 
 import (
 	"log"
+	"os"
 
 	"github.com/vishvananda/netlink"
 )
 
-var internalLogger *log.Logger
+var internalLogger *log.Logger = log.New(os.Stdout, "[DEBUG] ", log.LstdFlags)
 
 func GetBridge(bridgeName string) (netlink.Link, error) {
 	bridgeLink, err := netlink.LinkByName(bridgeName)
@@ -34,6 +35,24 @@ func CreateBridge(bridgeName string) (*netlink.Bridge, error) {
 	return bridgeLink, nil
 }
 
+// CreateVeth creates veth pair of vethName and peerName
+func CreateVeth(vethName, peerName string) (*netlink.Veth, error) {
+	vethLink := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: vethName,
+		},
+		PeerName: peerName,
+	}
+
+	err := netlink.LinkAdd(vethLink)
+	if err != nil {
+		internalLogger.Println("Failed to create veth pair:", err)
+		return nil, err
+	}
+
+	return vethLink, nil
+}
+
 func SetBridgeIp(ipv4Addr string, bridgeLink netlink.Link) error {
 	addr, err := netlink.ParseAddr(ipv4Addr)
 	if err != nil {
@@ -63,3 +82,127 @@ func DelBridge(bridgeName string) error {
 
 	return nil
 }
+
+// BridgeInfo is a linux bridge found in kernel, with its ports
+type BridgeInfo struct {
+	Name string `json:"Name"`
+	Mac  string `json:"Mac"`
+	Mtu  int    `json:"Mtu"`
+	// Operational state, e.g. up, down, lower-layer-down or unknown
+	OperState string `json:"OperState"`
+	// ipv4 and ipv6 addresses in cidr
+	Addrs []string     `json:"Addrs"`
+	Ports []BridgePort `json:"Ports"`
+}
+
+// BridgePort is a link enslaved to a bridge
+type BridgePort struct {
+	Name string `json:"Name"`
+	// vxlan, veth, physical, or the link type of other ports, e.g. tuntap
+	Kind      string `json:"Kind"`
+	Mac       string `json:"Mac"`
+	Mtu       int    `json:"Mtu"`
+	OperState string `json:"OperState"`
+	// VNI of vxlan ports
+	VxlanId int `json:"VxlanId,omitempty"`
+	// Underlay device of vxlan ports, empty if not bound
+	Underlay string `json:"Underlay,omitempty"`
+}
+
+// ListBridges reads the linux bridges of kernel with their ports, ordered by
+// link index
+func ListBridges() ([]BridgeInfo, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		internalLogger.Println("Failed to list link, ", err)
+		return nil, err
+	}
+
+	bridges := []BridgeInfo{}
+	for _, link := range links {
+		if _, ok := link.(*netlink.Bridge); !ok {
+			continue
+		}
+		info, err := bridgeInfo(link, links)
+		if err != nil {
+			return nil, err
+		}
+		bridges = append(bridges, *info)
+	}
+	return bridges, nil
+}
+
+// ReadBridge reads bridge with its ports. It returns nil if the bridge does
+// not exist or the link is not a linux bridge.
+func ReadBridge(bridgeName string) (*BridgeInfo, error) {
+	link, err := netlink.LinkByName(bridgeName)
+	if _, notFound := err.(netlink.LinkNotFoundError); notFound {
+		return nil, nil
+	}
+	if err != nil {
+		internalLogger.Println("Failed to get bridge interface: ", err)
+		return nil, err
+	}
+	if _, ok := link.(*netlink.Bridge); !ok {
+		return nil, nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		internalLogger.Println("Failed to list link, ", err)
+		return nil, err
+	}
+	return bridgeInfo(link, links)
+}
+
+// bridgeInfo reads the addresses of bridge, and finds its ports in links
+func bridgeInfo(bridge netlink.Link, links []netlink.Link) (*BridgeInfo, error) {
+	attrs := bridge.Attrs()
+	info := &BridgeInfo{
+		Name:      attrs.Name,
+		Mac:       attrs.HardwareAddr.String(),
+		Mtu:       attrs.MTU,
+		OperState: attrs.OperState.String(),
+		Addrs:     []string{},
+		Ports:     []BridgePort{},
+	}
+
+	addrs, err := netlink.AddrList(bridge, netlink.FAMILY_ALL)
+	if err != nil {
+		internalLogger.Println("Failed to list address, ", err)
+		return nil, err
+	}
+	for _, addr := range addrs {
+		info.Addrs = append(info.Addrs, addr.IPNet.String())
+	}
+
+	names := make(map[int]string)
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	for _, link := range links {
+		portAttrs := link.Attrs()
+		if portAttrs.MasterIndex != attrs.Index {
+			continue
+		}
+
+		port := BridgePort{
+			Name:      portAttrs.Name,
+			Kind:      link.Type(),
+			Mac:       portAttrs.HardwareAddr.String(),
+			Mtu:       portAttrs.MTU,
+			OperState: portAttrs.OperState.String(),
+		}
+		if port.Kind == "device" {
+			port.Kind = "physical"
+		}
+		if vxlan, ok := link.(*netlink.Vxlan); ok {
+			port.VxlanId = vxlan.VxlanId
+			port.Underlay = names[vxlan.VtepDevIndex]
+		}
+		info.Ports = append(info.Ports, port)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Capacity used when it is neither declared nor detected, 1 Gbit/s
+const fallbackCapacity = 125000
+
+// Map link name to its capacity (KB/Sec)
+var linkCapacities map[string]int = make(map[string]int)
+var capacityLock sync.Mutex
+
+// SetLinkCapacity declares the capacity (KB/Sec) of vxlan interface. If
+// capacity is 0, the configured capacity is used, or the link speed of the
+// underlay interface if not configured. It returns the capacity taken.
+func SetLinkCapacity(vxlanName, underlayName string, capacity int) int {
+	if capacity <= 0 {
+		capacity = DefaultLinkConfig.Capacity
+	}
+
+	if capacity <= 0 {
+		speed, err := UnderlaySpeed(underlayName)
+		if err != nil {
+			internalLogger.Println("Failed to detect link speed, ", err)
+			speed = fallbackCapacity
+		}
+		capacity = speed
+	}
+
+	capacityLock.Lock()
+	linkCapacities[vxlanName] = capacity
+	capacityLock.Unlock()
+
+	return capacity
+}
+
+// LinkCapacity returns the capacity (KB/Sec) of link
+func LinkCapacity(linkName string) int {
+	capacityLock.Lock()
+	defer capacityLock.Unlock()
+
+	if capacity, ok := linkCapacities[linkName]; ok {
+		return capacity
+	}
+
+	if DefaultLinkConfig.Capacity > 0 {
+		return DefaultLinkConfig.Capacity
+	}
+	return fallbackCapacity
+}
+
+// Drop the capacity of link, called when the link is deleted
+func forgetLinkCapacity(linkName string) {
+	capacityLock.Lock()
+	defer capacityLock.Unlock()
+
+	delete(linkCapacities, linkName)
+}
+
+// UnderlaySpeed reads the link speed of interface reported by its driver,
+// in KB/Sec
+func UnderlaySpeed(name string) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("underlay interface not specified")
+	}
+
+	data, err := os.ReadFile("/sys/class/net/" + name + "/speed")
+	if err != nil {
+		return 0, err
+	}
+
+	// Mbit/s, -1 if unknown
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, err
+	}
+	if speed <= 0 {
+		return 0, fmt.Errorf("link speed of %s unknown", name)
+	}
+
+	return speed * 1000 / 8, nil
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Minor IDs of slice classes, minor 0 is the root qdisc, 1 the parent class
+// and ffff the default class
+const (
+	minClassId uint16 = ParentClassId + 1
+	maxClassId uint16 = defaultClassId - 1
+)
+
+// classAllocator hands out the class minor IDs of one link
+type classAllocator struct {
+	used map[uint16]bool
+}
+
+// Map link index to its class ID allocator
+var classAllocators map[int]*classAllocator = make(map[int]*classAllocator)
+var allocatorLock sync.Mutex
+
+// Get the allocator of link, the root htb qdisc is created and the classes
+// already in kernel are marked as used on first call
+func linkAllocator(link netlink.Link) (*classAllocator, error) {
+	index := link.Attrs().Index
+	if allocator, ok := classAllocators[index]; ok {
+		return allocator, nil
+	}
+
+	exist, err := hasRootQdisc(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exist {
+		err = CreateRootQdisc(&link)
+		if err != nil {
+			internalLogger.Println("Failed to create root qdisc, ", err)
+			return nil, err
+		}
+	}
+
+	if err := setupLinkTree(link); err != nil {
+		return nil, err
+	}
+
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(1, 0))
+	if err != nil {
+		internalLogger.Println("Failed to list class, ", err)
+		return nil, err
+	}
+
+	allocator := &classAllocator{used: make(map[uint16]bool)}
+	for _, class := range classes {
+		major, minor := netlink.MajorMinor(class.Attrs().Handle)
+		if major == 1 {
+			allocator.used[minor] = true
+		}
+	}
+
+	classAllocators[index] = allocator
+	return allocator, nil
+}
+
+// Check if root htb qdisc 1: exist on link. A root qdisc other than the
+// kernel default (handle 0:) is not taken over
+func hasRootQdisc(link netlink.Link) (bool, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		internalLogger.Println("Failed to list qdisc, ", err)
+		return false, err
+	}
+
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if attrs.Parent != netlink.HANDLE_ROOT || attrs.Handle == 0 {
+			continue
+		}
+
+		if attrs.Handle == netlink.MakeHandle(1, 0) && qdisc.Type() == "htb" {
+			return true, nil
+		}
+
+		return false, fmt.Errorf("root qdisc %s %s already exist on %s",
+			qdisc.Type(), netlink.HandleStr(attrs.Handle), link.Attrs().Name)
+	}
+
+	return false, nil
+}
+
+// Allocate the lowest free class ID
+func (a *classAllocator) allocate() (uint16, error) {
+	for id := minClassId; ; id++ {
+		if !a.used[id] {
+			a.used[id] = true
+			return id, nil
+		}
+		if id == maxClassId {
+			return 0, fmt.Errorf("no free class ID")
+		}
+	}
+}
+
+// Release the class ID for reuse
+func (a *classAllocator) release(id uint16) {
+	delete(a.used, id)
+}
+
+// allocClassId allocates a free class ID on vxlan interface
+func allocClassId(vxlanLink netlink.Link) (uint16, error) {
+	allocatorLock.Lock()
+	defer allocatorLock.Unlock()
+
+	allocator, err := linkAllocator(vxlanLink)
+	if err != nil {
+		return 0, err
+	}
+
+	return allocator.allocate()
+}
+
+// releaseClassId returns the class ID to the allocator of vxlan interface
+func releaseClassId(vxlanLink netlink.Link, classId uint16) {
+	allocatorLock.Lock()
+	defer allocatorLock.Unlock()
+
+	if allocator, ok := classAllocators[vxlanLink.Attrs().Index]; ok {
+		allocator.release(classId)
+	}
+}
+
+// Drop the allocator of link, called when the link is deleted
+func forgetClassIds(link netlink.Link) {
+	allocatorLock.Lock()
+	defer allocatorLock.Unlock()
+
+	delete(classAllocators, link.Attrs().Index)
+}
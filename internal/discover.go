@@ -0,0 +1,381 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// VxlanPort is a vxlan interface enslaved to a bridge, found in kernel
+type VxlanPort struct {
+	Bridge   string
+	Vxlan    string
+	VxlanId  int
+	Underlay string // empty if the vxlan interface is not bound to a device
+	Remote   string // remote or group ip, empty if not set
+}
+
+// LinkTc is the htb hierarchy TN-Manager installs on a link, found in kernel.
+// Rates are in KB/Sec.
+type LinkTc struct {
+	Link string
+	// Rate of the parent class 1:1, 0 if it does not exist
+	Capacity int
+	// The default class 1:ffff exists
+	DefaultClass bool
+	// Slice and tenant classes, without the parent and default class
+	Classes []LinkClass
+	// u32 filters under the root qdisc 1:
+	Filters []LinkFilter
+}
+
+// LinkClass is an htb class 1:ClassId under parent 1:Parent
+type LinkClass struct {
+	ClassId uint16
+	Parent  uint16
+	Rate    int
+	Ceil    int
+	Prio    uint32
+	// Netem leaf qdisc of the class, nil if not set
+	Netem *NetemSpec
+	// Leaf qdisc other than netem, empty if not set
+	Leaf string
+}
+
+// LinkFilter is an u32 filter which classifies traffic into class 1:ClassId
+type LinkFilter struct {
+	Prio    uint16
+	Handle  uint32
+	ClassId uint16
+	// Match of the selector, nil if the selector is not one TN-Manager builds
+	Match *Match
+	// Remark of the actions, only the skbedit priority (Pcp) is read back
+	Remark *Remark
+	// Actions of the filter which can not be read back, the DSCP rewrite
+	// (pedit and csum) is one of them
+	UnknownActions int
+}
+
+// ListVxlanPorts finds the vxlan interfaces enslaved to bridges
+func ListVxlanPorts() ([]VxlanPort, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		internalLogger.Println("Failed to list link, ", err)
+		return nil, err
+	}
+
+	names := make(map[int]netlink.Link)
+	for _, link := range links {
+		names[link.Attrs().Index] = link
+	}
+
+	var ports []VxlanPort
+	for _, link := range links {
+		vxlan, ok := link.(*netlink.Vxlan)
+		if !ok || vxlan.MasterIndex == 0 {
+			continue
+		}
+
+		master, ok := names[vxlan.MasterIndex].(*netlink.Bridge)
+		if !ok {
+			continue
+		}
+
+		port := VxlanPort{
+			Bridge:  master.Name,
+			Vxlan:   vxlan.Name,
+			VxlanId: vxlan.VxlanId,
+		}
+		if underlay, ok := names[vxlan.VtepDevIndex]; ok {
+			port.Underlay = underlay.Attrs().Name
+		}
+		if vxlan.Group != nil {
+			port.Remote = vxlan.Group.String()
+		}
+		ports = append(ports, port)
+	}
+
+	return ports, nil
+}
+
+// IfbOf returns the ifb device managed for vxlan interface, or empty if it
+// does not exist
+func IfbOf(vxlanName string) string {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		return ""
+	}
+
+	name := ifbName(vxlanLink)
+	if _, err := netlink.LinkByName(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// ListTc reads the htb classes, their leaf qdiscs and the u32 filters of
+// link. It returns nil if link has no root htb qdisc 1:.
+func ListTc(linkName string) (*LinkTc, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return nil, err
+	}
+
+	exist, err := hasRootQdisc(link)
+	if err != nil || !exist {
+		return nil, err
+	}
+
+	tc := &LinkTc{Link: linkName}
+
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(1, 0))
+	if err != nil {
+		internalLogger.Println("Failed to list class, ", err)
+		return nil, err
+	}
+
+	for _, class := range classes {
+		htb, ok := class.(*netlink.HtbClass)
+		if !ok {
+			continue
+		}
+
+		major, minor := netlink.MajorMinor(htb.Handle)
+		_, parent := netlink.MajorMinor(htb.Parent)
+		if major != 1 {
+			continue
+		}
+
+		switch minor {
+		case ParentClassId:
+			tc.Capacity = int(htb.Rate / 1000)
+		case defaultClassId:
+			tc.DefaultClass = true
+		default:
+			tc.Classes = append(tc.Classes, LinkClass{
+				ClassId: minor,
+				Parent:  parent,
+				Rate:    int(htb.Rate / 1000),
+				Ceil:    int(htb.Ceil / 1000),
+				Prio:    htb.Prio,
+			})
+		}
+	}
+
+	if err := readLeafQdiscs(link, tc.Classes); err != nil {
+		return nil, err
+	}
+
+	filters, err := netlink.FilterList(link, netlink.MakeHandle(1, 0))
+	if err != nil {
+		internalLogger.Println("Failed to list tc filter, ", err)
+		return nil, err
+	}
+
+	for _, filter := range filters {
+		u32, ok := filter.(*netlink.U32)
+		if !ok || u32.Sel == nil {
+			// hash tables of u32 have no selector
+			continue
+		}
+
+		_, classId := netlink.MajorMinor(u32.ClassId)
+		linkFilter := LinkFilter{
+			Prio:    u32.Priority,
+			Handle:  u32.Handle,
+			ClassId: classId,
+		}
+
+		if match, err := selMatch(u32.Sel); err == nil {
+			linkFilter.Match = match
+		}
+
+		for _, action := range u32.Actions {
+			skbedit, ok := action.(*netlink.SkbEditAction)
+			if ok && skbedit.Priority != nil && *skbedit.Priority <= 7 {
+				pcp := uint8(*skbedit.Priority)
+				linkFilter.Remark = &Remark{Pcp: &pcp}
+				continue
+			}
+			linkFilter.UnknownActions++
+		}
+
+		tc.Filters = append(tc.Filters, linkFilter)
+	}
+
+	return tc, nil
+}
+
+// Read the leaf qdiscs of classes, netem is converted back to its spec
+func readLeafQdiscs(link netlink.Link, classes []LinkClass) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		internalLogger.Println("Failed to list qdisc, ", err)
+		return err
+	}
+
+	for i := range classes {
+		class := &classes[i]
+		for _, qdisc := range qdiscs {
+			if qdisc.Attrs().Parent != netlink.MakeHandle(1, class.ClassId) {
+				continue
+			}
+
+			netem, ok := qdisc.(*netlink.Netem)
+			if !ok {
+				class.Leaf = qdisc.Type()
+				continue
+			}
+			class.Netem = netemSpec(netem, class.Ceil)
+		}
+	}
+
+	return nil
+}
+
+// Convert netem qdisc back to spec, the limit is left to be computed if it
+// is the one computed from ceilRate
+func netemSpec(netem *netlink.Netem, ceilRate int) *NetemSpec {
+	spec := &NetemSpec{
+		Delay:     tickMs(netem.Latency),
+		Jitter:    tickMs(netem.Jitter),
+		Loss:      percentage(netem.Loss),
+		Duplicate: percentage(netem.Duplicate),
+		Reorder:   percentage(netem.ReorderProb),
+	}
+
+	for _, corr := range []uint32{netem.DelayCorr, netem.LossCorr, netem.DuplicateCorr, netem.ReorderCorr} {
+		if corr != 0 {
+			spec.Correlation = percentage(corr)
+			break
+		}
+	}
+
+	if netem.Limit != spec.limit(ceilRate) {
+		spec.Limit = netem.Limit
+	}
+
+	return spec
+}
+
+// Convert kernel ticks to ms
+func tickMs(tick uint32) float64 {
+	us := float64(tick) / netlink.TickInUsec()
+	return math.Round(us) / 1000
+}
+
+// Convert netem probability to %, inverse of netlink.Percentage2u32
+func percentage(prob uint32) float32 {
+	percent := float64(prob) / math.MaxUint32 * 100
+	return float32(math.Round(percent*100) / 100)
+}
+
+// Build the match of u32 selector, inverse of Match.keySets. Port ranges are
+// split into filters, so each filter of a port range gives its own block.
+func selMatch(sel *netlink.TcU32Sel) (*Match, error) {
+	if sel.Offmask != 0 || sel.Offshift != 0 || sel.Off != 0 || sel.Hmask != 0 {
+		return nil, fmt.Errorf("selector with offset or hash not supported")
+	}
+
+	match := &Match{}
+	for _, key := range sel.Keys {
+		if key.OffMask != 0 {
+			return nil, fmt.Errorf("key with variable offset not supported")
+		}
+
+		switch {
+		case key.Mask == 0 && key.Val == 0:
+			// match all ipv4 packets
+		case key.Off == offSrc:
+			addr, err := keyIPv4Net(key)
+			if err != nil {
+				return nil, err
+			}
+			match.SrcIp = addr
+		case key.Off == offDst:
+			addr, err := keyIPv4Net(key)
+			if err != nil {
+				return nil, err
+			}
+			match.DstIp = addr
+		case key.Off == offProto && key.Mask == 0x00ff0000:
+			match.Protocol = protocolName(uint8(key.Val >> 16))
+		case key.Off == offTos && key.Mask == 0x00fc0000:
+			dscp := uint8(key.Val >> 18)
+			match.Dscp = &dscp
+		case key.Off == offPorts:
+			var err error
+			if match.SrcPort, err = portRange(key.Val>>16, key.Mask>>16); err != nil {
+				return nil, err
+			}
+			if match.DstPort, err = portRange(key.Val&0xffff, key.Mask&0xffff); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("key at offset %d with mask %08x not supported", key.Off, key.Mask)
+		}
+	}
+
+	return match, nil
+}
+
+// Format the addr of key as cidr, or addr if it is /32
+func keyIPv4Net(key netlink.TcU32Key) (string, error) {
+	ones := bits.OnesCount32(key.Mask)
+	if ipv4Mask(net.CIDRMask(ones, 32)) != key.Mask {
+		return "", fmt.Errorf("addr mask %08x not contiguous", key.Mask)
+	}
+
+	ip := net.IPv4(byte(key.Val>>24), byte(key.Val>>16), byte(key.Val>>8), byte(key.Val))
+	if ones == 32 {
+		return ip.String(), nil
+	}
+	return fmt.Sprintf("%s/%d", ip.String(), ones), nil
+}
+
+func protocolName(proto uint8) string {
+	for name, number := range protocols {
+		if number == proto {
+			return name
+		}
+	}
+	return fmt.Sprint(proto)
+}
+
+// Format port block val/mask as port or port range, empty if mask is 0
+func portRange(val, mask uint32) (string, error) {
+	switch mask {
+	case 0:
+		return "", nil
+	case 0xffff:
+		return fmt.Sprint(val), nil
+	}
+
+	size := ^mask&0xffff + 1
+	if size&(size-1) != 0 {
+		return "", fmt.Errorf("port mask %04x not contiguous", mask)
+	}
+	return fmt.Sprintf("%d-%d", val, val+size-1), nil
+}
+
+// LinkMaster returns the name of the master of link, or empty if link has no
+// master
+func LinkMaster(linkName string) (string, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		return "", err
+	}
+	if link.Attrs().MasterIndex == 0 {
+		return "", nil
+	}
+
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		return "", err
+	}
+	return master.Attrs().Name, nil
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Matches of one filter read back from the selector of the filter
+func TestSelMatchRoundTrip(t *testing.T) {
+	dscp := uint8(46)
+	tests := []Match{
+		{},
+		{DstIp: "10.0.0.1"},
+		{SrcIp: "192.168.1.0/24", DstIp: "10.0.0.0/8"},
+		{Protocol: "icmp"},
+		{Protocol: "udp", DstPort: "2152", Dscp: &dscp},
+		{Protocol: "tcp", SrcPort: "1000-1001", DstPort: "80-81"},
+		{Protocol: "sctp", SrcPort: "0-65535"},
+		{Protocol: "47", DstIp: "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		sets, err := test.keySets()
+		if err != nil {
+			t.Errorf("%+v: keySets failed: %v", test, err)
+			continue
+		}
+		if len(sets) != 1 {
+			t.Errorf("%+v: keySets = %v, want one filter", test, sets)
+			continue
+		}
+
+		match, err := selMatch(&netlink.TcU32Sel{Flags: netlink.TC_U32_TERMINAL, Keys: sets[0]})
+		if err != nil {
+			t.Errorf("%+v: selMatch failed: %v", test, err)
+			continue
+		}
+		// A port range of all ports matches as no port
+		if test.SrcPort == "0-65535" {
+			test.SrcPort = ""
+		}
+		if !reflect.DeepEqual(*match, test) {
+			t.Errorf("selMatch = %+v, want %+v", *match, test)
+		}
+	}
+}
+
+func TestSelMatchInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		sel  netlink.TcU32Sel
+	}{
+		{name: "hash", sel: netlink.TcU32Sel{Hmask: 0xff}},
+		{name: "variable offset", sel: netlink.TcU32Sel{Keys: []netlink.TcU32Key{{Off: offDst, Mask: 0xffffffff, OffMask: 1}}}},
+		{name: "addr mask", sel: netlink.TcU32Sel{Keys: []netlink.TcU32Key{{Off: offDst, Mask: 0xff00ff00}}}},
+		{name: "port mask", sel: netlink.TcU32Sel{Keys: []netlink.TcU32Key{{Off: offPorts, Mask: 0x0000fff5}}}},
+		{name: "unknown offset", sel: netlink.TcU32Sel{Keys: []netlink.TcU32Key{{Off: 24, Mask: 0xffffffff}}}},
+	}
+
+	for _, test := range tests {
+		if match, err := selMatch(&test.sel); err == nil {
+			t.Errorf("%s: selMatch = %+v, want error", test.name, match)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Types of Event
+const (
+	EventLinkAdded    = "link-added"
+	EventLinkRemoved  = "link-removed"
+	EventLinkUp       = "link-up"
+	EventLinkDown     = "link-down"
+	EventPortAdded    = "port-added"
+	EventPortRemoved  = "port-removed"
+	EventAddrAdded    = "addr-added"
+	EventAddrRemoved  = "addr-removed"
+	EventNeighAdded   = "neigh-added"
+	EventNeighRemoved = "neigh-removed"
+	EventNeighFailed  = "neigh-failed"
+)
+
+// EventTypes are the types of Event
+var EventTypes = []string{
+	EventLinkAdded, EventLinkRemoved, EventLinkUp, EventLinkDown,
+	EventPortAdded, EventPortRemoved,
+	EventAddrAdded, EventAddrRemoved,
+	EventNeighAdded, EventNeighRemoved, EventNeighFailed,
+}
+
+// Event is a link, address or neighbor change of kernel
+type Event struct {
+	Type string `json:"Type"`
+	Link string `json:"Link"`
+	// Kind of link, e.g. bridge, vxlan, veth, ifb or device
+	Kind string `json:"Kind,omitempty"`
+	// Bridge of port events
+	Bridge string `json:"Bridge,omitempty"`
+	// Address in cidr of address events, or ip of neighbor events
+	Addr string `json:"Addr,omitempty"`
+	// MAC of neighbor events
+	Mac     string `json:"Mac,omitempty"`
+	Message string `json:"Message"`
+}
+
+// Links and neighbors last seen by the watcher, to tell the change of an
+// update
+type eventWatcher struct {
+	links  map[int]linkState
+	neighs map[string]uint16
+	synced bool
+}
+
+type linkState struct {
+	name   string
+	kind   string
+	up     bool
+	master int
+}
+
+// WatchEvents subscribes to the link, address and neighbor updates of kernel
+// and sends the changes to ch as events, until done is closed. If kernel
+// drops the subscriptions, e.g. when they overflow, they are made again and
+// the link changes missed in between are sent.
+func WatchEvents(ch chan<- Event, done <-chan struct{}) {
+	w := &eventWatcher{
+		links:  make(map[int]linkState),
+		neighs: make(map[string]uint16),
+	}
+
+	for {
+		err := w.watch(ch, done)
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		internalLogger.Println("Netlink subscription lost, ", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// Watch the updates until done is closed or a subscription is lost
+func (w *eventWatcher) watch(ch chan<- Event, done <-chan struct{}) error {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	errs := make(chan error, 3)
+	onError := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	linkUpdates := make(chan netlink.LinkUpdate, 64)
+	addrUpdates := make(chan netlink.AddrUpdate, 64)
+	neighUpdates := make(chan netlink.NeighUpdate, 64)
+
+	// The subscriptions end when stop is closed, what they still send is
+	// dropped so they are not blocked
+	var drains []func()
+	defer func() {
+		for _, drain := range drains {
+			go drain()
+		}
+	}()
+
+	if err := netlink.LinkSubscribeWithOptions(linkUpdates, stop, netlink.LinkSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return err
+	}
+	drains = append(drains, func() {
+		for range linkUpdates {
+		}
+	})
+
+	if err := netlink.AddrSubscribeWithOptions(addrUpdates, stop, netlink.AddrSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return err
+	}
+	drains = append(drains, func() {
+		for range addrUpdates {
+		}
+	})
+
+	if err := netlink.NeighSubscribeWithOptions(neighUpdates, stop, netlink.NeighSubscribeOptions{ErrorCallback: onError}); err != nil {
+		return err
+	}
+	drains = append(drains, func() {
+		for range neighUpdates {
+		}
+	})
+
+	// Subscribed before the links are read, so no change is missed
+	if err := w.sync(ch); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case update, ok := <-linkUpdates:
+			if !ok {
+				return subscriptionError(errs)
+			}
+			w.link(update, ch)
+		case update, ok := <-addrUpdates:
+			if !ok {
+				return subscriptionError(errs)
+			}
+			w.addr(update, ch)
+		case update, ok := <-neighUpdates:
+			if !ok {
+				return subscriptionError(errs)
+			}
+			w.neigh(update, ch)
+		}
+	}
+}
+
+func subscriptionError(errs <-chan error) error {
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return fmt.Errorf("subscription closed")
+	}
+}
+
+// Read the links and neighbors of kernel. The links changed since the last
+// sync are sent as events, nothing is sent on the first sync.
+func (w *eventWatcher) sync(ch chan<- Event) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		internalLogger.Println("Failed to list link, ", err)
+		return err
+	}
+
+	present := make(map[int]bool)
+	for _, link := range links {
+		present[link.Attrs().Index] = true
+	}
+	for index := range w.links {
+		if !present[index] {
+			w.linkRemoved(index, ch)
+		}
+	}
+	for _, link := range links {
+		if w.synced {
+			w.linkUpdated(link, ch)
+			continue
+		}
+		w.links[link.Attrs().Index] = newLinkState(link)
+	}
+
+	w.neighs = make(map[string]uint16)
+	for _, family := range []int{netlink.FAMILY_ALL, unix.AF_BRIDGE} {
+		neighs, err := netlink.NeighList(0, family)
+		if err != nil {
+			internalLogger.Println("Failed to list neighbor, ", err)
+			return err
+		}
+		for _, neigh := range neighs {
+			w.neighs[neighKey(neigh)] = uint16(neigh.State)
+		}
+	}
+
+	w.synced = true
+	return nil
+}
+
+func newLinkState(link netlink.Link) linkState {
+	attrs := link.Attrs()
+	return linkState{
+		name: attrs.Name,
+		kind: link.Type(),
+		// Links without carrier detection, e.g. vxlan, report unknown
+		up:     attrs.Flags&net.FlagUp != 0 && (attrs.OperState == netlink.OperUp || attrs.OperState == netlink.OperUnknown),
+		master: attrs.MasterIndex,
+	}
+}
+
+// Name of link index, or the index if the link is not known
+func (w *eventWatcher) linkName(index int) string {
+	if state, ok := w.links[index]; ok {
+		return state.name
+	}
+	return fmt.Sprintf("if%d", index)
+}
+
+func (w *eventWatcher) link(update netlink.LinkUpdate, ch chan<- Event) {
+	// Bridge port notifications, the master of port is also in the link
+	// notifications
+	if update.Family == unix.AF_BRIDGE {
+		return
+	}
+
+	if update.Header.Type == unix.RTM_DELLINK {
+		w.linkRemoved(update.Attrs().Index, ch)
+		return
+	}
+	w.linkUpdated(update.Link, ch)
+}
+
+func (w *eventWatcher) linkUpdated(link netlink.Link, ch chan<- Event) {
+	index := link.Attrs().Index
+	state := newLinkState(link)
+	old, known := w.links[index]
+	w.links[index] = state
+
+	if !known {
+		ch <- state.event(EventLinkAdded, fmt.Sprintf("%s %s added", state.kind, state.name))
+		if state.master != 0 {
+			w.portEvent(EventPortAdded, state, state.master, ch)
+		}
+		return
+	}
+
+	if old.up != state.up {
+		if state.up {
+			ch <- state.event(EventLinkUp, fmt.Sprintf("%s came up", state.name))
+		} else {
+			ch <- state.event(EventLinkDown, fmt.Sprintf("%s went down", state.name))
+		}
+	}
+
+	if old.master != state.master {
+		if old.master != 0 {
+			w.portEvent(EventPortRemoved, state, old.master, ch)
+		}
+		if state.master != 0 {
+			w.portEvent(EventPortAdded, state, state.master, ch)
+		}
+	}
+}
+
+func (w *eventWatcher) linkRemoved(index int, ch chan<- Event) {
+	old, known := w.links[index]
+	if !known {
+		return
+	}
+
+	if old.master != 0 {
+		w.portEvent(EventPortRemoved, old, old.master, ch)
+	}
+	delete(w.links, index)
+	ch <- old.event(EventLinkRemoved, fmt.Sprintf("%s %s removed", old.kind, old.name))
+}
+
+func (w *eventWatcher) portEvent(eventType string, port linkState, master int, ch chan<- Event) {
+	bridge := w.linkName(master)
+	event := port.event(eventType, fmt.Sprintf("bridge %s gained port %s", bridge, port.name))
+	if eventType == EventPortRemoved {
+		event.Message = fmt.Sprintf("bridge %s lost port %s", bridge, port.name)
+	}
+	event.Bridge = bridge
+	ch <- event
+}
+
+func (state linkState) event(eventType, message string) Event {
+	return Event{
+		Type:    eventType,
+		Link:    state.name,
+		Kind:    state.kind,
+		Message: message,
+	}
+}
+
+func (w *eventWatcher) addr(update netlink.AddrUpdate, ch chan<- Event) {
+	event := Event{
+		Type: EventAddrAdded,
+		Link: w.linkName(update.LinkIndex),
+		Addr: update.LinkAddress.String(),
+	}
+	if state, ok := w.links[update.LinkIndex]; ok {
+		event.Kind = state.kind
+	}
+
+	if update.NewAddr {
+		event.Message = fmt.Sprintf("address %s added on %s", event.Addr, event.Link)
+	} else {
+		event.Type = EventAddrRemoved
+		event.Message = fmt.Sprintf("address %s removed from %s", event.Addr, event.Link)
+	}
+	ch <- event
+}
+
+// Neighbors are keyed by ip, or by MAC for the bridge fdb entries without ip
+func neighKey(neigh netlink.Neigh) string {
+	if neigh.IP != nil {
+		return fmt.Sprintf("%d/%d/%s", neigh.LinkIndex, neigh.Family, neigh.IP)
+	}
+	return fmt.Sprintf("%d/%d/%s/%d", neigh.LinkIndex, neigh.Family, neigh.HardwareAddr, neigh.Vlan)
+}
+
+// Neighbor updates are sent when a neighbor is added, removed or fails, the
+// refreshes of its state are not
+func (w *eventWatcher) neigh(update netlink.NeighUpdate, ch chan<- Event) {
+	neigh := update.Neigh
+	key := neighKey(neigh)
+
+	event := Event{
+		Link: w.linkName(neigh.LinkIndex),
+		Mac:  neigh.HardwareAddr.String(),
+	}
+	if state, ok := w.links[neigh.LinkIndex]; ok {
+		event.Kind = state.kind
+	}
+	if neigh.IP != nil {
+		event.Addr = neigh.IP.String()
+	}
+	name := event.Addr
+	if name == "" {
+		name = event.Mac
+	}
+
+	old, known := w.neighs[key]
+	switch {
+	case update.Type == unix.RTM_DELNEIGH:
+		delete(w.neighs, key)
+		event.Type = EventNeighRemoved
+		event.Message = fmt.Sprintf("neighbor %s removed from %s", name, event.Link)
+	case neigh.State&netlink.NUD_FAILED != 0:
+		w.neighs[key] = uint16(neigh.State)
+		if known && old&netlink.NUD_FAILED != 0 {
+			return
+		}
+		event.Type = EventNeighFailed
+		event.Message = fmt.Sprintf("neighbor %s on %s failed", name, event.Link)
+	default:
+		w.neighs[key] = uint16(neigh.State)
+		if known {
+			return
+		}
+		event.Type = EventNeighAdded
+		event.Message = fmt.Sprintf("neighbor %s added on %s", name, event.Link)
+	}
+	ch <- event
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// Htb hierarchy on a link:
+//
+//	1:     root htb qdisc, unclassified traffic goes to 1:ffff
+//	1:1    parent class sized to link capacity
+//	1:N    slice and tenant classes, borrow idle bandwidth of 1:1 up to their
+//	       ceil. Classes of slices of a tenant are under the tenant class.
+//	1:ffff default class
+const (
+	// ParentClassId is the minor ID of the parent class 1:1
+	ParentClassId  uint16 = 1
+	defaultClassId uint16 = 0xffff
+)
+
+// LinkConfig sizes the htb hierarchy of a link, rates are in KB/Sec
+type LinkConfig struct {
+	// Rate and ceil of parent class, if not set the link speed of underlay
+	// interface is taken, see SetLinkCapacity
+	Capacity int
+	// Guaranteed rate of default class
+	DefaultRate int
+	// Ceil of default class, defaults to Capacity
+	DefaultCeil int
+}
+
+// Config of the links TN-Manager shapes
+var DefaultLinkConfig LinkConfig = LinkConfig{
+	DefaultRate: 125,
+}
+
+// ClassSpec describes the htb class of a slice
+type ClassSpec struct {
+	// Guaranteed rate (KB/Sec)
+	Rate int
+	// Ceil (KB/Sec), defaults to Rate
+	Ceil int
+	// Bytes can be sent at ceil speed, computed from rate if not set
+	Burst uint32
+	// Bytes can be sent at link speed, computed from ceil if not set
+	Cburst uint32
+	// Priority to borrow idle bandwidth, 0 (highest) - 7
+	Prio uint32
+	// Minor ID of the parent class, the parent class 1:1 if not set
+	Parent uint16
+}
+
+// Minor ID of the parent class of spec
+func (spec ClassSpec) parent() uint16 {
+	if spec.Parent == 0 {
+		return ParentClassId
+	}
+	return spec.Parent
+}
+
+// Build htb class 1:classId under parent 1:parentId
+func newHtbClass(link netlink.Link, classId, parentId uint16, spec ClassSpec) *netlink.HtbClass {
+	ceil := spec.Ceil
+	if ceil == 0 {
+		ceil = spec.Rate
+	}
+
+	classAttr := netlink.ClassAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    netlink.MakeHandle(1, classId),
+		Parent:    netlink.MakeHandle(1, parentId),
+	}
+
+	htbClassAttr := netlink.HtbClassAttrs{
+		Rate:    uint64(spec.Rate * 8 * 1000),
+		Ceil:    uint64(ceil * 8 * 1000),
+		Buffer:  spec.Burst,
+		Cbuffer: spec.Cburst,
+	}
+
+	class := netlink.NewHtbClass(classAttr, htbClassAttr)
+	class.Prio = spec.Prio
+	// Let kernel compute quantum from rate
+	class.Quantum = 0
+
+	return class
+}
+
+// Create or update the parent class and default class of link
+func setupLinkTree(link netlink.Link) error {
+	config := DefaultLinkConfig
+	capacity := LinkCapacity(link.Attrs().Name)
+
+	// Parent of 1:1 is the root qdisc 1:0
+	parent := newHtbClass(link, ParentClassId, 0, ClassSpec{Rate: capacity})
+	if err := netlink.ClassReplace(parent); err != nil {
+		internalLogger.Println("Failed to create parent class: ", err)
+		return err
+	}
+
+	defaultCeil := config.DefaultCeil
+	if defaultCeil == 0 {
+		defaultCeil = capacity
+	}
+
+	defaultClass := newHtbClass(link, defaultClassId, ParentClassId, ClassSpec{
+		Rate: config.DefaultRate,
+		Ceil: defaultCeil,
+		Prio: 7,
+	})
+	if err := netlink.ClassReplace(defaultClass); err != nil {
+		internalLogger.Println("Failed to create default class: ", err)
+		return err
+	}
+
+	return nil
+}
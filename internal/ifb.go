@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Ingress traffic of a vxlan interface is redirected to an ifb device, where
+// the uplink htb classes of slices are attached
+
+// Priority of the ingress filter which redirects all packets to ifb
+const ingressRedirectPrio uint16 = 1
+
+// Name of the ifb device managed for vxlan interface
+func ifbName(vxlanLink netlink.Link) string {
+	return fmt.Sprintf("tnifb%d", vxlanLink.Attrs().Index)
+}
+
+// SetupIfb creates the ifb device of vxlan interface, and redirects ingress
+// traffic of vxlan interface to it. It returns the ifb device name.
+func SetupIfb(vxlanName string) (string, error) {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return "", err
+	}
+
+	name := ifbName(vxlanLink)
+
+	// Uplink shares the capacity of vxlan interface
+	capacity := LinkCapacity(vxlanName)
+	capacityLock.Lock()
+	linkCapacities[name] = capacity
+	capacityLock.Unlock()
+
+	ifbLink, err := netlink.LinkByName(name)
+	if err != nil {
+		ifbLink = &netlink.Ifb{
+			LinkAttrs: netlink.LinkAttrs{
+				Name: name,
+			},
+		}
+
+		if err := netlink.LinkAdd(ifbLink); err != nil {
+			internalLogger.Println("Failed to create ifb device:", err)
+			return "", err
+		}
+	}
+
+	if err := netlink.LinkSetUp(ifbLink); err != nil {
+		internalLogger.Println("Failed to activate ifb device:", err)
+		return "", err
+	}
+
+	exist, err := hasIngressRedirect(vxlanLink, ifbLink)
+	if err != nil || exist {
+		return name, err
+	}
+
+	ingress := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: vxlanLink.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+
+	if err := netlink.QdiscReplace(ingress); err != nil {
+		internalLogger.Println("Failed to create ingress qdisc:", err)
+		return "", err
+	}
+
+	// u32 filter without selector matches all packets
+	redirect := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: vxlanLink.Attrs().Index,
+			Parent:    netlink.MakeHandle(0xffff, 0),
+			Priority:  ingressRedirectPrio,
+			Protocol:  syscall.ETH_P_ALL,
+		},
+		Actions: []netlink.Action{
+			netlink.NewMirredAction(ifbLink.Attrs().Index),
+		},
+	}
+
+	if err := netlink.FilterAdd(redirect); err != nil {
+		internalLogger.Println("Failed to redirect ingress traffic to ifb:", err)
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Check if ingress traffic of vxlan interface is redirected to ifb device
+func hasIngressRedirect(vxlanLink, ifbLink netlink.Link) (bool, error) {
+	filters, err := netlink.FilterList(vxlanLink, netlink.MakeHandle(0xffff, 0))
+	if err != nil {
+		// ingress qdisc not exist
+		return false, nil
+	}
+
+	for _, filter := range filters {
+		u32, ok := filter.(*netlink.U32)
+		if ok && u32.RedirIndex == ifbLink.Attrs().Index {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// TeardownIfb removes the ingress redirect of vxlan interface and deletes its
+// ifb device, it undoes SetupIfb
+func TeardownIfb(vxlanName string) error {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return err
+	}
+
+	qdiscs, err := netlink.QdiscList(vxlanLink)
+	if err != nil {
+		internalLogger.Println("Failed to list qdisc, ", err)
+		return err
+	}
+	for _, qdisc := range qdiscs {
+		if qdisc.Type() != "ingress" {
+			continue
+		}
+		// The redirect filter is gone with the ingress qdisc
+		if err := netlink.QdiscDel(qdisc); err != nil {
+			internalLogger.Println("Failed to delete ingress qdisc, ", err)
+			return err
+		}
+	}
+
+	return delIfb(vxlanLink)
+}
+
+// Del the ifb device of vxlan interface, the uplink classes are gone with it
+func delIfb(vxlanLink netlink.Link) error {
+	return DelIfb(ifbName(vxlanLink))
+}
+
+// DelIfb deletes ifb device name, which may be left behind by a vxlan
+// interface deleted outside of TN-Manager. It does nothing if the device does
+// not exist.
+func DelIfb(name string) error {
+	ifbLink, err := netlink.LinkByName(name)
+	if err != nil {
+		// ifb device not exist
+		return nil
+	}
+	if ifbLink.Type() != "ifb" {
+		return fmt.Errorf("%s is not an ifb device", name)
+	}
+
+	if err := netlink.LinkDel(ifbLink); err != nil {
+		internalLogger.Println("Failed to delete ifb device:", err)
+		return err
+	}
+
+	forgetClassIds(ifbLink)
+	forgetLinkCapacity(ifbLink.Attrs().Name)
+	return nil
+}
+
+// ReverseMatches swaps source and destination of matches, so downlink
+// matches select the uplink traffic of the same flows
+func ReverseMatches(matches []Match) []Match {
+	reversed := make([]Match, len(matches))
+	for i, match := range matches {
+		reversed[i] = match
+		reversed[i].SrcIp, reversed[i].DstIp = match.DstIp, match.SrcIp
+		reversed[i].SrcPort, reversed[i].DstPort = match.DstPort, match.SrcPort
+	}
+
+	return reversed
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// Kernel makes the link, address, qdisc, class and filter changes of a
+// request. Live makes them on the host, a Plan records them for dry run.
+type Kernel interface {
+	CreateBridge(bridgeName string) error
+	CreateVxlan(vxlanName, vxlanId, localIp, remoteIp string) error
+	CreateVeth(vethName, peerName string) error
+	SetMaster(linkName, masterName string) error
+	SetBridgeIp(ipv4Addr, bridgeName string) error
+	LinkSetUp(linkName string) error
+	SetVxlanDown(vxlanName string) error
+	DelBridge(bridgeName string) error
+	DelVxlan(vxlanName string) error
+
+	AddQdisc(linkName string, spec ClassSpec) (uint16, error)
+	ChangeQdisc(linkName string, classId uint16, spec ClassSpec) error
+	DelQdisc(linkName string, classId uint16) error
+	// Set up the root htb qdisc and the parent and default class of link
+	SetupLinkTree(linkName string) error
+	// Add htb class 1:classId of spec back, or change it if it exists
+	RestoreQdisc(linkName string, classId uint16, spec ClassSpec) error
+	AddFilter(linkName string, matches []Match, classId uint16, remark *Remark) ([]uint32, uint16, error)
+	ReplaceFilter(linkName string, matches []Match, classId, prio uint16, handles []uint32, remark *Remark) ([]uint32, error)
+	DelFilter(linkName string, prio uint16) error
+	SetNetem(linkName string, classId uint16, spec *NetemSpec, ceilRate int) error
+	SetupIfb(vxlanName string) (string, error)
+	DelIfb(ifbName string) error
+	// Remove the ingress redirect of vxlan interface and its ifb device
+	TeardownIfb(vxlanName string) error
+}
+
+// Live changes the kernel of the host
+var Live Kernel = liveKernel{}
+
+type liveKernel struct{}
+
+func (liveKernel) CreateBridge(bridgeName string) error {
+	_, err := CreateBridge(bridgeName)
+	return err
+}
+
+func (liveKernel) CreateVxlan(vxlanName, vxlanId, localIp, remoteIp string) error {
+	_, err := CreateVxlan(vxlanName, vxlanId, localIp, remoteIp)
+	return err
+}
+
+func (liveKernel) CreateVeth(vethName, peerName string) error {
+	_, err := CreateVeth(vethName, peerName)
+	return err
+}
+
+func (liveKernel) SetMaster(linkName, masterName string) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return err
+	}
+
+	master, err := netlink.LinkByName(masterName)
+	if err != nil {
+		internalLogger.Println("Failed to get master, ", err)
+		return err
+	}
+
+	if err := netlink.LinkSetMaster(link, master); err != nil {
+		internalLogger.Println("Failed to set master:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (liveKernel) SetBridgeIp(ipv4Addr, bridgeName string) error {
+	bridgeLink, err := GetBridge(bridgeName)
+	if err != nil {
+		internalLogger.Println("Failed to get bridge interface: ", err)
+		return err
+	}
+
+	return SetBridgeIp(ipv4Addr, bridgeLink)
+}
+
+func (liveKernel) LinkSetUp(linkName string) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return err
+	}
+
+	return netlink.LinkSetUp(link)
+}
+
+func (liveKernel) SetVxlanDown(vxlanName string) error {
+	return SetVxlanDown(vxlanName)
+}
+
+func (liveKernel) DelBridge(bridgeName string) error {
+	return DelBridge(bridgeName)
+}
+
+func (liveKernel) DelVxlan(vxlanName string) error {
+	return DelVxlan(vxlanName)
+}
+
+func (liveKernel) AddQdisc(linkName string, spec ClassSpec) (uint16, error) {
+	return AddQdisc(linkName, spec)
+}
+
+func (liveKernel) ChangeQdisc(linkName string, classId uint16, spec ClassSpec) error {
+	return ChangeQdisc(linkName, classId, spec)
+}
+
+func (liveKernel) DelQdisc(linkName string, classId uint16) error {
+	return DelQdisc(linkName, classId)
+}
+
+func (liveKernel) SetupLinkTree(linkName string) error {
+	return SetupLinkTree(linkName)
+}
+
+func (liveKernel) RestoreQdisc(linkName string, classId uint16, spec ClassSpec) error {
+	return RestoreQdisc(linkName, classId, spec)
+}
+
+func (liveKernel) AddFilter(linkName string, matches []Match, classId uint16, remark *Remark) ([]uint32, uint16, error) {
+	return AddFilter(linkName, matches, classId, remark)
+}
+
+func (liveKernel) ReplaceFilter(linkName string, matches []Match, classId, prio uint16, handles []uint32, remark *Remark) ([]uint32, error) {
+	return ReplaceFilter(linkName, matches, classId, prio, handles, remark)
+}
+
+func (liveKernel) DelFilter(linkName string, prio uint16) error {
+	return DelFilter(linkName, prio)
+}
+
+func (liveKernel) SetNetem(linkName string, classId uint16, spec *NetemSpec, ceilRate int) error {
+	return SetNetem(linkName, classId, spec, ceilRate)
+}
+
+func (liveKernel) SetupIfb(vxlanName string) (string, error) {
+	return SetupIfb(vxlanName)
+}
+
+func (liveKernel) DelIfb(ifbName string) error {
+	return DelIfb(ifbName)
+}
+
+func (liveKernel) TeardownIfb(vxlanName string) error {
+	return TeardownIfb(vxlanName)
+}
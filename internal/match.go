@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Match selects the ipv4 packets classified into a slice. Empty fields match any.
+type Match struct {
+	SrcIp    string `json:"SrcIP,omitempty"`    // ipv4 addr or cidr
+	DstIp    string `json:"DstIP,omitempty"`    // ipv4 addr or cidr
+	Protocol string `json:"Protocol,omitempty"` // tcp, udp, sctp, icmp or ip protocol number
+	SrcPort  string `json:"SrcPort,omitempty"`  // port or port range, e.g. 2152 or 8000-8080
+	DstPort  string `json:"DstPort,omitempty"`  // port or port range
+	Dscp     *uint8 `json:"Dscp,omitempty"`     // 0-63
+}
+
+// Offsets of the 32-bit words in ipv4 header matched by u32 keys
+const (
+	offTos   = 0  // version, ihl, tos, total length
+	offProto = 8  // ttl, protocol, checksum
+	offSrc   = 12 // source addr
+	offDst   = 16 // destination addr
+	offPorts = 20 // l4 source and destination port, assuming no ip options
+)
+
+// Port ranges are split into value/mask blocks, one u32 filter per combination
+const maxMatchFilters = 64
+
+var protocols = map[string]uint8{
+	"icmp": 1,
+	"tcp":  6,
+	"udp":  17,
+	"sctp": 132,
+}
+
+type portBlock struct {
+	val  uint32
+	mask uint32
+}
+
+// Build the u32 key sets of the match, each set is installed as one filter
+func (m Match) keySets() ([][]netlink.TcU32Key, error) {
+	keys := make(map[int32]*netlink.TcU32Key)
+	addKey := func(off int32, mask, val uint32) {
+		key, ok := keys[off]
+		if !ok {
+			key = &netlink.TcU32Key{Off: off}
+			keys[off] = key
+		}
+		key.Mask |= mask
+		key.Val |= val & mask
+	}
+
+	if m.SrcIp != "" {
+		src, err := parseIPv4Net(m.SrcIp)
+		if err != nil {
+			return nil, err
+		}
+		addKey(offSrc, ipv4Mask(src.Mask), iPToUint32(src.IP))
+	}
+
+	if m.DstIp != "" {
+		dst, err := parseIPv4Net(m.DstIp)
+		if err != nil {
+			return nil, err
+		}
+		addKey(offDst, ipv4Mask(dst.Mask), iPToUint32(dst.IP))
+	}
+
+	var proto uint8
+	if m.Protocol != "" {
+		var err error
+		proto, err = parseProtocol(m.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		addKey(offProto, 0x00ff0000, uint32(proto)<<16)
+	}
+
+	if m.Dscp != nil {
+		if *m.Dscp > 63 {
+			return nil, fmt.Errorf("invalid dscp %d", *m.Dscp)
+		}
+		addKey(offTos, 0x00fc0000, uint32(*m.Dscp)<<18)
+	}
+
+	srcPorts := []portBlock{{}}
+	dstPorts := []portBlock{{}}
+	if m.SrcPort != "" || m.DstPort != "" {
+		if proto != 6 && proto != 17 && proto != 132 {
+			return nil, fmt.Errorf("port match requires protocol tcp, udp or sctp")
+		}
+
+		var err error
+		if m.SrcPort != "" {
+			if srcPorts, err = parsePortRange(m.SrcPort); err != nil {
+				return nil, err
+			}
+		}
+		if m.DstPort != "" {
+			if dstPorts, err = parsePortRange(m.DstPort); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(srcPorts)*len(dstPorts) > maxMatchFilters {
+		return nil, fmt.Errorf("port ranges expand to more than %d filters", maxMatchFilters)
+	}
+
+	var keySets [][]netlink.TcU32Key
+	for _, sport := range srcPorts {
+		for _, dport := range dstPorts {
+			set := []netlink.TcU32Key{}
+			for _, off := range []int32{offTos, offProto, offSrc, offDst} {
+				if key, ok := keys[off]; ok {
+					set = append(set, *key)
+				}
+			}
+
+			mask := sport.mask<<16 | dport.mask
+			if mask != 0 {
+				set = append(set, netlink.TcU32Key{
+					Off:  offPorts,
+					Mask: mask,
+					Val:  sport.val<<16 | dport.val,
+				})
+			}
+
+			if len(set) == 0 {
+				// match all ipv4 packets
+				set = append(set, netlink.TcU32Key{})
+			}
+			keySets = append(keySets, set)
+		}
+	}
+
+	return keySets, nil
+}
+
+func parseProtocol(protocol string) (uint8, error) {
+	if proto, ok := protocols[strings.ToLower(protocol)]; ok {
+		return proto, nil
+	}
+
+	proto, err := strconv.ParseUint(protocol, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol %s", protocol)
+	}
+
+	return uint8(proto), nil
+}
+
+// Parse port or port range, and split it into value/mask blocks
+func parsePortRange(ports string) ([]portBlock, error) {
+	bounds := strings.SplitN(ports, "-", 2)
+
+	lo, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", ports)
+	}
+
+	hi := lo
+	if len(bounds) == 2 {
+		hi, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 16)
+		if err != nil || hi < lo {
+			return nil, fmt.Errorf("invalid port range %s", ports)
+		}
+	}
+
+	var blocks []portBlock
+	for start := uint32(lo); start <= uint32(hi); {
+		size := uint32(1)
+		for size < 0x10000 && start&(size*2-1) == 0 && start+size*2-1 <= uint32(hi) {
+			size *= 2
+		}
+
+		blocks = append(blocks, portBlock{val: start, mask: ^(size - 1) & 0xffff})
+		start += size
+	}
+
+	return blocks, nil
+}
+
+// Validate the matches, so the request can be rejected before touching kernel
+func ValidateMatches(matches []Match) error {
+	for _, match := range matches {
+		if _, err := match.keySets(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		ports  string
+		blocks []portBlock
+		err    bool
+	}{
+		{ports: "2152", blocks: []portBlock{{val: 2152, mask: 0xffff}}},
+		{ports: "8000-8007", blocks: []portBlock{{val: 8000, mask: 0xfff8}}},
+		{ports: "8000-8010", blocks: []portBlock{
+			{val: 8000, mask: 0xfff8},
+			{val: 8008, mask: 0xfffe},
+			{val: 8010, mask: 0xffff},
+		}},
+		{ports: "0-65535", blocks: []portBlock{{val: 0, mask: 0}}},
+		{ports: " 80 - 81 ", blocks: []portBlock{{val: 80, mask: 0xfffe}}},
+		{ports: "http", err: true},
+		{ports: "65536", err: true},
+		{ports: "81-80", err: true},
+		{ports: "80-", err: true},
+	}
+
+	for _, test := range tests {
+		blocks, err := parsePortRange(test.ports)
+		if test.err {
+			if err == nil {
+				t.Errorf("parsePortRange(%q) = %v, want error", test.ports, blocks)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePortRange(%q) failed: %v", test.ports, err)
+			continue
+		}
+		if !reflect.DeepEqual(blocks, test.blocks) {
+			t.Errorf("parsePortRange(%q) = %v, want %v", test.ports, blocks, test.blocks)
+		}
+	}
+}
+
+func TestKeySets(t *testing.T) {
+	dscp := uint8(46)
+	tests := []struct {
+		name  string
+		match Match
+		sets  [][]netlink.TcU32Key
+	}{
+		{
+			name:  "match all",
+			match: Match{},
+			sets:  [][]netlink.TcU32Key{{{}}},
+		},
+		{
+			name:  "dst addr",
+			match: Match{DstIp: "10.0.0.1"},
+			sets:  [][]netlink.TcU32Key{{{Off: offDst, Mask: 0xffffffff, Val: 0x0a000001}}},
+		},
+		{
+			name:  "src cidr",
+			match: Match{SrcIp: "192.168.1.7/24"},
+			sets:  [][]netlink.TcU32Key{{{Off: offSrc, Mask: 0xffffff00, Val: 0xc0a80100}}},
+		},
+		{
+			name:  "protocol, dscp and port",
+			match: Match{Protocol: "udp", DstPort: "2152", Dscp: &dscp},
+			sets: [][]netlink.TcU32Key{{
+				{Off: offTos, Mask: 0x00fc0000, Val: 46 << 18},
+				{Off: offProto, Mask: 0x00ff0000, Val: 17 << 16},
+				{Off: offPorts, Mask: 0x0000ffff, Val: 2152},
+			}},
+		},
+		{
+			name:  "port ranges",
+			match: Match{Protocol: "6", SrcPort: "1000-1001", DstPort: "80-81"},
+			sets: [][]netlink.TcU32Key{{
+				{Off: offProto, Mask: 0x00ff0000, Val: 6 << 16},
+				{Off: offPorts, Mask: 0xfffefffe, Val: 1000<<16 | 80},
+			}},
+		},
+		{
+			name:  "split port range",
+			match: Match{Protocol: "tcp", DstPort: "7-8"},
+			sets: [][]netlink.TcU32Key{
+				{{Off: offProto, Mask: 0x00ff0000, Val: 6 << 16}, {Off: offPorts, Mask: 0xffff, Val: 7}},
+				{{Off: offProto, Mask: 0x00ff0000, Val: 6 << 16}, {Off: offPorts, Mask: 0xffff, Val: 8}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		sets, err := test.match.keySets()
+		if err != nil {
+			t.Errorf("%s: keySets failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sets, test.sets) {
+			t.Errorf("%s: keySets = %v, want %v", test.name, sets, test.sets)
+		}
+	}
+}
+
+func TestKeySetsInvalid(t *testing.T) {
+	dscp := uint8(64)
+	tests := []struct {
+		name  string
+		match Match
+	}{
+		{name: "ipv6 addr", match: Match{DstIp: "fd00::1"}},
+		{name: "bad addr", match: Match{SrcIp: "10.0.0"}},
+		{name: "bad protocol", match: Match{Protocol: "gre2"}},
+		{name: "dscp out of range", match: Match{Dscp: &dscp}},
+		{name: "port without protocol", match: Match{DstPort: "80"}},
+		{name: "port with icmp", match: Match{Protocol: "icmp", DstPort: "80"}},
+		{name: "too many filters", match: Match{Protocol: "tcp", SrcPort: "1-1000", DstPort: "1-1000"}},
+	}
+
+	for _, test := range tests {
+		if sets, err := test.match.keySets(); err == nil {
+			t.Errorf("%s: keySets = %v, want error", test.name, sets)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// NetemSpec emulates latency and impairments of a slice, with a netem leaf
+// qdisc under the htb class of the slice
+type NetemSpec struct {
+	Delay       float64 `json:"Delay,omitempty"`       // ms
+	Jitter      float64 `json:"Jitter,omitempty"`      // ms, requires Delay
+	Loss        float32 `json:"Loss,omitempty"`        // %
+	Duplicate   float32 `json:"Duplicate,omitempty"`   // %
+	Reorder     float32 `json:"Reorder,omitempty"`     // %, requires Delay
+	Correlation float32 `json:"Correlation,omitempty"` // %, of delay, loss, duplicate and reorder
+	Limit       uint32  `json:"Limit,omitempty"`       // packets, computed from ceil and delay if not set
+}
+
+// Queue length of netem when it is not set
+const minNetemLimit = 1000
+
+// IsZero reports whether spec emulates nothing
+func (spec NetemSpec) IsZero() bool {
+	return spec == NetemSpec{}
+}
+
+// Validate checks the ranges of spec
+func (spec NetemSpec) Validate() error {
+	if spec.Delay < 0 || spec.Jitter < 0 {
+		return fmt.Errorf("delay and jitter should not be negative")
+	}
+	if spec.Jitter > 0 && spec.Delay == 0 {
+		return fmt.Errorf("jitter requires delay")
+	}
+	if spec.Reorder > 0 && spec.Delay == 0 {
+		return fmt.Errorf("reorder requires delay")
+	}
+
+	for _, percent := range []float32{spec.Loss, spec.Duplicate, spec.Reorder, spec.Correlation} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("loss, duplicate, reorder and correlation should be 0-100")
+		}
+	}
+
+	return nil
+}
+
+// Queue length of netem, sized from ceilRate (KB/Sec) unless spec sets it
+func (spec NetemSpec) limit(ceilRate int) uint32 {
+	if spec.Limit != 0 {
+		return spec.Limit
+	}
+
+	// ceil (bytes/ms) * delay (ms) / mtu, doubled for jitter and bursts
+	limit := uint32(float64(ceilRate) * (spec.Delay + spec.Jitter) / 1500 * 2)
+	if limit < minNetemLimit {
+		limit = minNetemLimit
+	}
+	return limit
+}
+
+// SetNetem replaces the leaf qdisc of htb class 1:classId with netem of spec,
+// or restores the default leaf qdisc if spec is nil or zero. ceilRate (KB/Sec)
+// sizes the netem queue, so it holds the packets in flight during delay.
+func SetNetem(linkName string, classId uint16, spec *NetemSpec, ceilRate int) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return err
+	}
+
+	qdiscAttr := netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Parent:    netlink.MakeHandle(1, classId),
+	}
+
+	if spec == nil || spec.IsZero() {
+		return delNetem(link, qdiscAttr.Parent)
+	}
+
+	limit := spec.limit(ceilRate)
+
+	netemAttr := netlink.NetemQdiscAttrs{
+		Latency:       uint32(spec.Delay * 1000),
+		Jitter:        uint32(spec.Jitter * 1000),
+		DelayCorr:     spec.Correlation,
+		Loss:          spec.Loss,
+		LossCorr:      spec.Correlation,
+		Duplicate:     spec.Duplicate,
+		DuplicateCorr: spec.Correlation,
+		ReorderProb:   spec.Reorder,
+		ReorderCorr:   spec.Correlation,
+		Limit:         limit,
+	}
+
+	if err := netlink.QdiscReplace(netlink.NewNetem(qdiscAttr, netemAttr)); err != nil {
+		internalLogger.Println("Failed to set netem qdisc, ", err)
+		return err
+	}
+
+	return nil
+}
+
+// Del netem leaf qdisc under parent, kernel attaches the default leaf qdisc again
+func delNetem(link netlink.Link, parent uint32) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		internalLogger.Println("Failed to list qdisc, ", err)
+		return err
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent != parent || qdisc.Type() != "netem" {
+			continue
+		}
+
+		if err := netlink.QdiscDel(qdisc); err != nil {
+			internalLogger.Println("Failed to delete netem qdisc, ", err)
+			return err
+		}
+	}
+
+	return nil
+}
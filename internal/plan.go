@@ -0,0 +1,557 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Plan records the kernel changes of a request in order, with the ip and tc
+// command lines which make the same changes, and changes nothing. It reads
+// the links to plan the changes as Live would make them.
+type Plan struct {
+	Ops []Op `json:"Ops"`
+
+	// Class IDs in use on each link, including the ones the plan takes
+	classIds map[string]map[uint16]bool
+	// Links the plan creates, and their capacities (KB/Sec)
+	links map[string]int
+	// ifb devices the plan sets up, by vxlan interface
+	ifbs map[string]string
+}
+
+// Op is a change of a link, address, qdisc, class or filter
+type Op struct {
+	// link, address, qdisc, class or filter
+	Object string `json:"Object"`
+	// add, change, replace, set or delete
+	Action  string `json:"Action"`
+	Link    string `json:"Link"`
+	Command string `json:"Command"`
+}
+
+// NewPlan returns an empty plan
+func NewPlan() *Plan {
+	return &Plan{
+		Ops:      []Op{},
+		classIds: make(map[string]map[uint16]bool),
+		links:    make(map[string]int),
+		ifbs:     make(map[string]string),
+	}
+}
+
+func (plan *Plan) add(object, action, linkName, format string, args ...interface{}) {
+	plan.Ops = append(plan.Ops, Op{
+		Object:  object,
+		Action:  action,
+		Link:    linkName,
+		Command: fmt.Sprintf(format, args...),
+	})
+}
+
+// Check the link exists on the host or is created by the plan
+func (plan *Plan) checkLink(linkName string) error {
+	if _, ok := plan.links[linkName]; ok {
+		return nil
+	}
+
+	_, err := netlink.LinkByName(linkName)
+	return err
+}
+
+// Check the link neither exists on the host nor is created by the plan
+func (plan *Plan) checkNoLink(linkName string) error {
+	if plan.checkLink(linkName) == nil {
+		return fmt.Errorf("link %s already exists", linkName)
+	}
+	return nil
+}
+
+func (plan *Plan) CreateBridge(bridgeName string) error {
+	if err := plan.checkNoLink(bridgeName); err != nil {
+		return err
+	}
+
+	plan.add("link", "add", bridgeName, "ip link add name %s type bridge", bridgeName)
+	plan.links[bridgeName] = 0
+	return nil
+}
+
+func (plan *Plan) CreateVxlan(vxlanName, vxlanId, localIp, remoteIp string) error {
+	if err := plan.checkNoLink(vxlanName); err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("ip link add name %s type vxlan id %s", vxlanName, vxlanId)
+	if ip := net.ParseIP(localIp); ip != nil {
+		command += " local " + ip.String()
+	}
+	if ip := net.ParseIP(remoteIp); ip != nil {
+		if ip.IsMulticast() {
+			command += " group " + ip.String()
+		} else {
+			command += " remote " + ip.String()
+		}
+	}
+
+	plan.add("link", "add", vxlanName, "%s tos inherit", command)
+	plan.links[vxlanName] = 0
+	return nil
+}
+
+func (plan *Plan) CreateVeth(vethName, peerName string) error {
+	if err := plan.checkNoLink(vethName); err != nil {
+		return err
+	}
+	if err := plan.checkNoLink(peerName); err != nil {
+		return err
+	}
+
+	plan.add("link", "add", vethName, "ip link add name %s type veth peer name %s", vethName, peerName)
+	plan.links[vethName] = 0
+	plan.links[peerName] = 0
+	return nil
+}
+
+func (plan *Plan) SetMaster(linkName, masterName string) error {
+	if err := plan.checkLink(linkName); err != nil {
+		return err
+	}
+	if err := plan.checkLink(masterName); err != nil {
+		return err
+	}
+
+	plan.add("link", "set", linkName, "ip link set dev %s master %s", linkName, masterName)
+	return nil
+}
+
+func (plan *Plan) SetBridgeIp(ipv4Addr, bridgeName string) error {
+	if err := plan.checkLink(bridgeName); err != nil {
+		return err
+	}
+
+	addr, err := netlink.ParseAddr(ipv4Addr)
+	if err != nil {
+		return err
+	}
+
+	plan.add("address", "add", bridgeName, "ip addr add %s dev %s", addr.IPNet.String(), bridgeName)
+	return nil
+}
+
+func (plan *Plan) LinkSetUp(linkName string) error {
+	if err := plan.checkLink(linkName); err != nil {
+		return err
+	}
+
+	plan.add("link", "set", linkName, "ip link set dev %s up", linkName)
+	return nil
+}
+
+func (plan *Plan) SetVxlanDown(vxlanName string) error {
+	if err := plan.checkLink(vxlanName); err != nil {
+		return err
+	}
+
+	plan.add("link", "set", vxlanName, "ip link set dev %s down", vxlanName)
+	plan.add("link", "set", vxlanName, "ip link set dev %s nomaster", vxlanName)
+	return nil
+}
+
+func (plan *Plan) DelBridge(bridgeName string) error {
+	if err := plan.checkLink(bridgeName); err != nil {
+		return err
+	}
+
+	plan.add("link", "delete", bridgeName, "ip link del dev %s", bridgeName)
+	return nil
+}
+
+func (plan *Plan) DelVxlan(vxlanName string) error {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		return err
+	}
+
+	// The ifb device is deleted with the vxlan interface
+	if ifbLink, err := netlink.LinkByName(ifbName(vxlanLink)); err == nil {
+		plan.add("link", "delete", ifbLink.Attrs().Name, "ip link del dev %s", ifbLink.Attrs().Name)
+	}
+
+	plan.add("link", "delete", vxlanName, "ip link del dev %s", vxlanName)
+	return nil
+}
+
+// Capacity of link, the ifb devices the plan sets up take the capacity of
+// their vxlan interface
+func (plan *Plan) capacity(linkName string) int {
+	if capacity, ok := plan.links[linkName]; ok && capacity > 0 {
+		return capacity
+	}
+	return LinkCapacity(linkName)
+}
+
+// Class IDs in use on link. As linkAllocator, the root htb qdisc and the
+// parent and default classes are set up on first use of the link.
+func (plan *Plan) linkClassIds(linkName string) (map[uint16]bool, error) {
+	if used, ok := plan.classIds[linkName]; ok {
+		return used, nil
+	}
+
+	used := make(map[uint16]bool)
+
+	_, created := plan.links[linkName]
+	link, err := netlink.LinkByName(linkName)
+	if err != nil && !created {
+		return nil, err
+	}
+
+	var allocator *classAllocator
+	if link != nil {
+		allocatorLock.Lock()
+		if allocator = classAllocators[link.Attrs().Index]; allocator != nil {
+			for id := range allocator.used {
+				used[id] = true
+			}
+		}
+		allocatorLock.Unlock()
+	}
+
+	if allocator == nil {
+		exist := false
+		if link != nil {
+			if exist, err = hasRootQdisc(link); err != nil {
+				return nil, err
+			}
+		}
+
+		if exist {
+			if used, err = kernelClassIds(link); err != nil {
+				return nil, err
+			}
+		} else {
+			plan.add("qdisc", "add", linkName, "tc qdisc add dev %s root handle 1: htb default %x", linkName, defaultClassId)
+		}
+
+		plan.linkTree(linkName)
+	}
+
+	plan.classIds[linkName] = used
+	return used, nil
+}
+
+// Class IDs of the root htb qdisc 1: in kernel
+func kernelClassIds(link netlink.Link) (map[uint16]bool, error) {
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(1, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[uint16]bool)
+	for _, class := range classes {
+		major, minor := netlink.MajorMinor(class.Attrs().Handle)
+		if major == 1 {
+			used[minor] = true
+		}
+	}
+	return used, nil
+}
+
+// Plan the parent and default class of link, as setupLinkTree
+func (plan *Plan) linkTree(linkName string) {
+	capacity := plan.capacity(linkName)
+	defaultCeil := DefaultLinkConfig.DefaultCeil
+	if defaultCeil == 0 {
+		defaultCeil = capacity
+	}
+	plan.add("class", "replace", linkName, "tc class replace dev %s parent 1: classid 1:%x htb %s",
+		linkName, ParentClassId, htbArgs(ClassSpec{Rate: capacity}))
+	plan.add("class", "replace", linkName, "tc class replace dev %s parent 1:%x classid 1:%x htb %s",
+		linkName, ParentClassId, defaultClassId, htbArgs(ClassSpec{Rate: DefaultLinkConfig.DefaultRate, Ceil: defaultCeil, Prio: 7}))
+}
+
+// SetupLinkTree plans the root htb qdisc if it is missing on link, and the
+// parent and default class
+func (plan *Plan) SetupLinkTree(linkName string) error {
+	if _, ok := plan.classIds[linkName]; ok {
+		return nil
+	}
+
+	_, created := plan.links[linkName]
+	link, err := netlink.LinkByName(linkName)
+	if err != nil && !created {
+		return err
+	}
+
+	exist := false
+	if link != nil {
+		if exist, err = hasRootQdisc(link); err != nil {
+			return err
+		}
+	}
+
+	used := make(map[uint16]bool)
+	if exist {
+		if used, err = kernelClassIds(link); err != nil {
+			return err
+		}
+	} else {
+		plan.add("qdisc", "add", linkName, "tc qdisc add dev %s root handle 1: htb default %x", linkName, defaultClassId)
+	}
+
+	plan.linkTree(linkName)
+	plan.classIds[linkName] = used
+	return nil
+}
+
+func (plan *Plan) RestoreQdisc(linkName string, classId uint16, spec ClassSpec) error {
+	used, err := plan.linkClassIds(linkName)
+	if err != nil {
+		return err
+	}
+
+	used[classId] = true
+	plan.add("class", "replace", linkName, "tc class replace dev %s parent 1:%x classid 1:%x htb %s",
+		linkName, spec.parent(), classId, htbArgs(spec))
+	return nil
+}
+
+func (plan *Plan) AddQdisc(linkName string, spec ClassSpec) (uint16, error) {
+	used, err := plan.linkClassIds(linkName)
+	if err != nil {
+		return 0, err
+	}
+
+	allocator := classAllocator{used: used}
+	classId, err := allocator.allocate()
+	if err != nil {
+		return 0, err
+	}
+
+	plan.add("class", "add", linkName, "tc class add dev %s parent 1:%x classid 1:%x htb %s",
+		linkName, spec.parent(), classId, htbArgs(spec))
+	return classId, nil
+}
+
+func (plan *Plan) ChangeQdisc(linkName string, classId uint16, spec ClassSpec) error {
+	plan.add("class", "change", linkName, "tc class change dev %s parent 1:%x classid 1:%x htb %s",
+		linkName, spec.parent(), classId, htbArgs(spec))
+	return nil
+}
+
+func (plan *Plan) DelQdisc(linkName string, classId uint16) error {
+	plan.add("class", "delete", linkName, "tc class del dev %s classid 1:%x", linkName, classId)
+	if used, ok := plan.classIds[linkName]; ok {
+		delete(used, classId)
+	}
+	return nil
+}
+
+// AddFilter plans the filters, the handles are assigned by kernel and not
+// known until they are added
+func (plan *Plan) AddFilter(linkName string, matches []Match, classId uint16, remark *Remark) ([]uint32, uint16, error) {
+	prio := classId
+	if err := plan.addFilters(linkName, matches, classId, prio, remark); err != nil {
+		return nil, 0, err
+	}
+
+	return nil, prio, nil
+}
+
+func (plan *Plan) ReplaceFilter(linkName string, matches []Match, classId, prio uint16, handles []uint32, remark *Remark) ([]uint32, error) {
+	if err := plan.addFilters(linkName, matches, classId, prio, remark); err != nil {
+		return nil, err
+	}
+
+	for _, handle := range handles {
+		plan.add("filter", "delete", linkName, "tc filter del dev %s parent 1: protocol ip prio %d handle %s u32",
+			linkName, prio, u32Handle(handle))
+	}
+
+	return nil, nil
+}
+
+func (plan *Plan) addFilters(linkName string, matches []Match, classId, prio uint16, remark *Remark) error {
+	for _, match := range matches {
+		keySets, err := match.keySets()
+		if err != nil {
+			return err
+		}
+
+		for _, keys := range keySets {
+			selector := ""
+			for _, key := range keys {
+				selector += fmt.Sprintf(" match u32 0x%08x 0x%08x at %d", key.Val, key.Mask, key.Off)
+			}
+			if selector == "" {
+				selector = " match u32 0 0"
+			}
+
+			plan.add("filter", "add", linkName, "tc filter add dev %s parent 1: protocol ip prio %d u32%s flowid 1:%x%s",
+				linkName, prio, selector, classId, remarkArgs(remark))
+		}
+	}
+
+	return nil
+}
+
+func (plan *Plan) DelFilter(linkName string, prio uint16) error {
+	plan.add("filter", "delete", linkName, "tc filter del dev %s parent 1: protocol ip prio %d", linkName, prio)
+	return nil
+}
+
+func (plan *Plan) SetNetem(linkName string, classId uint16, spec *NetemSpec, ceilRate int) error {
+	if spec == nil || spec.IsZero() {
+		// Only an existing netem qdisc is deleted
+		link, err := netlink.LinkByName(linkName)
+		if err != nil {
+			return nil
+		}
+		qdiscs, err := netlink.QdiscList(link)
+		if err != nil {
+			return err
+		}
+		for _, qdisc := range qdiscs {
+			if qdisc.Attrs().Parent == netlink.MakeHandle(1, classId) && qdisc.Type() == "netem" {
+				plan.add("qdisc", "delete", linkName, "tc qdisc del dev %s parent 1:%x", linkName, classId)
+			}
+		}
+		return nil
+	}
+
+	plan.add("qdisc", "replace", linkName, "tc qdisc replace dev %s parent 1:%x netem %s",
+		linkName, classId, netemArgs(*spec, ceilRate))
+	return nil
+}
+
+func (plan *Plan) SetupIfb(vxlanName string) (string, error) {
+	if name, ok := plan.ifbs[vxlanName]; ok {
+		return name, nil
+	}
+
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		return "", err
+	}
+
+	name := ifbName(vxlanLink)
+
+	ifbLink, err := netlink.LinkByName(name)
+	if err != nil {
+		plan.add("link", "add", name, "ip link add name %s type ifb", name)
+		ifbLink = nil
+	}
+	plan.links[name] = plan.capacity(vxlanName)
+
+	if ifbLink == nil || ifbLink.Attrs().Flags&net.FlagUp == 0 {
+		plan.add("link", "set", name, "ip link set dev %s up", name)
+	}
+
+	exist := false
+	if ifbLink != nil {
+		if exist, err = hasIngressRedirect(vxlanLink, ifbLink); err != nil {
+			return "", err
+		}
+	}
+	if !exist {
+		plan.add("qdisc", "replace", vxlanName, "tc qdisc replace dev %s ingress", vxlanName)
+		plan.add("filter", "add", vxlanName, "tc filter add dev %s parent ffff: protocol all prio %d u32 match u32 0 0 action mirred egress redirect dev %s",
+			vxlanName, ingressRedirectPrio, name)
+	}
+
+	plan.ifbs[vxlanName] = name
+	return name, nil
+}
+
+func (plan *Plan) TeardownIfb(vxlanName string) error {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		return err
+	}
+
+	name := ifbName(vxlanLink)
+	plan.add("qdisc", "delete", vxlanName, "tc qdisc del dev %s ingress", vxlanName)
+	plan.add("link", "delete", name, "ip link del dev %s", name)
+	delete(plan.ifbs, vxlanName)
+	delete(plan.links, name)
+	return nil
+}
+
+func (plan *Plan) DelIfb(ifbName string) error {
+	ifbLink, err := netlink.LinkByName(ifbName)
+	if err != nil {
+		return nil
+	}
+	if ifbLink.Type() != "ifb" {
+		return fmt.Errorf("%s is not an ifb device", ifbName)
+	}
+
+	plan.add("link", "delete", ifbName, "ip link del dev %s", ifbName)
+	return nil
+}
+
+// Arguments of tc htb class of spec
+func htbArgs(spec ClassSpec) string {
+	ceil := spec.Ceil
+	if ceil == 0 {
+		ceil = spec.Rate
+	}
+
+	args := fmt.Sprintf("rate %dkbit ceil %dkbit", spec.Rate*8, ceil*8)
+	if spec.Burst != 0 {
+		args += fmt.Sprintf(" burst %db", spec.Burst)
+	}
+	if spec.Cburst != 0 {
+		args += fmt.Sprintf(" cburst %db", spec.Cburst)
+	}
+	return args + fmt.Sprintf(" prio %d", spec.Prio)
+}
+
+// Arguments of tc netem qdisc of spec
+func netemArgs(spec NetemSpec, ceilRate int) string {
+	correlation := ""
+	if spec.Correlation > 0 {
+		correlation = fmt.Sprintf(" %g%%", spec.Correlation)
+	}
+
+	args := fmt.Sprintf("limit %d", spec.limit(ceilRate))
+	if spec.Delay > 0 {
+		args += fmt.Sprintf(" delay %gms", spec.Delay)
+		if spec.Jitter > 0 {
+			args += fmt.Sprintf(" %gms", spec.Jitter)
+		}
+		args += correlation
+	}
+	if spec.Loss > 0 {
+		args += fmt.Sprintf(" loss %g%%", spec.Loss) + correlation
+	}
+	if spec.Duplicate > 0 {
+		args += fmt.Sprintf(" duplicate %g%%", spec.Duplicate) + correlation
+	}
+	if spec.Reorder > 0 {
+		args += fmt.Sprintf(" reorder %g%%", spec.Reorder) + correlation
+	}
+	return args
+}
+
+// Actions of tc filter which rewrite as remark
+func remarkArgs(remark *Remark) string {
+	if remark.IsZero() {
+		return ""
+	}
+
+	args := ""
+	if remark.Pcp != nil {
+		args += fmt.Sprintf(" action skbedit priority %d", *remark.Pcp)
+	}
+	if remark.Dscp != nil {
+		args += fmt.Sprintf(" action pedit ex munge ip dsfield set 0x%02x retain 0xfc pipe action csum ip4h", *remark.Dscp<<2)
+	}
+	return args
+}
+
+// Format u32 filter handle as tc does, <htid>:<hash>:<node>
+func u32Handle(handle uint32) string {
+	return fmt.Sprintf("%x:%x:%x", handle>>20, (handle>>12)&0xff, handle&0xfff)
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// LinkStatus is the state of a link found in kernel
+type LinkStatus struct {
+	Exist bool
+	// Administratively up
+	Up bool
+	// Name of the master, empty if none
+	Master string
+	// ipv4 addresses in cidr
+	Addrs []string
+}
+
+// HasAddr reports whether link has the ipv4 addr in cidr
+func (status LinkStatus) HasAddr(ipv4Addr string) bool {
+	addr, err := netlink.ParseAddr(ipv4Addr)
+	if err != nil {
+		return false
+	}
+
+	for _, a := range status.Addrs {
+		if a == addr.IPNet.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLinkStatus reads the state of link, Exist is false if it does not exist
+func GetLinkStatus(linkName string) LinkStatus {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		return LinkStatus{}
+	}
+
+	status := LinkStatus{
+		Exist: true,
+		Up:    link.Attrs().Flags&net.FlagUp != 0,
+	}
+
+	if master, err := LinkMaster(linkName); err == nil {
+		status.Master = master
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err == nil {
+		for _, addr := range addrs {
+			status.Addrs = append(status.Addrs, addr.IPNet.String())
+		}
+	}
+
+	return status
+}
+
+// IfbReady reports whether the ifb device of vxlan interface exists and is
+// up, and ingress traffic of vxlan interface is redirected to it
+func IfbReady(vxlanName string) bool {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		return false
+	}
+
+	ifbLink, err := netlink.LinkByName(ifbName(vxlanLink))
+	if err != nil || ifbLink.Attrs().Flags&net.FlagUp == 0 {
+		return false
+	}
+
+	exist, err := hasIngressRedirect(vxlanLink, ifbLink)
+	return err == nil && exist
+}
+
+// SetupLinkTree creates the root htb qdisc of link if it is missing, and
+// creates or updates the parent and default class
+func SetupLinkTree(linkName string) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return err
+	}
+
+	allocatorLock.Lock()
+	defer allocatorLock.Unlock()
+
+	exist, err := hasRootQdisc(link)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		// The classes are gone with the root qdisc
+		delete(classAllocators, link.Attrs().Index)
+	}
+
+	// Root qdisc is created on first use of the link
+	if _, err := linkAllocator(link); err != nil {
+		return err
+	}
+
+	return setupLinkTree(link)
+}
+
+// RestoreQdisc adds htb class 1:classId of spec, or changes it if it exists,
+// keeping the class ID recorded for it
+func RestoreQdisc(linkName string, classId uint16, spec ClassSpec) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return err
+	}
+
+	allocatorLock.Lock()
+	allocator, err := linkAllocator(link)
+	if err == nil {
+		allocator.used[classId] = true
+	}
+	allocatorLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	class := newHtbClass(link, classId, spec.parent(), spec)
+	if err := netlink.ClassReplace(class); err != nil {
+		internalLogger.Println("Failed to restore class: ", err)
+		return err
+	}
+
+	return nil
+}
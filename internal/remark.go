@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Remark rewrites the QoS fields of packets matched by the filters of a
+// slice, so devices of the transport network can honour the slice QoS
+type Remark struct {
+	// DSCP of the ipv4 header, 0-63. It is copied to the outer header if the
+	// vxlan interface inherits tos.
+	Dscp *uint8 `json:"Dscp,omitempty"`
+	// 802.1p priority, 0-7. It is set as skb priority, which the vlan device
+	// of the underlay maps to PCP with its egress-qos-map.
+	Pcp *uint8 `json:"Pcp,omitempty"`
+}
+
+// Attributes of pedit and csum actions, not provided by netlink
+const (
+	tcaPeditParms = 2
+	tcaCsumParms  = 1
+	// Update ipv4 header checksum
+	tcaCsumUpdateFlagIPv4Hdr = 1
+)
+
+// IsZero reports whether remark rewrites nothing
+func (remark *Remark) IsZero() bool {
+	return remark == nil || (remark.Dscp == nil && remark.Pcp == nil)
+}
+
+// Validate checks the ranges of remark
+func (remark *Remark) Validate() error {
+	if remark == nil {
+		return nil
+	}
+	if remark.Dscp != nil && *remark.Dscp > 63 {
+		return fmt.Errorf("dscp should be 0-63")
+	}
+	if remark.Pcp != nil && *remark.Pcp > 7 {
+		return fmt.Errorf("pcp should be 0-7")
+	}
+	return nil
+}
+
+// Add u32 filter with the actions of remark. netlink has no pedit action to
+// rewrite the tos, so the filter request is built here in the same way as
+// netlink.FilterAdd.
+func addRemarkFilter(filter *netlink.U32, remark *Remark) error {
+	native := nl.NativeEndian()
+
+	req := nl.NewNetlinkRequest(unix.RTM_NEWTFILTER, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	base := filter.Attrs()
+	req.AddData(&nl.TcMsg{
+		Family:  nl.FAMILY_ALL,
+		Ifindex: int32(base.LinkIndex),
+		Handle:  base.Handle,
+		Parent:  base.Parent,
+		Info:    netlink.MakeHandle(base.Priority, nl.Swap16(base.Protocol)),
+	})
+	req.AddData(nl.NewRtAttr(nl.TCA_KIND, nl.ZeroTerminated(filter.Type())))
+
+	options := nl.NewRtAttr(nl.TCA_OPTIONS, nil)
+
+	// Keys are in network order
+	sel := *filter.Sel
+	sel.Keys = make([]nl.TcU32Key, len(filter.Sel.Keys))
+	for i, key := range filter.Sel.Keys {
+		sel.Keys[i] = key
+		sel.Keys[i].Mask = native.Uint32(networkOrder(key.Mask))
+		sel.Keys[i].Val = native.Uint32(networkOrder(key.Val))
+	}
+	sel.Nkeys = uint8(len(sel.Keys))
+	options.AddRtAttr(nl.TCA_U32_SEL, sel.Serialize())
+	options.AddRtAttr(nl.TCA_U32_CLASSID, nl.Uint32Attr(filter.ClassId))
+
+	actions := options.AddRtAttr(nl.TCA_U32_ACT, nil)
+	tabIndex := nl.TCA_ACT_TAB
+
+	if remark.Pcp != nil {
+		priority := uint32(*remark.Pcp)
+		skbedit := netlink.NewSkbEditAction()
+		skbedit.Priority = &priority
+		if err := netlink.EncodeActions(actions, []netlink.Action{skbedit}); err != nil {
+			return err
+		}
+		tabIndex++
+	}
+
+	if remark.Dscp != nil {
+		// tos is the 2nd byte of the 1st word of ipv4 header, keep ECN bits
+		pedit := actions.AddRtAttr(tabIndex, nil)
+		pedit.AddRtAttr(nl.TCA_ACT_KIND, nl.ZeroTerminated("pedit"))
+		peditOpts := pedit.AddRtAttr(nl.TCA_ACT_OPTIONS, nil)
+		peditOpts.AddRtAttr(tcaPeditParms, peditSel(native, 0, networkOrder(0xff03ffff), networkOrder(uint32(*remark.Dscp)<<18)))
+		tabIndex++
+
+		// Fix ipv4 header checksum after tos is rewritten
+		csum := actions.AddRtAttr(tabIndex, nil)
+		csum.AddRtAttr(nl.TCA_ACT_KIND, nl.ZeroTerminated("csum"))
+		csumOpts := csum.AddRtAttr(nl.TCA_ACT_OPTIONS, nil)
+		csumParms := tcGen(native, int32(netlink.TC_ACT_PIPE))
+		csumParms = appendUint32(native, csumParms, tcaCsumUpdateFlagIPv4Hdr)
+		csumOpts.AddRtAttr(tcaCsumParms, csumParms)
+	}
+
+	req.AddData(options)
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// struct tc_gen with action
+func tcGen(native binary.ByteOrder, action int32) []byte {
+	gen := make([]byte, 20)
+	native.PutUint32(gen[8:], uint32(action))
+	return gen
+}
+
+// struct tc_pedit_sel with one key, which sets the word at offset off of
+// network header to (word & mask) ^ val
+func peditSel(native binary.ByteOrder, off uint32, mask, val []byte) []byte {
+	sel := tcGen(native, int32(netlink.TC_ACT_PIPE))
+	// nkeys, flags and padding
+	sel = append(sel, 1, 0, 0, 0)
+
+	// struct tc_pedit_key
+	sel = append(sel, mask...)
+	sel = append(sel, val...)
+	sel = appendUint32(native, sel, off)
+	sel = appendUint32(native, sel, 0) // at
+	sel = appendUint32(native, sel, 0) // offmask
+	sel = appendUint32(native, sel, 0) // shift
+	return sel
+}
+
+func appendUint32(native binary.ByteOrder, b []byte, v uint32) []byte {
+	word := make([]byte, 4)
+	native.PutUint32(word, v)
+	return append(b, word...)
+}
+
+// Bytes of v in network order
+func networkOrder(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
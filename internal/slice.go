@@ -1,19 +1,15 @@
 package internal
 
 import (
+	"fmt"
 	"net"
-	"os"
-	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/vishvananda/netlink"
-	//"github.com/florianl/go-tc/class"
-	//"github.com/florianl/go-tc/filter"
 )
 
-var qdiscIndex uint16 = 1
-
 func CreateRootQdisc(vxlanLink *netlink.Link) error {
 
 	qdiscAttr := netlink.QdiscAttrs{
@@ -24,103 +20,283 @@ func CreateRootQdisc(vxlanLink *netlink.Link) error {
 
 	// Create root qdisc
 	rootQdisc := netlink.NewHtb(qdiscAttr)
+	rootQdisc.Defcls = uint32(defaultClassId)
 
 	if err := netlink.QdiscAdd(rootQdisc); err != nil {
-		internalLogger.Panicln("Failed to create root qdisc:", err)
+		internalLogger.Println("Failed to create root qdisc:", err)
 		return err
 	}
 
 	return nil
 }
 
-func AddQdisc(vxlanName string, flowRate int) (uint16, error) {
+// Add htb class of slice under the parent class of spec, returns the class ID
+func AddQdisc(vxlanName string, spec ClassSpec) (uint16, error) {
 	vxlanLink, err := netlink.LinkByName(vxlanName)
 	if err != nil {
-		internalLogger.Panicln("Failed to get vxlan link, ", err)
+		internalLogger.Println("Failed to get vxlan link, ", err)
 		return 0, err
 	}
 
-	//TODO: if root qdisc not exist, create it
-	if qdiscIndex == 1 {
-		err = CreateRootQdisc(&vxlanLink)
-		if err != nil {
-			internalLogger.Panic("Failed to create vxlan root qdisc, ", err)
-			return 0, err
-		}
+	// Root qdisc and parent class are created on first allocation of the link
+	classId, err := allocClassId(vxlanLink)
+	if err != nil {
+		internalLogger.Println("Failed to allocate class ID, ", err)
+		return 0, err
 	}
 
 	// Create class
-	classAttr := &netlink.ClassAttrs{
-		LinkIndex: vxlanLink.Attrs().Index,
-		Handle:    netlink.MakeHandle(1, qdiscIndex),
-		Parent:    netlink.HANDLE_ROOT, //tc.HandleRoot,
+	class := newHtbClass(vxlanLink, classId, spec.parent(), spec)
+
+	if err := netlink.ClassAdd(class); err != nil {
+		internalLogger.Println("Failed to create class: ", err)
+		releaseClassId(vxlanLink, classId)
+		return 0, err
 	}
 
-	htbClassAttr := &netlink.HtbClassAttrs{
-		Rate: uint64(flowRate * 8 * 1000),
-		Ceil: uint64(flowRate * 8 * 1000),
+	return classId, nil
+}
+
+// Change htb class 1:classId in place
+func ChangeQdisc(vxlanName string, classId uint16, spec ClassSpec) error {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return err
 	}
 
-	class := netlink.NewHtbClass(*classAttr, *htbClassAttr)
+	class := newHtbClass(vxlanLink, classId, spec.parent(), spec)
 
-	if err := netlink.ClassAdd(class); err != nil {
-		internalLogger.Panicln("Failed to create class: ", err)
-		return 0, err
+	if err := netlink.ClassChange(class); err != nil {
+		internalLogger.Println("Failed to change class: ", err)
+		return err
 	}
 
-	qdiscIndex += 1
-	return qdiscIndex - 1, nil
+	return nil
 }
 
-// Add filter
-func AddFilter(vxlanName, dstIP, classId string) error {
+// Add u32 filters which classify the ipv4 traffic selected by matches into
+// class 1:classId, and rewrite it by remark if not nil. It returns the handles
+// and the priority of the filters.
+func AddFilter(vxlanName string, matches []Match, classId uint16, remark *Remark) ([]uint32, uint16, error) {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return nil, 0, err
+	}
 
-	//TODO: Call library to create tc filter
-	cmd := exec.Command("tc", "filter", "add", "dev", vxlanName, "parent", "1:", "protocol", "ip", "prio", "1", "u32", "match", "ip", "dst", dstIP, "flowid", "1:"+classId)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Each slice owns a filter priority, so the class ID is unique for it
+	prio := classId
 
-	if err := cmd.Run(); err != nil {
-		internalLogger.Panicln("Failed to create tc filter, ", err)
-		return err
+	if err := addFilters(vxlanLink, matches, classId, prio, remark); err != nil {
+		DelFilter(vxlanName, prio)
+		return nil, 0, err
 	}
 
-	/*
-		filter := &netlink.U32{
-			FilterAttrs: netlink.FilterAttrs{
-				LinkIndex: vxlanIndex(vxlanName),
-				Parent:    1, // parent 1:
-				Protocol:  unix.ETH_P_IP,
-				//Prio:      1,
-			},
-			ClassId: netlink.MakeHandle(1, 32), // same as ClassAttrs.Handle
-			Sel: &netlink.TcU32Sel{
-				Nkeys: 1,
-				Keys: []netlink.TcU32Key{
-					{
-						Mask: 0xffffffff,                     // fit all bits
-						Val:  iPToUint32(net.ParseIP(dstIP)), // set value
-						Off:  16,                             // offset
-					},
-				},
-			},
+	// Kernel assigns the filter handles
+	handles, err := filterHandles(vxlanLink, prio, netlink.MakeHandle(1, classId))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return handles, prio, nil
+}
+
+// Replace the u32 filters of class 1:classId with filters of matches, returns
+// the handles of the new filters. The selector of an u32 filter can not be
+// changed, so new filters are added before the old ones are deleted, and the
+// traffic is classified into the class all the time.
+func ReplaceFilter(vxlanName string, matches []Match, classId, prio uint16, handles []uint32, remark *Remark) ([]uint32, error) {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return nil, err
+	}
+
+	old := make(map[uint32]bool)
+	for _, handle := range handles {
+		old[handle] = true
+	}
+
+	if err := addFilters(vxlanLink, matches, classId, prio, remark); err != nil {
+		// Del the filters added so far, keep the old ones
+		if added, listErr := filterHandles(vxlanLink, prio, netlink.MakeHandle(1, classId)); listErr == nil {
+			for _, handle := range added {
+				if !old[handle] {
+					delFilterHandle(vxlanLink, prio, handle)
+				}
+			}
 		}
+		return nil, err
+	}
+
+	for _, handle := range handles {
+		if err := delFilterHandle(vxlanLink, prio, handle); err != nil {
+			return nil, err
+		}
+	}
 
-		err := netlink.FilterAdd(filter)
+	return filterHandles(vxlanLink, prio, netlink.MakeHandle(1, classId))
+}
 
+// Add one u32 filter for each key set of matches
+func addFilters(vxlanLink netlink.Link, matches []Match, classId, prio uint16, remark *Remark) error {
+	for _, match := range matches {
+		keySets, err := match.keySets()
 		if err != nil {
-			fmt.Println("Failed to add filter, ", err)
-			//FIXME: get err = invalid argument
-			os.Exit(1)
+			internalLogger.Println("Failed to parse match, ", err)
+			return err
 		}
-	*/
+
+		for _, keys := range keySets {
+			filter := &netlink.U32{
+				FilterAttrs: netlink.FilterAttrs{
+					LinkIndex: vxlanLink.Attrs().Index,
+					Parent:    netlink.MakeHandle(1, 0), // parent 1:
+					Priority:  prio,
+					Protocol:  syscall.ETH_P_IP,
+				},
+				ClassId: netlink.MakeHandle(1, classId), // same as ClassAttrs.Handle
+				Sel: &netlink.TcU32Sel{
+					Flags: netlink.TC_U32_TERMINAL,
+					Keys:  keys,
+				},
+			}
+
+			if !remark.IsZero() {
+				err = addRemarkFilter(filter, remark)
+			} else {
+				err = netlink.FilterAdd(filter)
+			}
+			if err != nil {
+				internalLogger.Println("Failed to create tc filter, ", err)
+				return err
+			}
+		}
+	}
 
 	return nil
 }
 
-// TODO: del filter
+// Del one u32 filter by its handle
+func delFilterHandle(vxlanLink netlink.Link, prio uint16, handle uint32) error {
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: vxlanLink.Attrs().Index,
+			Parent:    netlink.MakeHandle(1, 0),
+			Handle:    handle,
+			Priority:  prio,
+			Protocol:  syscall.ETH_P_IP,
+		},
+	}
+
+	if err := netlink.FilterDel(filter); err != nil {
+		internalLogger.Println("Failed to delete tc filter, ", err)
+		return err
+	}
 
-// TODO: del qdisc
+	return nil
+}
+
+// Del all u32 filters of the slice by their priority
+func DelFilter(vxlanName string, prio uint16) error {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return err
+	}
+
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: vxlanLink.Attrs().Index,
+			Parent:    netlink.MakeHandle(1, 0),
+			Priority:  prio,
+			Protocol:  syscall.ETH_P_IP,
+		},
+	}
+
+	if err := netlink.FilterDel(filter); err != nil {
+		internalLogger.Println("Failed to delete tc filter, ", err)
+		return err
+	}
+
+	return nil
+}
+
+// Find the handles of u32 filters with priority prio which classify traffic into classId
+func filterHandles(vxlanLink netlink.Link, prio uint16, classId uint32) ([]uint32, error) {
+	filters, err := netlink.FilterList(vxlanLink, netlink.MakeHandle(1, 0))
+	if err != nil {
+		internalLogger.Println("Failed to list tc filter, ", err)
+		return nil, err
+	}
+
+	var handles []uint32
+	for _, filter := range filters {
+		u32, ok := filter.(*netlink.U32)
+		if ok && u32.Priority == prio && u32.ClassId == classId {
+			handles = append(handles, u32.Handle)
+		}
+	}
+
+	if len(handles) == 0 {
+		return nil, fmt.Errorf("filter of class %s not found", netlink.HandleStr(classId))
+	}
+
+	return handles, nil
+}
+
+// Del the htb class 1:classId, and release the class ID for reuse
+func DelQdisc(vxlanName string, classId uint16) error {
+	vxlanLink, err := netlink.LinkByName(vxlanName)
+	if err != nil {
+		internalLogger.Println("Failed to get vxlan link, ", err)
+		return err
+	}
+
+	class := &netlink.HtbClass{
+		ClassAttrs: netlink.ClassAttrs{
+			LinkIndex: vxlanLink.Attrs().Index,
+			// Parent is not set, classes of tenant slices are not under
+			// 1:1 and kernel finds the class by handle
+			Handle: netlink.MakeHandle(1, classId),
+		},
+	}
+
+	if err := netlink.ClassDel(class); err != nil {
+		internalLogger.Println("Failed to delete class: ", err)
+		return err
+	}
+
+	releaseClassId(vxlanLink, classId)
+	forgetClassStats(vxlanLink, classId)
+	return nil
+}
+
+// Parse ipv4 addr or cidr, an addr is taken as /32
+func parseIPv4Net(addr string) (*net.IPNet, error) {
+	if !strings.Contains(addr, "/") {
+		addr += "/32"
+	}
+
+	ip, ipNet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("%s is not an ipv4 addr", addr)
+	}
+
+	return ipNet, nil
+}
+
+func ipv4Mask(mask net.IPMask) uint32 {
+	ones, _ := mask.Size()
+	if ones == 0 {
+		return 0
+	}
+	return ^uint32(0) << (32 - ones)
+}
 
 func iPToUint32(ipAddr net.IP) uint32 {
 	bits := strings.Split(ipAddr.String(), ".")
@@ -138,13 +314,3 @@ func iPToUint32(ipAddr net.IP) uint32 {
 
 	return sum
 }
-
-// Get vxlan interface index
-func vxlanIndex(vxlanName string) int {
-	link, err := netlink.LinkByName(vxlanName)
-	if err != nil {
-		println("Failed to get VXLAN interface:", err)
-		os.Exit(1)
-	}
-	return link.Attrs().Index
-}
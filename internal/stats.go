@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// TrafficStats of a htb class. Packets reach a slice class only through the
+// filters of the slice, so the class counters are the traffic matched by them.
+type TrafficStats struct {
+	Bytes      uint64 `json:"Bytes"`
+	Packets    uint32 `json:"Packets"`
+	Drops      uint32 `json:"Drops"`
+	Overlimits uint32 `json:"Overlimits"`
+	Backlog    uint32 `json:"Backlog"` // bytes
+	Qlen       uint32 `json:"Qlen"`    // packets
+	ByteRate   uint64 `json:"ByteRate"`
+	PacketRate uint64 `json:"PacketRate"`
+}
+
+// Counters of a class at the last read, to compute the current rate when
+// kernel does not estimate it
+type statsSample struct {
+	bytes   uint64
+	packets uint32
+	at      time.Time
+}
+
+type statsKey struct {
+	linkIndex int
+	classId   uint16
+}
+
+var statsSamples map[statsKey]statsSample = make(map[statsKey]statsSample)
+var statsLock sync.Mutex
+
+// ClassStats reads the statistics of htb class 1:classId on link
+func ClassStats(linkName string, classId uint16) (*TrafficStats, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return nil, err
+	}
+
+	classStats, err := linkClassStats(link)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, ok := classStats[classId]
+	if !ok {
+		return nil, fmt.Errorf("class %x:%x not found on %s", 1, classId, linkName)
+	}
+
+	sampleRate(statsKey{link.Attrs().Index, classId}, stats)
+	return stats, nil
+}
+
+// LinkClassStats reads the statistics of all htb classes on link, keyed by
+// class ID. Rates are only set if kernel estimates them.
+func LinkClassStats(linkName string) (map[uint16]*TrafficStats, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		internalLogger.Println("Failed to get link, ", err)
+		return nil, err
+	}
+
+	return linkClassStats(link)
+}
+
+func linkClassStats(link netlink.Link) (map[uint16]*TrafficStats, error) {
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(1, 0))
+	if err != nil {
+		internalLogger.Println("Failed to list class, ", err)
+		return nil, err
+	}
+
+	classStats := make(map[uint16]*TrafficStats)
+	for _, class := range classes {
+		attrs := class.Attrs()
+		major, minor := netlink.MajorMinor(attrs.Handle)
+		if class.Type() != "htb" || major != 1 {
+			continue
+		}
+
+		stats := &TrafficStats{}
+		if attrs.Statistics != nil {
+			if basic := attrs.Statistics.Basic; basic != nil {
+				stats.Bytes = basic.Bytes
+				stats.Packets = basic.Packets
+			}
+			if queue := attrs.Statistics.Queue; queue != nil {
+				stats.Drops = queue.Drops
+				stats.Overlimits = queue.Overlimits
+				stats.Backlog = queue.Backlog
+				stats.Qlen = queue.Qlen
+			}
+			if rate := attrs.Statistics.RateEst; rate != nil {
+				stats.ByteRate = uint64(rate.Bps)
+				stats.PacketRate = uint64(rate.Pps)
+			}
+		}
+
+		classStats[minor] = stats
+	}
+
+	return classStats, nil
+}
+
+// Fill the rate of stats from the counters at the last read, if kernel has
+// no rate estimator on the class
+func sampleRate(key statsKey, stats *TrafficStats) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+
+	now := time.Now()
+	last, ok := statsSamples[key]
+	statsSamples[key] = statsSample{bytes: stats.Bytes, packets: stats.Packets, at: now}
+
+	if stats.ByteRate > 0 || !ok {
+		return
+	}
+
+	// Counters reset if the class was created again
+	elapsed := now.Sub(last.at).Seconds()
+	if elapsed <= 0 || stats.Bytes < last.bytes || stats.Packets < last.packets {
+		return
+	}
+
+	stats.ByteRate = uint64(float64(stats.Bytes-last.bytes) / elapsed)
+	stats.PacketRate = uint64(float64(stats.Packets-last.packets) / elapsed)
+}
+
+// Drop the last read of class, called when the class is deleted
+func forgetClassStats(link netlink.Link, classId uint16) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+
+	delete(statsSamples, statsKey{link.Attrs().Index, classId})
+}
@@ -17,6 +17,9 @@ func CreateVxlan(vxlanIntfName, vxlanId, localIp, remoteIp string) (*netlink.Vxl
 		VxlanId: vxlanIdInt,
 		SrcAddr: net.ParseIP(localIp),
 		Group:   net.ParseIP(remoteIp),
+		// Inherit tos of inner packets, so the DSCP remarked by slices
+		// reaches the transport network
+		TOS: 1,
 	}
 
 	err := netlink.LinkAdd(vxlanLink)
@@ -67,11 +70,20 @@ func DelVxlan(vxlanIntfName string) error {
 		return err
 	}
 
+	err = delIfb(vxlanLink)
+	if err != nil {
+		return err
+	}
+
 	err = netlink.LinkDel(vxlanLink)
 	if err != nil {
 		internalLogger.Println("Failed to delete VXLAN interface:", err)
 		return err
 	}
 
+	// Qdisc and classes are gone with the link
+	forgetClassIds(vxlanLink)
+	forgetLinkCapacity(vxlanIntfName)
+
 	return nil
 }
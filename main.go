@@ -7,10 +7,13 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/vishvananda/netlink"
@@ -23,7 +26,22 @@ var sysLogger *log.Logger
 
 // Map bridgeName to vxlanInterface
 var BridgeMap map[string]string = make(map[string]string)
-var SliceMap map[string]string = make(map[string]string)
+
+// Map bridgeName to the request the vxlan bridge is created with, guarded by
+// sliceLock. The reconciler creates the links again from it.
+var BridgeConfigMap map[string]VxlanInterfaceRequest = make(map[string]VxlanInterfaceRequest)
+
+// Linux bridges created by addBridge, guarded by sliceLock
+var LinuxBridgeMap map[string]bool = make(map[string]bool)
+
+// Map veth name to the veth pair created by addInterface, guarded by sliceLock
+var VethMap map[string]*VethLink = make(map[string]*VethLink)
+
+// Map bridgeName to installed slices (keyed by S-NSSAI, see Snssai.String)
+var SliceMap map[string]map[string]*Slice = make(map[string]map[string]*Slice)
+
+// Guard SliceMap and the tc rules it records
+var sliceLock sync.Mutex
 
 // @title Bridge API
 // @version 1.0
@@ -33,10 +51,14 @@ func main() {
 	sysLogger = log.New(os.Stdout, "[DEBUG] ", log.LstdFlags)
 
 	router := gin.Default()
+	router.Use(apiMetrics())
 
 	// register swagger url
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// register prometheus metrics url
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
 	//TODO: Decouple api functions to another module
 	v1 := router.Group("/api/v1")
 	{
@@ -48,11 +70,50 @@ func main() {
 		v1.GET("/bridge/:bridge_name", retrieveBridge)
 		v1.POST("/vxlan/:bridge_name/activate", activateVxlanBridge)
 		v1.DELETE("/vxlan/:bridge_name", delVxlanBridge)
+		v1.GET("/slice", listSlice)
+		v1.GET("/slice/:bridge_name", listBridgeSlice)
+		v1.GET("/slice/:bridge_name/:snssai", retrieveSlice)
+		v1.GET("/slice/:bridge_name/:snssai/stats", retrieveSliceStats)
 		v1.POST("/slice/:bridge_name", addSlice)
-		v1.DELETE("/slice/:bridge_name", delSlice)
+		v1.PATCH("/slice/:bridge_name/:snssai", updateSlice)
+		v1.DELETE("/slice/:bridge_name/:snssai", delSlice)
+		v1.GET("/slice/:bridge_name/:snssai/schedule", listSliceSchedule)
+		v1.POST("/slice/:bridge_name/:snssai/schedule", addSchedule)
+		v1.GET("/schedule", listSchedule)
+		v1.GET("/schedule/:schedule_id", retrieveSchedule)
+		v1.DELETE("/schedule/:schedule_id", delSchedule)
+		v1.GET("/profile", listProfile)
+		v1.GET("/profile/:profile_name", retrieveProfile)
+		v1.POST("/profile", addProfile)
+		v1.DELETE("/profile/:profile_name", delProfile)
+		v1.GET("/e2eslice", listE2eSlice)
+		v1.GET("/e2eslice/:snssai", retrieveE2eSlice)
+		v1.POST("/e2eslice", addE2eSlice)
+		v1.DELETE("/e2eslice/:snssai", delE2eSlice)
+		v1.GET("/tenant", listTenant)
+		v1.GET("/tenant/:tenant_id", retrieveTenant)
+		v1.POST("/tenant/:tenant_id/bridge/:bridge_name", addTenant)
+		v1.DELETE("/tenant/:tenant_id/bridge/:bridge_name", delTenant)
+		v1.POST("/tenant/:tenant_id/slice/:bridge_name", addTenantSlice)
+		v1.GET("/discovery", getDiscovery)
+		v1.POST("/reconcile", reconcileNow)
+		v1.GET("/reconcile", getReconcile)
+		v1.GET("/events", streamEvents)
+		v1.GET("/subscriptions", listSubscription)
+		v1.GET("/subscriptions/:subscription_id", retrieveSubscription)
+		v1.GET("/subscriptions/:subscription_id/deadletters", listDeadLetter)
+		v1.POST("/subscriptions", addSubscription)
+		v1.DELETE("/subscriptions/:subscription_id", delSubscription)
 	}
 
 	port := flag.String("port", "8080", "service port")
+	storeKind := flag.String("state-store", storeFile, "backend to keep bridges, veth links, slices and profiles across restarts: file, bolt or none")
+	storePath := flag.String("state-path", "", "path of the state store, /var/lib/tn-manager/state.json for file or state.db for bolt if not set")
+	reconcileInterval := flag.Duration("reconcile-interval", time.Minute, "interval to re-apply the recorded bridges, tenants and slices missing in kernel, 0 disables periodic reconcile")
+	scheduleFile := flag.String("schedule-file", "/var/lib/tn-manager/schedule.json", "file to keep slice schedules across restarts, not kept if empty")
+	flag.IntVar(&internal.DefaultLinkConfig.Capacity, "capacity", internal.DefaultLinkConfig.Capacity, "link capacity (KB/Sec) shared by slices on a vxlan interface, detected from underlay interface speed if not set")
+	flag.IntVar(&internal.DefaultLinkConfig.DefaultRate, "default-rate", internal.DefaultLinkConfig.DefaultRate, "guaranteed rate (KB/Sec) of unclassified traffic")
+	flag.IntVar(&internal.DefaultLinkConfig.DefaultCeil, "default-ceil", internal.DefaultLinkConfig.DefaultCeil, "ceil (KB/Sec) of unclassified traffic, defaults to capacity")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage：\n")
 		fmt.Fprintf(os.Stderr, "  %s [options]\n", os.Args[0])
@@ -61,6 +122,28 @@ func main() {
 	}
 	flag.Parse()
 
+	store, err := openStore(*storeKind, *storePath)
+	if err != nil {
+		sysLogger.Fatalln("Failed to open state store, ", err)
+	}
+	stateStore = store
+
+	// Restore the state kept by a previous run and adopt what else is left
+	// in kernel, before the schedules of the slices are loaded
+	report := discover()
+	sysLogger.Printf("Restored %d records, %d stale. Discovered %d bridges, %d tenants and %d slice classes, %d kernel objects not mapped\n",
+		len(report.Restored), len(report.Stale), len(report.Bridges), len(report.Tenants), len(report.Slices), len(report.Unmapped))
+
+	if err := loadSchedules(*scheduleFile); err != nil {
+		sysLogger.Println("Failed to load schedules, ", err)
+	}
+	go runScheduler()
+	go runEvents()
+
+	if *reconcileInterval > 0 {
+		go runReconciler(*reconcileInterval)
+	}
+
 	router.Run(":" + *port)
 }
 
@@ -80,7 +163,9 @@ func retrieveVxlanBridge(c *gin.Context) {
 	// If bridge doesn't record in BridgeMap (without vxlan interface binding), return 404
 	bridgeName := c.Param("bridge_name")
 
+	sliceLock.Lock()
 	_, ok := BridgeMap[bridgeName]
+	sliceLock.Unlock()
 	if ok {
 		c.String(http.StatusAccepted, "Vxlan bridge existed")
 	} else {
@@ -98,74 +183,257 @@ func retrieveVxlanBridge(c *gin.Context) {
 // @Produce json
 // @Param bridge_name path string true "Bridge name"
 // @Param request body SliceRequest true "Slice request"
-// @Success 204 {string} string "Slice Installed"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 202 {string} string "Slice Installed"
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 409 {object} AdmissionResponse "Slice existed, or not enough bandwidth"
 // @Router /api/v1/slice/{bridge_name} [post]
 func addSlice(c *gin.Context) {
 	bridgeName := c.Param("bridge_name")
 
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
 	var request SliceRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.String(http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	sysLogger.Println("bridgeName, ", bridgeName)
-	vxlanInterface, ok := BridgeMap[bridgeName]
+	if _, err := createSlice(bridgeName, "", request, plan); err != nil {
+		respondSliceError(c, err)
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
 
-	if ok {
-		sysLogger.Println("Add slice on interface, ", vxlanInterface)
-		classId, err := internal.AddQdisc(BridgeMap[bridgeName], request.FlowRate)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "Failed to add qdisc, ", err)
-			return
-		}
+	c.String(http.StatusAccepted, "Install Slice successful")
+}
 
-		err = internal.AddFilter(vxlanInterface, request.DstIp, strconv.Itoa(int(classId)))
-		if err != nil {
-			c.String(http.StatusInternalServerError, "Failed to add filter, ", err)
-			return
+// listSlice handles the GET /api/v1/slice endpoint.
+// It lists slices installed on all vxlan bridges.
+//
+// @Summary List slices
+// @Description
+// @Tags slice
+// @Produce json
+// @Success 200 {array} Slice
+// @Router /api/v1/slice [get]
+func listSlice(c *gin.Context) {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	slices := []Slice{}
+	for bridgeName := range SliceMap {
+		slices = append(slices, bridgeSlices(bridgeName)...)
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		if slices[i].Bridge != slices[j].Bridge {
+			return slices[i].Bridge < slices[j].Bridge
 		}
-	} else {
-		sysLogger.Println("Failed to find interface")
-		c.String(http.StatusInternalServerError, "Failed to find interface")
+		return slices[i].Snssai().less(slices[j].Snssai())
+	})
+
+	c.JSON(http.StatusOK, slices)
+}
+
+// listBridgeSlice handles the GET /api/v1/slice/:bridge_name endpoint.
+// It lists slices installed on the vxlan bridge, or on the interface of
+// end-to-end slice hops.
+//
+// @Summary List slices on bridge
+// @Description
+// @Tags slice
+// @Produce json
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Success 200 {array} Slice
+// @Failure 404 {string} string "Vxlan bridge not existed"
+// @Router /api/v1/slice/{bridge_name} [get]
+func listBridgeSlice(c *gin.Context) {
+	bridgeName := c.Param("bridge_name")
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	if !hasSlices(bridgeName) {
+		c.String(http.StatusNotFound, "Vxlan bridge not existed")
 		return
 	}
 
-	sysLogger.Println("Install slice successful, ", "SliceSD", request.SliceSd, "FlowRate (KB/Sec)", request.FlowRate)
-	c.String(http.StatusAccepted, "Install Slice successful")
+	c.JSON(http.StatusOK, bridgeSlices(bridgeName))
+}
+
+// retrieveSlice handles the GET /api/v1/slice/:bridge_name/:snssai endpoint.
+// It retrieve the slice installed on the vxlan bridge.
+//
+// @Summary Retrieve slice
+// @Description
+// @Tags slice
+// @Produce json
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Success 200 {object} Slice
+// @Failure 404 {string} string "Slice not found"
+// @Router /api/v1/slice/{bridge_name}/{snssai} [get]
+func retrieveSlice(c *gin.Context) {
+	bridgeName := c.Param("bridge_name")
+	snssai := c.Param("snssai")
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	slice, err := lookupSlice(bridgeName, snssai)
+	if err != nil {
+		c.String(lookupStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, slice)
+}
+
+// retrieveSliceStats handles the GET /api/v1/slice/:bridge_name/:snssai/stats endpoint.
+// It reads the traffic statistics of the slice classes.
+//
+// @Summary Retrieve slice statistics
+// @Description
+// @Tags slice
+// @Produce json
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Success 200 {object} SliceStats
+// @Failure 404 {string} string "Slice not found"
+// @Failure 500 {string} string "Failed to read slice statistics"
+// @Router /api/v1/slice/{bridge_name}/{snssai}/stats [get]
+func retrieveSliceStats(c *gin.Context) {
+	bridgeName := c.Param("bridge_name")
+	snssai := c.Param("snssai")
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	slice, err := lookupSlice(bridgeName, snssai)
+	if err != nil {
+		c.String(lookupStatus(err), err.Error())
+		return
+	}
+
+	stats, err := sliceStats(slice)
+	if err != nil {
+		sysLogger.Println("Failed to read slice statistics, ", err)
+		c.String(http.StatusInternalServerError, "Failed to read slice statistics")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// hasSlices reports whether name keys slices in SliceMap: a vxlan bridge, or
+// an interface with the slices of end-to-end hops. The caller must hold
+// sliceLock.
+func hasSlices(name string) bool {
+	if _, ok := BridgeMap[name]; ok {
+		return true
+	}
+	return len(SliceMap[name]) > 0
+}
+
+// bridgeSlices returns the slices of bridge sorted by S-NSSAI.
+// The caller must hold sliceLock.
+func bridgeSlices(bridgeName string) []Slice {
+	slices := []Slice{}
+	for _, slice := range SliceMap[bridgeName] {
+		slices = append(slices, *slice)
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		return slices[i].Snssai().less(slices[j].Snssai())
+	})
+
+	return slices
 }
 
 // retrieveBridge handles the GET /api/v1/bridge/:bridge_name endpoint.
 // It retrieve bridge status.
 //
 // @Summary Retrieve bridge status
-// @Description
+// @Description Retrieve the linux bridge of the given name with its ports, addresses, MTU, oper state and MAC, and whether TN-Manager owns it
 // @Tags bridge
 // @Accept json
 // @Produce json
 // @Param bridge_name path string true "Bridge name"
-// @Success 204 {string} string "Bridge existed"
+// @Success 200 {object} BridgeResponse
 // @Failure 404 {string} string "Bridge not found"
 // @Failure 500 {string} string "System error"
 // @Router /api/v1/bridge/{bridge_name} [GET]
 func retrieveBridge(c *gin.Context) {
 	vxlanBridgeName := c.Param("bridge_name")
 	sysLogger.Println("Get vxlan bridge name", "name", vxlanBridgeName)
-	link, err := internal.GetBridge(vxlanBridgeName)
-	if link == nil {
+	bridge, err := internal.ReadBridge(vxlanBridgeName)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "System error")
+		return
+	}
+	if bridge == nil {
 		c.String(http.StatusNotFound, "Bridge not found")
 		return
-	} else {
-		if err != nil {
-			c.String(http.StatusInternalServerError, "System error")
-			return
-		}
 	}
 
-	c.String(http.StatusNoContent, "Bridge existed")
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	c.JSON(http.StatusOK, bridgeResponse(*bridge))
 }
 
-// delSlice handles the DELETE /api/v1/slice/:bridge_name/:sliceSd endpoint.
+// updateSlice handles the PATCH /api/v1/slice/:bridge_name/:snssai endpoint.
+// It changes rate, ceil and matches of slice in place, fields not set are unchanged.
+//
+// @Summary Update slice on interface
+// @Description
+// @Tags slice
+// @Accept json
+// @Produce json
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Param request body SliceUpdateRequest true "Slice update request"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 200 {object} Slice "Updated slice, or DryRunResponse of dry run"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 404 {string} string "Slice not found"
+// @Failure 409 {object} AdmissionResponse "Not enough bandwidth"
+// @Failure 500 {string} string "Failed to update slice"
+// @Router /api/v1/slice/{bridge_name}/{snssai} [patch]
+func updateSlice(c *gin.Context) {
+	bridgeName := c.Param("bridge_name")
+	snssai := c.Param("snssai")
+
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	var request SliceUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	updated, err := modifySlice(bridgeName, snssai, request, plan)
+	if err != nil {
+		respondSliceError(c, err)
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// delSlice handles the DELETE /api/v1/slice/:bridge_name/:snssai endpoint.
 // It del slice (tc rule) on vxlan interface.
 //
 // @Summary Del slice on interface
@@ -173,16 +441,33 @@ func retrieveBridge(c *gin.Context) {
 // @Tags slice
 // @Accept json
 // @Produce json
-// @Param bridge_name path string true "Bridge name"
-// @Param slice_sd path string true "Slice SD identifier"
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Param dryRun query bool false "Return the planned changes without making them"
 // @Success 204 {string} string "Slice deletion successful"
-// @Router /api/v1/slice/{bridge_name}/{slice_sd}} [delete]
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 404 {string} string "Slice not found"
+// @Failure 500 {string} string "Failed to delete slice"
+// @Router /api/v1/slice/{bridge_name}/{snssai} [delete]
 func delSlice(c *gin.Context) {
-	sliceSd := c.Param("sliceSd")
-	bridge_name := c.Param("bridgeName")
+	bridgeName := c.Param("bridge_name")
+	snssai := c.Param("snssai")
 
-	sysLogger.Println("Delete slice ", "Slice SD", sliceSd, "Bridge", bridge_name)
-	c.String(http.StatusAccepted, "Slice deleted")
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	if err := deleteSlice(bridgeName, snssai, plan); err != nil {
+		respondSliceError(c, err)
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	c.String(http.StatusNoContent, "Slice deleted")
 }
 
 // addVxlanBridge handles the POST /api/v1/vxlan/:bridge_name endpoint.
@@ -195,12 +480,20 @@ func delSlice(c *gin.Context) {
 // @Produce json
 // @Param bridge_name path string true "Bridge name"
 // @Param request body VxlanInterfaceRequest true "Vxlan Interface request"
+// @Param dryRun query bool false "Return the planned changes without making them"
 // @Success 201 {string} string "Bridge created successfully"
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
 // @Failure 400 {string} string "Invalid bridge name"
 // @Router /api/v1/vxlan/{bridge_name} [post]
 func addVxlanBridge(c *gin.Context) {
 	vxlanBridgeName := c.Param("bridge_name")
 
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+	k := kernel(plan)
+
 	var request VxlanInterfaceRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.String(http.StatusBadRequest, "Invalid request body")
@@ -212,62 +505,79 @@ func addVxlanBridge(c *gin.Context) {
 	// Setup vxlan interface
 	sysLogger.Println("Create VXLAN interface: ", request.VxlanInterface)
 
-	vxlanLink, err := internal.CreateVxlan(request.VxlanInterface, request.VxlanId, request.BindInterface, request.RemoteIp)
+	err := k.CreateVxlan(request.VxlanInterface, request.VxlanId, request.BindInterface, request.RemoteIp)
 
 	if err != nil {
 		sysLogger.Println("Failed to create vxlan interface: ", err)
 		c.String(http.StatusInternalServerError, "Failed to create vxlan interface")
+		notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to create vxlan interface")
 		return
 	}
 
 	// Check if bridge exist
 	bridgeLink, _ := internal.GetBridge(vxlanBridgeName)
 	if bridgeLink == nil {
-		bridgeLink, err = internal.CreateBridge(vxlanBridgeName)
+		err = k.CreateBridge(vxlanBridgeName)
 		if err != nil {
 			sysLogger.Println("Failed to create bridge: ", err)
 			c.String(http.StatusInternalServerError, "Failed to create bridge")
+			notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to create bridge")
 			return
 		}
-	}
-
-	bridge, isBridge := bridgeLink.(*netlink.Bridge)
-	if !isBridge {
+	} else if _, isBridge := bridgeLink.(*netlink.Bridge); !isBridge {
 		sysLogger.Println("Failed to assert netlink.Bridge")
 		c.String(http.StatusInternalServerError, "The specified bridge is not of type netlink.Bridge")
+		notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "The specified bridge is not of type netlink.Bridge")
 		return
 	}
 
-	err = internal.SetVxlanMaster(vxlanLink, bridge)
+	err = k.SetMaster(request.VxlanInterface, vxlanBridgeName)
 	if err != nil {
 		sysLogger.Println("Failed to bind vxlan interface to bridge: ", err)
 		c.String(http.StatusInternalServerError, "Failed to bind vxlan to bridge")
+		notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to bind vxlan to bridge")
 		return
 	}
 
-	err = internal.SetBridgeIp(request.LocalBridgeIp, bridgeLink)
+	err = k.SetBridgeIp(request.LocalBridgeIp, vxlanBridgeName)
 	if err != nil {
 		sysLogger.Println("Failed to configure bridge ipv4 addr: ", err)
 		c.String(http.StatusInternalServerError, "Failed to set bridge ip")
+		notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to set bridge ip")
 		return
 	}
 
 	// Activate bridge and vxlan
-	err = netlink.LinkSetUp(vxlanLink)
+	err = k.LinkSetUp(request.VxlanInterface)
 	if err != nil {
 		sysLogger.Println("Failed to activate vxlan interface: ", err)
 		c.String(http.StatusInternalServerError, "Failed to enable vxlan interface")
+		notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to enable vxlan interface")
 		return
 	}
 
-	err = netlink.LinkSetUp(bridgeLink)
+	err = k.LinkSetUp(vxlanBridgeName)
 	if err != nil {
 		sysLogger.Println("Failed to activate bridge: ", err)
 		c.String(http.StatusInternalServerError, "Failed to enable bridge")
+		notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to enable bridge")
 		return
 	}
 
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	capacity := internal.SetLinkCapacity(request.VxlanInterface, request.BindInterface, request.Capacity)
+	sysLogger.Println("Vxlan interface capacity (KB/Sec): ", capacity)
+
+	sliceLock.Lock()
 	BridgeMap[vxlanBridgeName] = request.VxlanInterface
+	BridgeConfigMap[vxlanBridgeName] = request
+	saveState()
+	notifyBridge(nil, NotifyBridgeCreated, vxlanBridgeName, "")
+	sliceLock.Unlock()
 
 	response := fmt.Sprintf("Bridge %s created successfully", vxlanBridgeName)
 	c.String(http.StatusCreated, response)
@@ -282,28 +592,43 @@ func addVxlanBridge(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param bridge_name path string true "Bridge name"
+// @Param dryRun query bool false "Return the planned changes without making them"
 // @Success 204 {string} string "Bridge Activated"
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
 // @Failure 400 {string} string "Invalid bridge name"
 // @Router /api/v1/vxlan/{bridge_name}/activate [post]
 func activateVxlanBridge(c *gin.Context) {
 	vxlanBridgeName := c.Param("bridge_name")
 
-	cmd := exec.Command("ip", "link", "set", BridgeMap[vxlanBridgeName], "up")
-	err := cmd.Run()
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+	k := kernel(plan)
+
+	sliceLock.Lock()
+	vxlanIf := BridgeMap[vxlanBridgeName]
+	sliceLock.Unlock()
+
+	err := k.LinkSetUp(vxlanIf)
 	if err != nil {
 		sysLogger.Println("Failed to enable vxlan interface: ", err)
 		c.String(http.StatusInternalServerError, "Failed to enable vxlan interface")
 		return
 	}
 
-	cmd = exec.Command("ip", "link", "set", vxlanBridgeName, "up")
-	err = cmd.Run()
+	err = k.LinkSetUp(vxlanBridgeName)
 	if err != nil {
 		sysLogger.Println("Failed to enable bridge: ", err)
 		c.String(http.StatusInternalServerError, "Failed to enable bridge")
 		return
 	}
 
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
 	c.String(http.StatusAccepted, "Bridge Activated")
 }
 
@@ -316,41 +641,68 @@ func activateVxlanBridge(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param bridge_name path string true "Bridge name"
-// @Success 200 {string} string "Bridge delete successfully"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 200 {string} string "Bridge delete successfully, or DryRunResponse of dry run"
 // @Router /api/v1/vxlan/{bridge_name} [delete]
 func delVxlanBridge(c *gin.Context) {
 	vxlanBridgeName := c.Param("bridge_name")
 
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+	k := kernel(plan)
+
+	// Held across the kernel changes, so the reconciler does not create the
+	// links again before the bridge is removed from map
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
 	if vxlanIf, exist := BridgeMap[vxlanBridgeName]; exist {
 		// Disable device
 		sysLogger.Println("Disable device")
 
 		// Set vxlan interface down and unbound vxlan from bridge
-		err := internal.SetVxlanDown(vxlanIf)
+		err := k.SetVxlanDown(vxlanIf)
 		if err != nil {
 			sysLogger.Println("Failed to disable device ", vxlanIf)
 			c.String(http.StatusInternalServerError, "Failed to disable vxlan interface")
+			notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to disable vxlan interface")
 			return
 		}
 
 		// Remove bridge
-		err = internal.DelBridge(vxlanBridgeName)
+		err = k.DelBridge(vxlanBridgeName)
 		if err != nil {
 			sysLogger.Println("Failed to disable device ", vxlanBridgeName)
 			c.String(http.StatusInternalServerError, "Failed to disable bridge")
+			notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to disable bridge")
 			return
 		}
 
 		// Remove vxlan interface
-		err = internal.DelVxlan(vxlanIf)
+		err = k.DelVxlan(vxlanIf)
 		if err != nil {
 			sysLogger.Println("Failed to delete device ", vxlanIf)
 			c.String(http.StatusInternalServerError, "Failed to delete device")
+			notifyBridge(plan, NotifyBridgeFailed, vxlanBridgeName, "Failed to delete device")
+			return
+		}
+
+		if plan != nil {
+			respondPlan(c, plan)
 			return
 		}
 
-		// Remove from map
+		// Remove from map, the slices are gone with the vxlan interface
 		delete(BridgeMap, vxlanBridgeName)
+		delete(BridgeConfigMap, vxlanBridgeName)
+		delete(LinuxBridgeMap, vxlanBridgeName)
+		delete(SliceMap, vxlanBridgeName)
+		delete(TenantMap, vxlanBridgeName)
+		delete(DiscoveredSliceMap, vxlanBridgeName)
+		saveState()
+		notifyBridge(nil, NotifyBridgeDeleted, vxlanBridgeName, "")
 	} else {
 		// Bridge not exist
 		c.String(http.StatusNotFound, "Bridge not found")
@@ -361,23 +713,47 @@ func delVxlanBridge(c *gin.Context) {
 }
 
 // getBridge handles the GET /api/v1/bridge endpoint.
-// It returns the current bridge and connected interface.
+// It lists the linux bridges of the host with their ports.
 //
-// @Summary Get current bridge and connected interface
-// @Description Get the current bridge and its connected interface
+// @Summary List bridges
+// @Description List the linux bridges of the host with their ports (vxlan, veth, physical), addresses, MTU, oper state and MAC, and whether TN-Manager owns them
 // @Tags bridge
 // @Produce json
-// @Success 200 {object} BridgeResponse
+// @Param managed query bool false "Only the bridges TN-Manager owns"
+// @Success 200 {array} BridgeResponse
+// @Failure 400 {string} string "Invalid managed"
+// @Failure 500 {string} string "System error"
 // @Router /api/v1/bridge [get]
 func getBridge(c *gin.Context) {
-	//TODO: retrieve current bridge and interfaces
-	bridgeName := "bridge0"
-	interfaceName := "eth0"
-	response := BridgeResponse{
-		Bridge:    bridgeName,
-		Interface: interfaceName,
-	}
-	c.JSON(http.StatusOK, response)
+	managedOnly := false
+	if value, ok := c.GetQuery("managed"); ok {
+		var err error
+		if managedOnly, err = strconv.ParseBool(value); err != nil {
+			c.String(http.StatusBadRequest, "Invalid managed, true or false is required")
+			return
+		}
+	}
+
+	bridges, err := internal.ListBridges()
+	if err != nil {
+		sysLogger.Println("Failed to list bridge: ", err)
+		c.String(http.StatusInternalServerError, "System error")
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	list := []BridgeResponse{}
+	for _, bridge := range bridges {
+		response := bridgeResponse(bridge)
+		if managedOnly && !response.Managed {
+			continue
+		}
+		list = append(list, response)
+	}
+
+	c.JSON(http.StatusOK, list)
 }
 
 // addBridge handles the POST /api/v1/bridge/:bridge_name endpoint.
@@ -389,21 +765,37 @@ func getBridge(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param bridge_name path string true "Bridge name"
-// @Success 200 {string} string "Bridge created successfully"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 200 {string} string "Bridge created successfully, or DryRunResponse of dry run"
 // @Failure 400 {string} string "Invalid bridge name"
 // @Router /api/v1/bridge/{bridge_name} [post]
 func addBridge(c *gin.Context) {
 
 	bridgeName := c.Param("bridge_name")
 
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
 	//err := createBridge(bridgeName)
-	_, err := internal.CreateBridge(bridgeName)
+	err := kernel(plan).CreateBridge(bridgeName)
 
 	if err != nil {
 		c.String(http.StatusBadRequest, fmt.Sprintf("Failed to create bridge: %s", err.Error()))
 		return
 	}
 
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	sliceLock.Lock()
+	LinuxBridgeMap[bridgeName] = true
+	saveState()
+	sliceLock.Unlock()
+
 	response := fmt.Sprintf("Bridge %s created successfully", bridgeName)
 	c.String(http.StatusOK, response)
 }
@@ -417,10 +809,16 @@ func addBridge(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param request body InterfaceRequest true "Interface request"
-// @Success 200 {string} string "Interface added successfully"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 200 {string} string "Interface added successfully, or DryRunResponse of dry run"
 // @Failure 400 {string} string "Invalid request body"
 // @Router /api/v1/interface [post]
 func addInterface(c *gin.Context) {
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
 	var request InterfaceRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.String(http.StatusBadRequest, "Invalid request body")
@@ -428,56 +826,69 @@ func addInterface(c *gin.Context) {
 	}
 
 	// create veth-pair between two Linux bridge
-	err := createVethPair(request.Bridge1, request.Bridge2)
+	veth, err := createVethPair(kernel(plan), request.Bridge1, request.Bridge2)
 	if err != nil {
 		c.String(http.StatusBadRequest, fmt.Sprintf("Failed to create veth pair: %s", err.Error()))
 		return
 	}
 
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	sliceLock.Lock()
+	VethMap[veth.Name] = veth
+	saveState()
+	sliceLock.Unlock()
+
 	response := "Interface added successfully"
 	c.String(http.StatusOK, response)
 }
 
+// VethLink is a veth pair between two Linux bridges
+type VethLink struct {
+	Name       string `json:"Name"`
+	Bridge     string `json:"Bridge"`
+	Peer       string `json:"Peer"`
+	PeerBridge string `json:"PeerBridge"`
+}
+
 // createVethPair creates a veth pair between two Linux bridges.
-func createVethPair(bridge1, bridge2 string) error {
+func createVethPair(k internal.Kernel, bridge1, bridge2 string) (*VethLink, error) {
 	vethName1 := bridge1 + "-veth" + generateRandomString(4)
 	vethName2 := bridge2 + "-veth"
 	sysLogger.Println("Create veth-pair: ", vethName1, vethName2)
 
-	cmd := exec.Command("ip", "link", "add", "name", vethName1, "type", "veth", "peer", "name", vethName2)
-	err := cmd.Run()
+	err := k.CreateVeth(vethName1, vethName2)
 	if err != nil {
-		sysLogger.Println("Fail to exec create veth-pair command: ", err)
-		return err
+		sysLogger.Println("Fail to create veth-pair: ", err)
+		return nil, err
 	}
 
-	cmd = exec.Command("brctl", "addif", bridge1, vethName1)
-	err = cmd.Run()
+	err = k.SetMaster(vethName1, bridge1)
 	if err != nil {
-		sysLogger.Println("Fail to exec add veth to bridge command: ", err)
-		return err
+		sysLogger.Println("Fail to add veth to bridge: ", err)
+		return nil, err
 	}
 
-	cmd = exec.Command("brctl", "addif", bridge2, vethName2)
-	err = cmd.Run()
+	err = k.SetMaster(vethName2, bridge2)
 	if err != nil {
-		sysLogger.Println("Fail to exec add veth to bridge command: ", err)
-		return err
+		sysLogger.Println("Fail to add veth to bridge: ", err)
+		return nil, err
 	}
 
-	cmd = exec.Command("ip", "link", "set", "dev", vethName1, "up")
-	err = cmd.Run()
+	err = k.LinkSetUp(vethName1)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cmd = exec.Command("ip", "link", "set", "dev", vethName2, "up")
-	err = cmd.Run()
+	err = k.LinkSetUp(vethName2)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &VethLink{Name: vethName1, Bridge: bridge1, Peer: vethName2, PeerBridge: bridge2}, nil
 }
 
 func generateRandomString(length int) string {
@@ -496,10 +907,36 @@ func generateRandomString(length int) string {
 	return string(randomBytes)
 }
 
-// BridgeResponse represents the response for the getBridge endpoint.
+// BridgeResponse is a linux bridge of the host with its ports, returned by
+// the getBridge and retrieveBridge endpoints.
 type BridgeResponse struct {
-	Bridge    string `json:"bridge"`
-	Interface string `json:"interface"`
+	internal.BridgeInfo
+	// TN-Manager owns the bridge: a vxlan bridge it created or adopted, a
+	// bridge created by the bridge api, or a bridge it connected with a veth
+	// pair
+	Managed bool `json:"Managed"`
+	// Vxlan interface of managed bridges
+	VxlanInterface string `json:"VxlanInterface,omitempty"`
+}
+
+// bridgeResponse tells whether TN-Manager owns bridge. The caller must hold
+// sliceLock.
+func bridgeResponse(bridge internal.BridgeInfo) BridgeResponse {
+	vxlanIf, managed := BridgeMap[bridge.Name]
+	if LinuxBridgeMap[bridge.Name] {
+		managed = true
+	}
+	for _, veth := range VethMap {
+		if veth.Bridge == bridge.Name || veth.PeerBridge == bridge.Name {
+			managed = true
+		}
+	}
+
+	return BridgeResponse{
+		BridgeInfo:     bridge,
+		Managed:        managed,
+		VxlanInterface: vxlanIf,
+	}
 }
 
 // InterfaceRequest represents the request body for the addInterface endpoint.
@@ -515,11 +952,198 @@ type VxlanInterfaceRequest struct {
 	//LocalBridgeName		string	`json:"localBrName"`
 	RemoteIp      string `json:"remoteIp"`
 	LocalBridgeIp string `json:"localBrIp"`
+	// Capacity (KB/Sec) shared by slices, defaults to -capacity or the link speed of bindInterface
+	Capacity int `json:"capacity,omitempty"`
 }
 
 type SliceRequest struct {
-	FlowRate int    `json:"FlowRate"`
-	SliceSd  string `json:"SliceSD,omitempty"`
-	DstIp    string `json:"DstIP"`
-	SrcIp    string `json:"SrcIP"`
+	// Name of the slice profile, fields set in the request override the profile
+	Profile string `json:"Profile,omitempty"`
+	// Downlink rate (KB/Sec), kept for compatibility with DownlinkRate
+	FlowRate int `json:"FlowRate"`
+	// Downlink rate (KB/Sec), defaults to FlowRate
+	DownlinkRate int `json:"DownlinkRate,omitempty"`
+	// Downlink ceil (KB/Sec), defaults to downlink rate
+	DownlinkCeil int `json:"DownlinkCeil,omitempty"`
+	// Uplink rate (KB/Sec), uplink is not shaped if not set
+	UplinkRate int `json:"UplinkRate,omitempty"`
+	// Uplink ceil (KB/Sec), defaults to uplink rate
+	UplinkCeil int `json:"UplinkCeil,omitempty"`
+	// Burst and cburst (bytes) of slice classes, computed from rate and ceil if not set
+	Burst  uint32 `json:"Burst,omitempty"`
+	Cburst uint32 `json:"Cburst,omitempty"`
+	// Priority to borrow idle bandwidth, 0 (highest) - 7
+	Prio *uint32 `json:"Prio,omitempty"`
+	// Latency, jitter and loss emulation of the slice
+	Netem *internal.NetemSpec `json:"Netem,omitempty"`
+	// DSCP / 802.1p rewrite of downlink traffic of the slice
+	Remark *internal.Remark `json:"Remark,omitempty"`
+	// S-NSSAI of the slice, SST defaults to the SST of Profile, or 1 (eMBB)
+	// for the clients of slices before SST
+	Sst     *uint8 `json:"SST,omitempty"`
+	SliceSd string `json:"SliceSD,omitempty"`
+	DstIp   string `json:"DstIP"`
+	SrcIp   string `json:"SrcIP"`
+	// Additional match rules sharing the slice class
+	Matches []internal.Match `json:"Matches,omitempty"`
+}
+
+// matches returns the match rules of the request, DstIP/SrcIP is taken as the first rule
+func (r SliceRequest) matches() []internal.Match {
+	matches := []internal.Match{}
+	if r.DstIp != "" || r.SrcIp != "" {
+		matches = append(matches, internal.Match{DstIp: r.DstIp, SrcIp: r.SrcIp})
+	}
+
+	return append(matches, r.Matches...)
+}
+
+// newSlice builds the slice of request, fields not set in request are taken
+// from profile if it is not nil
+func (r SliceRequest) newSlice(profile *SliceProfile) (*Slice, error) {
+	sd, err := normalizeSd(r.SliceSd)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SliceSD: %s", err.Error())
+	}
+
+	slice := &Slice{
+		SliceSd:      sd,
+		DstIp:        r.DstIp,
+		SrcIp:        r.SrcIp,
+		Matches:      r.matches(),
+		DownlinkRate: r.DownlinkRate,
+		DownlinkCeil: r.DownlinkCeil,
+		UplinkRate:   r.UplinkRate,
+		UplinkCeil:   r.UplinkCeil,
+		Burst:        r.Burst,
+		Cburst:       r.Cburst,
+		Netem:        r.Netem,
+		Remark:       r.Remark,
+	}
+
+	if slice.DownlinkRate == 0 {
+		slice.DownlinkRate = r.FlowRate
+	}
+
+	if profile != nil {
+		slice.Profile = profile.Name
+		// ceil of profile only applies with the rate of profile
+		if slice.DownlinkRate == 0 {
+			slice.DownlinkRate = profile.DownlinkRate
+			if slice.DownlinkCeil == 0 {
+				slice.DownlinkCeil = profile.DownlinkCeil
+			}
+		}
+		if slice.UplinkRate == 0 {
+			slice.UplinkRate = profile.UplinkRate
+			if slice.UplinkCeil == 0 {
+				slice.UplinkCeil = profile.UplinkCeil
+			}
+		}
+		if slice.Netem == nil && profile.Netem != nil {
+			netem := *profile.Netem
+			slice.Netem = &netem
+		}
+		if r.Prio == nil {
+			slice.Prio = profile.Prio
+		}
+		if profile.Dscp != nil {
+			for i := range slice.Matches {
+				if slice.Matches[i].Dscp == nil {
+					dscp := *profile.Dscp
+					slice.Matches[i].Dscp = &dscp
+				}
+			}
+		}
+	}
+
+	if r.Prio != nil {
+		slice.Prio = *r.Prio
+	}
+	slice.FlowRate = slice.DownlinkRate
+
+	switch {
+	case r.Sst != nil:
+		slice.Sst = *r.Sst
+	case profile != nil:
+		slice.Sst = profile.Sst
+	default:
+		slice.Sst = SstEmbb
+	}
+
+	return slice, nil
+}
+
+// SliceUpdateRequest represents the request body for the updateSlice endpoint.
+// Rates not set (0) are unchanged, matches are replaced if any of DstIP, SrcIP
+// and Matches is set.
+type SliceUpdateRequest struct {
+	DownlinkRate int `json:"DownlinkRate,omitempty"`
+	DownlinkCeil int `json:"DownlinkCeil,omitempty"`
+	UplinkRate   int `json:"UplinkRate,omitempty"`
+	UplinkCeil   int `json:"UplinkCeil,omitempty"`
+	// Remove the uplink shaping, UplinkRate and UplinkCeil are ignored
+	RemoveUplink bool    `json:"RemoveUplink,omitempty"`
+	Burst        uint32  `json:"Burst,omitempty"`
+	Cburst       uint32  `json:"Cburst,omitempty"`
+	Prio         *uint32 `json:"Prio,omitempty"`
+	// Netem of all zero values removes the emulation
+	Netem *internal.NetemSpec `json:"Netem,omitempty"`
+	// Remark without Dscp and Pcp removes the rewrite
+	Remark  *internal.Remark `json:"Remark,omitempty"`
+	DstIp   string           `json:"DstIP,omitempty"`
+	SrcIp   string           `json:"SrcIP,omitempty"`
+	Matches []internal.Match `json:"Matches,omitempty"`
+}
+
+// apply returns a copy of slice with the request applied
+func (r SliceUpdateRequest) apply(slice *Slice) (*Slice, error) {
+	updated := *slice
+
+	if r.DownlinkRate != 0 {
+		// ceil follows the rate unless it is set
+		updated.DownlinkRate, updated.DownlinkCeil = r.DownlinkRate, 0
+		updated.FlowRate = r.DownlinkRate
+	}
+	if r.DownlinkCeil != 0 {
+		updated.DownlinkCeil = r.DownlinkCeil
+	}
+	if r.UplinkRate != 0 {
+		updated.UplinkRate, updated.UplinkCeil = r.UplinkRate, 0
+	}
+	if r.UplinkCeil != 0 {
+		updated.UplinkCeil = r.UplinkCeil
+	}
+	if r.RemoveUplink {
+		updated.UplinkRate, updated.UplinkCeil = 0, 0
+	}
+	if r.Burst != 0 {
+		updated.Burst = r.Burst
+	}
+	if r.Cburst != 0 {
+		updated.Cburst = r.Cburst
+	}
+	if r.Prio != nil {
+		updated.Prio = *r.Prio
+	}
+	if r.Netem != nil {
+		updated.Netem = r.Netem
+	}
+	if r.Remark != nil {
+		updated.Remark = r.Remark
+	}
+
+	matches := SliceRequest{DstIp: r.DstIp, SrcIp: r.SrcIp, Matches: r.Matches}.matches()
+	if len(matches) > 0 {
+		if err := internal.ValidateMatches(matches); err != nil {
+			return nil, fmt.Errorf("Invalid match: %s", err.Error())
+		}
+		updated.DstIp, updated.SrcIp, updated.Matches = r.DstIp, r.SrcIp, matches
+	}
+
+	if err := updated.validateRate(); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+const metricsNamespace = "tn_manager"
+
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "API requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "API request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(apiRequests, apiRequestDuration, newTnCollector())
+}
+
+// apiMetrics counts and times API requests by route template, so slices of
+// different bridges share one series
+func apiMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		apiRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		apiRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// tnCollector reads link and slice counters from kernel on each scrape
+type tnCollector struct {
+	linkBytes   *prometheus.Desc
+	linkPackets *prometheus.Desc
+	linkErrors  *prometheus.Desc
+	linkDropped *prometheus.Desc
+
+	sliceBytes      *prometheus.Desc
+	slicePackets    *prometheus.Desc
+	sliceDrops      *prometheus.Desc
+	sliceOverlimits *prometheus.Desc
+	sliceBacklog    *prometheus.Desc
+	sliceRate       *prometheus.Desc
+}
+
+func newTnCollector() *tnCollector {
+	linkLabels := []string{"bridge", "vni", "link", "kind", "direction"}
+	sliceLabels := []string{"bridge", "vni", "sst", "slice_sd", "direction"}
+
+	return &tnCollector{
+		linkBytes:   prometheus.NewDesc(metricsNamespace+"_link_bytes_total", "Bytes received (rx) or transmitted (tx) by the bridge or vxlan interface.", linkLabels, nil),
+		linkPackets: prometheus.NewDesc(metricsNamespace+"_link_packets_total", "Packets received (rx) or transmitted (tx) by the bridge or vxlan interface.", linkLabels, nil),
+		linkErrors:  prometheus.NewDesc(metricsNamespace+"_link_errors_total", "Receive (rx) or transmit (tx) errors of the bridge or vxlan interface.", linkLabels, nil),
+		linkDropped: prometheus.NewDesc(metricsNamespace+"_link_dropped_total", "Packets dropped on receive (rx) or transmit (tx) by the bridge or vxlan interface.", linkLabels, nil),
+
+		sliceBytes:      prometheus.NewDesc(metricsNamespace+"_slice_bytes_total", "Bytes sent by the htb class of the slice.", sliceLabels, nil),
+		slicePackets:    prometheus.NewDesc(metricsNamespace+"_slice_packets_total", "Packets sent by the htb class of the slice.", sliceLabels, nil),
+		sliceDrops:      prometheus.NewDesc(metricsNamespace+"_slice_drops_total", "Packets dropped by the htb class of the slice.", sliceLabels, nil),
+		sliceOverlimits: prometheus.NewDesc(metricsNamespace+"_slice_overlimits_total", "Times the htb class of the slice exceeded its rate.", sliceLabels, nil),
+		sliceBacklog:    prometheus.NewDesc(metricsNamespace+"_slice_backlog_bytes", "Bytes queued in the htb class of the slice.", sliceLabels, nil),
+		sliceRate:       prometheus.NewDesc(metricsNamespace+"_slice_guaranteed_bytes_per_second", "Configured guaranteed rate of the slice.", sliceLabels, nil),
+	}
+}
+
+func (collector *tnCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.linkBytes
+	ch <- collector.linkPackets
+	ch <- collector.linkErrors
+	ch <- collector.linkDropped
+	ch <- collector.sliceBytes
+	ch <- collector.slicePackets
+	ch <- collector.sliceDrops
+	ch <- collector.sliceOverlimits
+	ch <- collector.sliceBacklog
+	ch <- collector.sliceRate
+}
+
+func (collector *tnCollector) Collect(ch chan<- prometheus.Metric) {
+	sliceLock.Lock()
+	bridges := make(map[string]string, len(BridgeMap))
+	for bridgeName, vxlanName := range BridgeMap {
+		bridges[bridgeName] = vxlanName
+	}
+	slices := []Slice{}
+	for bridgeName := range SliceMap {
+		slices = append(slices, bridgeSlices(bridgeName)...)
+	}
+	sliceLock.Unlock()
+
+	vnis := make(map[string]string, len(bridges))
+	for bridgeName, vxlanName := range bridges {
+		vni := ""
+		if link, err := netlink.LinkByName(vxlanName); err == nil {
+			if vxlan, ok := link.(*netlink.Vxlan); ok {
+				vni = strconv.Itoa(vxlan.VxlanId)
+			}
+		}
+		vnis[bridgeName] = vni
+
+		collector.collectLink(ch, bridgeName, vni, bridgeName, "bridge")
+		collector.collectLink(ch, bridgeName, vni, vxlanName, "vxlan")
+	}
+
+	// Read the classes of each link once per scrape
+	linkStats := make(map[string]map[uint16]*internal.TrafficStats)
+	classStats := func(linkName string, classId uint16) *internal.TrafficStats {
+		if _, ok := linkStats[linkName]; !ok {
+			stats, err := internal.LinkClassStats(linkName)
+			if err != nil {
+				sysLogger.Println("Failed to read class statistics of ", linkName, err)
+			}
+			linkStats[linkName] = stats
+		}
+		return linkStats[linkName][classId]
+	}
+
+	for _, slice := range slices {
+		vni := vnis[slice.Bridge]
+
+		if stats := classStats(slice.VxlanInterface, slice.ClassId); stats != nil {
+			collector.collectSlice(ch, slice.Bridge, vni, slice.Snssai(), "downlink", stats, slice.DownlinkRate)
+		}
+		if slice.IfbInterface == "" {
+			continue
+		}
+		if stats := classStats(slice.IfbInterface, slice.UplinkClassId); stats != nil {
+			collector.collectSlice(ch, slice.Bridge, vni, slice.Snssai(), "uplink", stats, slice.UplinkRate)
+		}
+	}
+}
+
+func (collector *tnCollector) collectLink(ch chan<- prometheus.Metric, bridgeName, vni, linkName, kind string) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil || link.Attrs().Statistics == nil {
+		return
+	}
+	stats := link.Attrs().Statistics
+
+	counter := func(desc *prometheus.Desc, rx, tx uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(rx), bridgeName, vni, linkName, kind, "rx")
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(tx), bridgeName, vni, linkName, kind, "tx")
+	}
+
+	counter(collector.linkBytes, stats.RxBytes, stats.TxBytes)
+	counter(collector.linkPackets, stats.RxPackets, stats.TxPackets)
+	counter(collector.linkErrors, stats.RxErrors, stats.TxErrors)
+	counter(collector.linkDropped, stats.RxDropped, stats.TxDropped)
+}
+
+func (collector *tnCollector) collectSlice(ch chan<- prometheus.Metric, bridgeName, vni string, snssai Snssai, direction string, stats *internal.TrafficStats, rate int) {
+	labels := []string{bridgeName, vni, strconv.Itoa(int(snssai.Sst)), snssai.Sd, direction}
+
+	ch <- prometheus.MustNewConstMetric(collector.sliceBytes, prometheus.CounterValue, float64(stats.Bytes), labels...)
+	ch <- prometheus.MustNewConstMetric(collector.slicePackets, prometheus.CounterValue, float64(stats.Packets), labels...)
+	ch <- prometheus.MustNewConstMetric(collector.sliceDrops, prometheus.CounterValue, float64(stats.Drops), labels...)
+	ch <- prometheus.MustNewConstMetric(collector.sliceOverlimits, prometheus.CounterValue, float64(stats.Overlimits), labels...)
+	ch <- prometheus.MustNewConstMetric(collector.sliceBacklog, prometheus.GaugeValue, float64(stats.Backlog), labels...)
+	// KB/Sec to bytes/sec, to compare with rate(slice_bytes_total)
+	ch <- prometheus.MustNewConstMetric(collector.sliceRate, prometheus.GaugeValue, float64(rate*1000), labels...)
+}
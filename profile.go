@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Standardized slice/service types, 3GPP TS 23.501 5.15.2.2
+const (
+	SstEmbb  uint8 = 1
+	SstUrllc uint8 = 2
+	SstMiot  uint8 = 3
+)
+
+// SliceProfile holds the defaults of slices of a service type, fields of
+// SliceRequest override them
+type SliceProfile struct {
+	Name string `json:"Name"`
+	// Slice/service type, 1-255
+	Sst uint8 `json:"SST"`
+	// Rates (KB/Sec), ceil defaults to the rate
+	DownlinkRate int `json:"DownlinkRate"`
+	DownlinkCeil int `json:"DownlinkCeil,omitempty"`
+	UplinkRate   int `json:"UplinkRate,omitempty"`
+	UplinkCeil   int `json:"UplinkCeil,omitempty"`
+	// Priority to borrow idle bandwidth, 0 (highest) - 7
+	Prio  uint32              `json:"Prio"`
+	Netem *internal.NetemSpec `json:"Netem,omitempty"`
+	// Dscp of match rules which do not set one, 0-63
+	Dscp *uint8 `json:"Dscp,omitempty"`
+}
+
+// Map profile name to profile, seeded with the standardized service types
+var ProfileMap map[string]*SliceProfile = defaultProfiles()
+
+// Guard ProfileMap. It is changed holding sliceLock and then profileLock, and
+// read holding either, so saveState reads it under sliceLock.
+var profileLock sync.Mutex
+
+func defaultProfiles() map[string]*SliceProfile {
+	ef := uint8(46)
+
+	return map[string]*SliceProfile{
+		"eMBB": {
+			Name:         "eMBB",
+			Sst:          SstEmbb,
+			DownlinkRate: 12500,
+			DownlinkCeil: 62500,
+			UplinkRate:   2500,
+			UplinkCeil:   12500,
+			Prio:         4,
+		},
+		"URLLC": {
+			Name:         "URLLC",
+			Sst:          SstUrllc,
+			DownlinkRate: 1250,
+			DownlinkCeil: 2500,
+			UplinkRate:   1250,
+			UplinkCeil:   2500,
+			Prio:         0,
+			Netem:        &internal.NetemSpec{Delay: 1},
+			Dscp:         &ef,
+		},
+		"mIoT": {
+			Name:         "mIoT",
+			Sst:          SstMiot,
+			DownlinkRate: 125,
+			DownlinkCeil: 625,
+			UplinkRate:   125,
+			UplinkCeil:   625,
+			Prio:         6,
+		},
+	}
+}
+
+// validate checks the fields of profile
+func (profile *SliceProfile) validate() error {
+	if profile.Name == "" {
+		return errors.New("Name is required")
+	}
+	if profile.Sst == 0 {
+		return errors.New("SST should be 1-255")
+	}
+	if profile.DownlinkRate <= 0 {
+		return errors.New("DownlinkRate is required")
+	}
+
+	// Check the rates as a slice would take them
+	slice := Slice{
+		DownlinkRate: profile.DownlinkRate,
+		DownlinkCeil: profile.DownlinkCeil,
+		UplinkRate:   profile.UplinkRate,
+		UplinkCeil:   profile.UplinkCeil,
+		Prio:         profile.Prio,
+		Netem:        profile.Netem,
+	}
+	if err := slice.validateRate(); err != nil {
+		return err
+	}
+
+	if profile.Dscp != nil && *profile.Dscp > 63 {
+		return errors.New("Dscp should be 0-63")
+	}
+
+	return nil
+}
+
+// lookupProfile returns a copy of the profile, so slices created from it are
+// not affected by later changes of the profile
+func lookupProfile(name string) (*SliceProfile, bool) {
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	profile, ok := ProfileMap[name]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *profile
+	return &copied, true
+}
+
+// listProfile handles the GET /api/v1/profile endpoint.
+// It lists slice profiles.
+//
+// @Summary List slice profiles
+// @Description
+// @Tags profile
+// @Produce json
+// @Success 200 {array} SliceProfile
+// @Router /api/v1/profile [get]
+func listProfile(c *gin.Context) {
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	profiles := []SliceProfile{}
+	for _, profile := range ProfileMap {
+		profiles = append(profiles, *profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Sst != profiles[j].Sst {
+			return profiles[i].Sst < profiles[j].Sst
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+// retrieveProfile handles the GET /api/v1/profile/:profile_name endpoint.
+// It retrieve the slice profile.
+//
+// @Summary Retrieve slice profile
+// @Description
+// @Tags profile
+// @Produce json
+// @Param profile_name path string true "Profile name"
+// @Success 200 {object} SliceProfile
+// @Failure 404 {string} string "Profile not found"
+// @Router /api/v1/profile/{profile_name} [get]
+func retrieveProfile(c *gin.Context) {
+	profile, ok := lookupProfile(c.Param("profile_name"))
+	if !ok {
+		c.String(http.StatusNotFound, "Profile not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// addProfile handles the POST /api/v1/profile endpoint.
+// It adds a slice profile.
+//
+// @Summary Add slice profile
+// @Description
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param request body SliceProfile true "Slice profile"
+// @Param dryRun query bool false "Validate the profile without adding it"
+// @Success 201 {object} SliceProfile
+// @Success 200 {object} DryRunResponse "No changes of dry run"
+// @Failure 400 {string} string "Invalid profile"
+// @Failure 409 {string} string "Profile existed"
+// @Router /api/v1/profile [post]
+func addProfile(c *gin.Context) {
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	var profile SliceProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := profile.validate(); err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Invalid profile: %s", err.Error()))
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	if _, exist := ProfileMap[profile.Name]; exist {
+		c.String(http.StatusConflict, "Profile existed")
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+	ProfileMap[profile.Name] = &profile
+	saveState()
+
+	sysLogger.Println("Add slice profile, ", profile.Name, "SST", profile.Sst)
+	c.JSON(http.StatusCreated, profile)
+}
+
+// delProfile handles the DELETE /api/v1/profile/:profile_name endpoint.
+// It deletes the slice profile, slices created from it are kept.
+//
+// @Summary Delete slice profile
+// @Description
+// @Tags profile
+// @Param profile_name path string true "Profile name"
+// @Param dryRun query bool false "Check the profile without deleting it"
+// @Success 204 {string} string "Profile deleted"
+// @Success 200 {object} DryRunResponse "No changes of dry run"
+// @Failure 404 {string} string "Profile not found"
+// @Router /api/v1/profile/{profile_name} [delete]
+func delProfile(c *gin.Context) {
+	name := c.Param("profile_name")
+
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	if _, ok := ProfileMap[name]; !ok {
+		c.String(http.StatusNotFound, "Profile not found")
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+	delete(ProfileMap, name)
+	saveState()
+
+	sysLogger.Println("Delete slice profile, ", name)
+	c.String(http.StatusNoContent, "Profile deleted")
+}
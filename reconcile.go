@@ -0,0 +1,510 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// The reconciler compares the recorded bridges, tenants and slices with the
+// kernel, and re-applies what is missing or changed: the links of the vxlan
+// bridge, their master, address and up state, the htb tree, the classes of
+// tenants and slices with their netem qdiscs, and the filters of slices.
+// Classes are added back with their recorded class IDs, filters get new
+// handles which are recorded.
+
+// ReconcileReport reports the drifts a reconcile found and the changes made
+type ReconcileReport struct {
+	Time   time.Time `json:"Time"`
+	DryRun bool      `json:"DryRun,omitempty"`
+	// Drifts found, empty if kernel is in the recorded state
+	Actions []ReconcileAction `json:"Actions"`
+	// Planned changes of dry run
+	Ops []internal.Op `json:"Ops,omitempty"`
+}
+
+// ReconcileAction is a drift found in kernel and the change made for it
+type ReconcileAction struct {
+	// bridge, interface, tenant or slice
+	Object string `json:"Object"`
+	// <bridge>, <interface>, <bridge>/<tenant ID> or <bridge>/<S-NSSAI>
+	Name string `json:"Name"`
+	Link string `json:"Link"`
+	// What differs from the recorded state
+	Drift string `json:"Drift"`
+	// Change made, empty if the drift can not be fixed
+	Action string `json:"Action"`
+	Error  string `json:"Error,omitempty"`
+}
+
+// Report of the last reconcile which is not a dry run, guarded by sliceLock
+var reconcileReport *ReconcileReport
+
+// reconciler keeps the state of one reconcile
+type reconciler struct {
+	k      internal.Kernel
+	dryRun bool
+	report *ReconcileReport
+	// tc of the links checked, by link name
+	tcs map[string]*internal.LinkTc
+	// ifb devices checked, by vxlan interface
+	ifbs map[string]string
+	// Records are updated with new handles
+	changed bool
+}
+
+// reconcile brings the kernel back to the recorded state, or plans it if
+// plan is not nil. The caller must hold sliceLock.
+func reconcile(plan *internal.Plan) *ReconcileReport {
+	r := &reconciler{
+		k:      kernel(plan),
+		dryRun: plan != nil,
+		report: &ReconcileReport{
+			Time:    time.Now(),
+			DryRun:  plan != nil,
+			Actions: []ReconcileAction{},
+		},
+		tcs:  make(map[string]*internal.LinkTc),
+		ifbs: make(map[string]string),
+	}
+
+	// Slices on interfaces are keyed by interface in SliceMap
+	names := []string{}
+	for bridgeName := range BridgeMap {
+		names = append(names, bridgeName)
+	}
+	for name := range SliceMap {
+		if _, ok := BridgeMap[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if vxlanIf, ok := BridgeMap[name]; ok {
+			if !r.bridge(name, vxlanIf) {
+				continue
+			}
+			r.classes(name)
+			continue
+		}
+
+		if !internal.GetLinkStatus(name).Exist {
+			r.act("interface", name, name, "interface missing", "", errors.New("interfaces are not created by TN-Manager"))
+			continue
+		}
+		r.classes(name)
+	}
+
+	if plan != nil {
+		r.report.Ops = plan.Ops
+		return r.report
+	}
+
+	if r.changed {
+		saveState()
+	}
+	for _, action := range r.report.Actions {
+		if action.Error != "" {
+			sysLogger.Printf("Reconcile %s %s: %s, failed: %s\n", action.Object, action.Name, action.Drift, action.Error)
+			continue
+		}
+		sysLogger.Printf("Reconcile %s %s: %s, %s\n", action.Object, action.Name, action.Drift, action.Action)
+	}
+	reconcileReport = r.report
+	notifyDrifts(r.report)
+	return r.report
+}
+
+// act records the drift and the change made for it, it returns whether the
+// change is made
+func (r *reconciler) act(object, name, link, drift, action string, err error) bool {
+	item := ReconcileAction{
+		Object: object,
+		Name:   name,
+		Link:   link,
+		Drift:  drift,
+		Action: action,
+	}
+	if err != nil {
+		item.Error = err.Error()
+	}
+
+	r.report.Actions = append(r.report.Actions, item)
+	return err == nil
+}
+
+// bridge creates the links of vxlan bridge again if they are missing, and
+// sets their master, address and up state. It returns false if the links
+// can not be made.
+func (r *reconciler) bridge(bridgeName, vxlanIf string) bool {
+	config, known := BridgeConfigMap[bridgeName]
+
+	vxlan := internal.GetLinkStatus(vxlanIf)
+	if !vxlan.Exist {
+		if !known {
+			r.act("bridge", bridgeName, vxlanIf, "vxlan interface missing", "", errors.New("configuration of the bridge is not recorded"))
+			return false
+		}
+		// The ifb device is named after the ifindex of the vxlan interface,
+		// the one of the old interface is left behind
+		for _, ifbInterface := range recordedIfbs(bridgeName) {
+			if internal.GetLinkStatus(ifbInterface).Exist {
+				err := r.k.DelIfb(ifbInterface)
+				r.act("bridge", bridgeName, ifbInterface, "ifb device of missing vxlan interface", "ifb device deleted", err)
+			}
+		}
+		err := r.k.CreateVxlan(vxlanIf, config.VxlanId, config.BindInterface, config.RemoteIp)
+		if !r.act("bridge", bridgeName, vxlanIf, "vxlan interface missing", "vxlan interface created", err) {
+			return false
+		}
+	}
+
+	bridge := internal.GetLinkStatus(bridgeName)
+	if !bridge.Exist {
+		err := r.k.CreateBridge(bridgeName)
+		if !r.act("bridge", bridgeName, bridgeName, "bridge missing", "bridge created", err) {
+			return false
+		}
+	}
+
+	if vxlan.Master != bridgeName {
+		drift := "vxlan interface not a port of the bridge"
+		if vxlan.Master != "" {
+			drift = fmt.Sprintf("vxlan interface a port of %s", vxlan.Master)
+		}
+		err := r.k.SetMaster(vxlanIf, bridgeName)
+		r.act("bridge", bridgeName, vxlanIf, drift, "master set", err)
+	}
+
+	if known && config.LocalBridgeIp != "" && !bridge.HasAddr(config.LocalBridgeIp) {
+		err := r.k.SetBridgeIp(config.LocalBridgeIp, bridgeName)
+		r.act("bridge", bridgeName, bridgeName, fmt.Sprintf("address %s missing", config.LocalBridgeIp), "address added", err)
+	}
+
+	if !vxlan.Up {
+		err := r.k.LinkSetUp(vxlanIf)
+		r.act("bridge", bridgeName, vxlanIf, "vxlan interface down", "link set up", err)
+	}
+	if !bridge.Up {
+		err := r.k.LinkSetUp(bridgeName)
+		r.act("bridge", bridgeName, bridgeName, "bridge down", "link set up", err)
+	}
+
+	return true
+}
+
+// recordedIfbs returns the ifb devices recorded by the tenants and slices of
+// bridge
+func recordedIfbs(bridgeName string) []string {
+	names := map[string]bool{}
+	for _, tenant := range TenantMap[bridgeName] {
+		if tenant.IfbInterface != "" {
+			names[tenant.IfbInterface] = true
+		}
+	}
+	for _, slice := range SliceMap[bridgeName] {
+		if slice.IfbInterface != "" {
+			names[slice.IfbInterface] = true
+		}
+	}
+
+	ifbs := []string{}
+	for name := range names {
+		ifbs = append(ifbs, name)
+	}
+	sort.Strings(ifbs)
+	return ifbs
+}
+
+// classes re-applies the classes of the tenants and slices keyed by name in
+// TenantMap and SliceMap
+func (r *reconciler) classes(name string) {
+	tenants := []*Tenant{}
+	for _, tenant := range TenantMap[name] {
+		tenants = append(tenants, tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].Id < tenants[j].Id
+	})
+
+	slices := []*Slice{}
+	for _, slice := range SliceMap[name] {
+		slices = append(slices, slice)
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		return slices[i].Snssai().less(slices[j].Snssai())
+	})
+
+	// Tenant classes first, the slices of tenants are under them
+	for _, tenant := range tenants {
+		r.tenant(tenant)
+	}
+	for _, slice := range slices {
+		r.slice(slice)
+	}
+}
+
+func (r *reconciler) tenant(tenant *Tenant) {
+	name := tenant.Bridge + "/" + tenant.Id
+	if tc := r.linkTc(tenant.Bridge, tenant.VxlanInterface); tc != nil {
+		r.class("tenant", name, tc, tenant.ClassId, tenant.downlinkSpec())
+	}
+
+	if tenant.UplinkClassId == 0 {
+		return
+	}
+
+	ifbInterface := r.ifb(tenant.Bridge, tenant.VxlanInterface)
+	if ifbInterface == "" {
+		return
+	}
+	if !r.dryRun && tenant.IfbInterface != ifbInterface {
+		tenant.IfbInterface = ifbInterface
+		r.changed = true
+	}
+
+	if tc := r.linkTc(tenant.Bridge, ifbInterface); tc != nil {
+		r.class("tenant", name, tc, tenant.UplinkClassId, tenant.uplinkSpec())
+	}
+}
+
+func (r *reconciler) slice(slice *Slice) {
+	name := slice.Bridge + "/" + slice.Snssai().String()
+	if tc := r.linkTc(slice.Bridge, slice.VxlanInterface); tc != nil {
+		class := r.class("slice", name, tc, slice.ClassId, slice.downlinkSpec())
+		r.netem(name, slice.VxlanInterface, class, slice.ClassId, slice.Netem, slice.DownlinkCeil)
+
+		handles, prio, ok := r.filters(name, tc, slice.ClassId, slice.FilterPrio, slice.FilterHandles, slice.Matches, slice.Remark)
+		if ok && !r.dryRun {
+			slice.FilterHandles = handles
+			slice.FilterPrio = prio
+			r.changed = true
+		}
+	}
+
+	if slice.UplinkClassId == 0 {
+		return
+	}
+
+	ifbInterface := r.ifb(slice.Bridge, slice.VxlanInterface)
+	if ifbInterface == "" {
+		return
+	}
+	if !r.dryRun && slice.IfbInterface != ifbInterface {
+		slice.IfbInterface = ifbInterface
+		r.changed = true
+	}
+
+	if tc := r.linkTc(slice.Bridge, ifbInterface); tc != nil {
+		class := r.class("slice", name, tc, slice.UplinkClassId, slice.uplinkSpec())
+		r.netem(name, ifbInterface, class, slice.UplinkClassId, slice.Netem, slice.UplinkCeil)
+
+		handles, prio, ok := r.filters(name, tc, slice.UplinkClassId, slice.UplinkFilterPrio, slice.UplinkFilterHandles, internal.ReverseMatches(slice.Matches), nil)
+		if ok && !r.dryRun {
+			slice.UplinkFilterHandles = handles
+			slice.UplinkFilterPrio = prio
+			r.changed = true
+		}
+	}
+}
+
+// linkTc reads the tc of link once per reconcile, and sets up the htb tree
+// if it is missing. It returns nil if the tree can not be set up.
+func (r *reconciler) linkTc(name, link string) *internal.LinkTc {
+	if tc, ok := r.tcs[link]; ok {
+		return tc
+	}
+
+	// Link created by a dry run is not in kernel
+	tc, _ := internal.ListTc(link)
+	if tc == nil || tc.Capacity == 0 || !tc.DefaultClass {
+		err := r.k.SetupLinkTree(link)
+		if !r.act("bridge", name, link, "htb tree missing", "htb tree set up", err) {
+			r.tcs[link] = nil
+			return nil
+		}
+		if tc == nil {
+			tc = &internal.LinkTc{Link: link}
+		}
+	}
+
+	r.tcs[link] = tc
+	return tc
+}
+
+// ifb sets up the ifb device of vxlan interface if it is missing or ingress
+// traffic is not redirected to it. It returns the ifb device name, or empty
+// if it can not be set up.
+func (r *reconciler) ifb(name, vxlanIf string) string {
+	if ifbInterface, ok := r.ifbs[vxlanIf]; ok {
+		return ifbInterface
+	}
+
+	ifbInterface := internal.IfbOf(vxlanIf)
+	if !internal.IfbReady(vxlanIf) {
+		var err error
+		ifbInterface, err = r.k.SetupIfb(vxlanIf)
+		r.act("bridge", name, vxlanIf, "ifb device missing or ingress not redirected", "ifb set up", err)
+	}
+
+	r.ifbs[vxlanIf] = ifbInterface
+	return ifbInterface
+}
+
+// class adds class 1:classId of spec back if it is missing on tc.Link, or
+// changes it if its rates differ. It returns the class found, or nil if the
+// class is added.
+func (r *reconciler) class(object, name string, tc *internal.LinkTc, classId uint16, spec internal.ClassSpec) *internal.LinkClass {
+	var class *internal.LinkClass
+	for i := range tc.Classes {
+		if tc.Classes[i].ClassId == classId {
+			class = &tc.Classes[i]
+		}
+	}
+
+	if class == nil {
+		err := r.k.RestoreQdisc(tc.Link, classId, spec)
+		r.act(object, name, tc.Link, fmt.Sprintf("class %s missing", classHandle(classId)), "class added", err)
+		return nil
+	}
+
+	parent := spec.Parent
+	if parent == 0 {
+		parent = internal.ParentClassId
+	}
+	if class.Parent != parent {
+		r.act(object, name, tc.Link, fmt.Sprintf("class %s under %s instead of %s", classHandle(classId), classHandle(class.Parent), classHandle(parent)),
+			"", errors.New("parent of a class can not be changed"))
+		return class
+	}
+
+	ceil := spec.Ceil
+	if ceil == 0 {
+		ceil = spec.Rate
+	}
+	if class.Rate != spec.Rate || class.Ceil != ceil || class.Prio != spec.Prio {
+		err := r.k.ChangeQdisc(tc.Link, classId, spec)
+		r.act(object, name, tc.Link, fmt.Sprintf("class %s rate %d ceil %d prio %d, recorded rate %d ceil %d prio %d",
+			classHandle(classId), class.Rate, class.Ceil, class.Prio, spec.Rate, ceil, spec.Prio), "class changed", err)
+	}
+
+	return class
+}
+
+// netem sets the netem qdisc of class 1:classId on link if it is missing, or
+// deletes it if the slice has none. class is nil if it is added back.
+func (r *reconciler) netem(name, link string, class *internal.LinkClass, classId uint16, spec *internal.NetemSpec, ceilRate int) {
+	exist := class != nil && class.Netem != nil
+	switch {
+	case spec != nil && !exist:
+		err := r.k.SetNetem(link, classId, spec, ceilRate)
+		r.act("slice", name, link, fmt.Sprintf("netem of class %s missing", classHandle(classId)), "netem set", err)
+	case spec == nil && exist:
+		err := r.k.SetNetem(link, classId, nil, ceilRate)
+		r.act("slice", name, link, fmt.Sprintf("netem of class %s not recorded", classHandle(classId)), "netem deleted", err)
+	}
+}
+
+// filters adds the filters of class 1:classId back if any recorded handle is
+// missing on tc.Link, the ones left are deleted first. It returns the new
+// handles and priority, ok is false if the filters are not added.
+func (r *reconciler) filters(name string, tc *internal.LinkTc, classId, prio uint16, handles []uint32, matches []internal.Match, remark *internal.Remark) ([]uint32, uint16, bool) {
+	left := 0
+	found := make(map[uint32]bool)
+	for _, filter := range tc.Filters {
+		if filter.Prio != prio {
+			continue
+		}
+		left++
+		if filter.ClassId == classId {
+			found[filter.Handle] = true
+		}
+	}
+
+	missing := 0
+	for _, handle := range handles {
+		if !found[handle] {
+			missing++
+		}
+	}
+	if missing == 0 && len(handles) > 0 {
+		return nil, 0, false
+	}
+
+	drift := fmt.Sprintf("%d of %d filters of class %s missing", missing, len(handles), classHandle(classId))
+	if left > 0 {
+		if err := r.k.DelFilter(tc.Link, prio); err != nil {
+			r.act("slice", name, tc.Link, drift, "", err)
+			return nil, 0, false
+		}
+	}
+
+	handles, prio, err := r.k.AddFilter(tc.Link, matches, classId, remark)
+	if !r.act("slice", name, tc.Link, drift, "filters added", err) {
+		return nil, 0, false
+	}
+	return handles, prio, true
+}
+
+// runReconciler reconciles every interval, until the process exits
+func runReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sliceLock.Lock()
+		reconcile(nil)
+		sliceLock.Unlock()
+	}
+}
+
+// reconcileNow handles the POST /api/v1/reconcile endpoint.
+// It reconciles the kernel with the recorded state.
+//
+// @Summary Reconcile
+// @Description Compare the recorded bridges, tenants and slices with the kernel and re-apply the links, addresses, classes, qdiscs and filters which are missing or changed
+// @Tags reconcile
+// @Produce json
+// @Param dryRun query bool false "Return the drifts and planned changes without making them"
+// @Success 200 {object} ReconcileReport
+// @Failure 400 {string} string "Invalid dryRun"
+// @Router /api/v1/reconcile [post]
+func reconcileNow(c *gin.Context) {
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	c.JSON(http.StatusOK, reconcile(plan))
+}
+
+// getReconcile handles the GET /api/v1/reconcile endpoint.
+// It reports the last reconcile.
+//
+// @Summary Report last reconcile
+// @Description Report the drifts found and the changes made by the last reconcile, periodic or on demand
+// @Tags reconcile
+// @Produce json
+// @Success 200 {object} ReconcileReport
+// @Failure 404 {string} string "Not reconciled yet"
+// @Router /api/v1/reconcile [get]
+func getReconcile(c *gin.Context) {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	if reconcileReport == nil {
+		c.String(http.StatusNotFound, "Not reconciled yet")
+		return
+	}
+	c.JSON(http.StatusOK, reconcileReport)
+}
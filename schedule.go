@@ -0,0 +1,713 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// ScheduleAction is what a schedule does to its slice at the start time. If
+// the schedule has an end time, the action is undone then.
+type ScheduleAction string
+
+const (
+	// Install the slice, removed at the end
+	ScheduleActivate ScheduleAction = "activate"
+	// Change the slice, changed back at the end
+	ScheduleUpdate ScheduleAction = "update"
+	// Remove the slice, installed again at the end
+	ScheduleDeactivate ScheduleAction = "deactivate"
+)
+
+// Phases of a schedule
+const (
+	phaseStart = "start"
+	phaseEnd   = "end"
+)
+
+// Results of a schedule execution
+const (
+	resultApplied = "applied"
+	resultFailed  = "failed"
+	// Not applied because TN-Manager was not running through the whole window
+	resultMissed = "missed"
+	// Not applied because the start failed
+	resultSkipped = "skipped"
+)
+
+// ScheduleRequest represents the request body for the addSchedule endpoint.
+type ScheduleRequest struct {
+	Action ScheduleAction `json:"Action"`
+	// Time to apply the action (RFC 3339)
+	At time.Time `json:"At"`
+	// Time to undo the action (RFC 3339), kept if not set
+	Until *time.Time `json:"Until,omitempty"`
+	// Slice to install, for activate. SST and SliceSD are taken from path
+	Slice *SliceRequest `json:"Slice,omitempty"`
+	// Changes of the slice, for update
+	Update *SliceUpdateRequest `json:"Update,omitempty"`
+}
+
+// Schedule applies an action to a slice at given times
+type Schedule struct {
+	Id     string              `json:"Id"`
+	Bridge string              `json:"Bridge"`
+	Snssai string              `json:"SNSSAI"`
+	Action ScheduleAction      `json:"Action"`
+	At     time.Time           `json:"At"`
+	Until  *time.Time          `json:"Until,omitempty"`
+	Slice  *SliceRequest       `json:"Slice,omitempty"`
+	Update *SliceUpdateRequest `json:"Update,omitempty"`
+
+	// Undo of update and deactivate, captured from the slice at the start
+	Revert  *SliceUpdateRequest `json:"Revert,omitempty"`
+	Restore *SliceRequest       `json:"Restore,omitempty"`
+	// Tenant the deactivated slice is restored under
+	RestoreTenant string `json:"RestoreTenant,omitempty"`
+
+	Executions []ScheduleExecution `json:"Executions"`
+}
+
+// ScheduleExecution is a planned or past run of a schedule phase
+type ScheduleExecution struct {
+	ScheduleId string         `json:"ScheduleId"`
+	Bridge     string         `json:"Bridge"`
+	Snssai     string         `json:"SNSSAI"`
+	Action     ScheduleAction `json:"Action"`
+	Phase      string         `json:"Phase"`
+	Planned    time.Time      `json:"Planned"`
+	Executed   *time.Time     `json:"Executed,omitempty"`
+	Result     string         `json:"Result,omitempty"`
+	Message    string         `json:"Message,omitempty"`
+}
+
+// ScheduleOverview lists the executions of all schedules
+type ScheduleOverview struct {
+	// Sorted by planned time
+	Upcoming []ScheduleExecution `json:"Upcoming"`
+	// Sorted by executed time, latest first
+	Past []ScheduleExecution `json:"Past"`
+}
+
+// Map schedule ID to schedule
+var ScheduleMap map[string]*Schedule = make(map[string]*Schedule)
+
+// Guard ScheduleMap, and the schedule file
+var scheduleLock sync.Mutex
+
+// File to keep schedules across restarts, not kept if empty
+var scheduleFile string
+
+// Wake up the scheduler when schedules change
+var scheduleWake = make(chan struct{}, 1)
+
+// validate checks request for the slice of S-NSSAI snssai
+func (r *ScheduleRequest) validate(snssai Snssai) error {
+	if r.At.IsZero() {
+		return errors.New("At is required")
+	}
+	if r.Until != nil && !r.Until.After(r.At) {
+		return errors.New("Until should be after At")
+	}
+
+	switch r.Action {
+	case ScheduleActivate:
+		if r.Slice == nil {
+			return errors.New("Slice is required to activate")
+		}
+		sst := snssai.Sst
+		r.Slice.Sst, r.Slice.SliceSd = &sst, snssai.Sd
+	case ScheduleUpdate:
+		if r.Update == nil {
+			return errors.New("Update is required to update")
+		}
+	case ScheduleDeactivate:
+	default:
+		return fmt.Errorf("Action should be %s, %s or %s", ScheduleActivate, ScheduleUpdate, ScheduleDeactivate)
+	}
+
+	return nil
+}
+
+// execution returns the execution of phase, nil if it has not run
+func (schedule *Schedule) execution(phase string) *ScheduleExecution {
+	for i := range schedule.Executions {
+		if schedule.Executions[i].Phase == phase {
+			return &schedule.Executions[i]
+		}
+	}
+	return nil
+}
+
+// newExecution returns the planned execution of phase
+func (schedule *Schedule) newExecution(phase string) ScheduleExecution {
+	planned := schedule.At
+	if phase == phaseEnd {
+		planned = *schedule.Until
+	}
+
+	return ScheduleExecution{
+		ScheduleId: schedule.Id,
+		Bridge:     schedule.Bridge,
+		Snssai:     schedule.Snssai,
+		Action:     schedule.Action,
+		Phase:      phase,
+		Planned:    planned,
+	}
+}
+
+// upcoming returns the phases of schedule which have not run
+func (schedule *Schedule) upcoming() []ScheduleExecution {
+	upcoming := []ScheduleExecution{}
+	if schedule.execution(phaseStart) == nil {
+		upcoming = append(upcoming, schedule.newExecution(phaseStart))
+	}
+	if schedule.Until != nil && schedule.execution(phaseEnd) == nil {
+		upcoming = append(upcoming, schedule.newExecution(phaseEnd))
+	}
+	return upcoming
+}
+
+// record appends the execution of phase with result
+func (schedule *Schedule) record(phase, result, message string, executed time.Time) {
+	execution := schedule.newExecution(phase)
+	execution.Executed = &executed
+	execution.Result = result
+	execution.Message = message
+	schedule.Executions = append(schedule.Executions, execution)
+}
+
+// scheduleUndo is the undo of update and deactivate, captured from the slice
+// when the start phase runs
+type scheduleUndo struct {
+	revert        *SliceUpdateRequest
+	restore       *SliceRequest
+	restoreTenant string
+}
+
+// apply runs phase of schedule through the same path as the slice API. It
+// runs without scheduleLock, so the undo is returned instead of set on
+// schedule. The fields a phase takes may be missing in a schedule file edited
+// by hand or kept by an older version, the phase fails then.
+func (schedule *Schedule) apply(phase string) (undo scheduleUndo, err error) {
+	if missing := schedule.missing(phase); missing != "" {
+		return undo, fmt.Errorf("%s of the schedule is missing", missing)
+	}
+
+	switch {
+	case schedule.Action == ScheduleActivate && phase == phaseStart:
+		_, err = createSlice(schedule.Bridge, "", *schedule.Slice, nil)
+	case schedule.Action == ScheduleActivate && phase == phaseEnd:
+		err = deleteSlice(schedule.Bridge, schedule.Snssai, nil)
+
+	case schedule.Action == ScheduleUpdate && phase == phaseStart:
+		sliceLock.Lock()
+		slice, lookupErr := lookupSlice(schedule.Bridge, schedule.Snssai)
+		if lookupErr == nil {
+			undo.revert = schedule.Update.revert(slice)
+		}
+		sliceLock.Unlock()
+		if lookupErr != nil {
+			return undo, lookupErr
+		}
+
+		_, err = modifySlice(schedule.Bridge, schedule.Snssai, *schedule.Update, nil)
+	case schedule.Action == ScheduleUpdate && phase == phaseEnd:
+		_, err = modifySlice(schedule.Bridge, schedule.Snssai, *schedule.Revert, nil)
+
+	case schedule.Action == ScheduleDeactivate && phase == phaseStart:
+		sliceLock.Lock()
+		slice, lookupErr := lookupSlice(schedule.Bridge, schedule.Snssai)
+		if lookupErr == nil {
+			restore := slice.request()
+			undo.restore = &restore
+			undo.restoreTenant = slice.Tenant
+		}
+		sliceLock.Unlock()
+		if lookupErr != nil {
+			return undo, lookupErr
+		}
+
+		err = deleteSlice(schedule.Bridge, schedule.Snssai, nil)
+	case schedule.Action == ScheduleDeactivate && phase == phaseEnd:
+		_, err = createSlice(schedule.Bridge, schedule.RestoreTenant, *schedule.Restore, nil)
+
+	default:
+		err = fmt.Errorf("unknown action %s", schedule.Action)
+	}
+
+	return undo, err
+}
+
+// missing returns the field phase of schedule takes which is not set, or
+// empty if none is missing
+func (schedule *Schedule) missing(phase string) string {
+	switch {
+	case schedule.Action == ScheduleActivate && phase == phaseStart && schedule.Slice == nil:
+		return "Slice"
+	case schedule.Action == ScheduleUpdate && phase == phaseStart && schedule.Update == nil:
+		return "Update"
+	case schedule.Action == ScheduleUpdate && phase == phaseEnd && schedule.Revert == nil:
+		return "Revert"
+	case schedule.Action == ScheduleDeactivate && phase == phaseEnd && schedule.Restore == nil:
+		return "Restore"
+	}
+	return ""
+}
+
+// revert returns the update which changes the fields r sets back to the
+// values of slice. Ceils are reverted with their rate, a recorded ceil is
+// never 0.
+func (r SliceUpdateRequest) revert(slice *Slice) *SliceUpdateRequest {
+	revert := &SliceUpdateRequest{}
+
+	if r.DownlinkRate != 0 || r.DownlinkCeil != 0 {
+		revert.DownlinkRate, revert.DownlinkCeil = slice.DownlinkRate, slice.DownlinkCeil
+	}
+	if r.UplinkRate != 0 || r.UplinkCeil != 0 || r.RemoveUplink {
+		if slice.UplinkRate == 0 {
+			// uplink was not shaped
+			revert.RemoveUplink = true
+		} else {
+			revert.UplinkRate, revert.UplinkCeil = slice.UplinkRate, slice.UplinkCeil
+		}
+	}
+	if r.Burst != 0 {
+		revert.Burst = slice.Burst
+	}
+	if r.Cburst != 0 {
+		revert.Cburst = slice.Cburst
+	}
+	if r.Prio != nil {
+		prio := slice.Prio
+		revert.Prio = &prio
+	}
+	if r.Netem != nil {
+		// Netem of zero values removes the emulation
+		revert.Netem = &internal.NetemSpec{}
+		if slice.Netem != nil {
+			netem := *slice.Netem
+			revert.Netem = &netem
+		}
+	}
+	if r.Remark != nil {
+		// Remark without Dscp and Pcp removes the rewrite
+		revert.Remark = &internal.Remark{}
+		if slice.Remark != nil {
+			remark := *slice.Remark
+			revert.Remark = &remark
+		}
+	}
+	if r.DstIp != "" || r.SrcIp != "" || len(r.Matches) > 0 {
+		request := slice.request()
+		revert.DstIp, revert.SrcIp, revert.Matches = request.DstIp, request.SrcIp, request.Matches
+	}
+
+	return revert
+}
+
+// runDueSchedules runs the phases due at now, and returns the time the next
+// phase is due, zero if none
+func runDueSchedules(now time.Time) time.Time {
+	scheduleLock.Lock()
+	type due struct {
+		schedule *Schedule
+		phase    string
+	}
+	dues := []due{}
+	for _, schedule := range ScheduleMap {
+		for _, execution := range schedule.upcoming() {
+			if !execution.Planned.After(now) {
+				dues = append(dues, due{schedule, execution.Phase})
+			}
+		}
+	}
+	scheduleLock.Unlock()
+
+	sort.Slice(dues, func(i, j int) bool {
+		return dues[i].schedule.newExecution(dues[i].phase).Planned.Before(dues[j].schedule.newExecution(dues[j].phase).Planned)
+	})
+
+	for _, due := range dues {
+		runSchedulePhase(due.schedule, due.phase, now)
+	}
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	if len(dues) > 0 {
+		saveSchedules()
+	}
+
+	var next time.Time
+	for _, schedule := range ScheduleMap {
+		for _, execution := range schedule.upcoming() {
+			if next.IsZero() || execution.Planned.Before(next) {
+				next = execution.Planned
+			}
+		}
+	}
+	return next
+}
+
+// runSchedulePhase runs phase of schedule which is due at now
+func runSchedulePhase(schedule *Schedule, phase string, now time.Time) {
+	scheduleLock.Lock()
+	if _, ok := ScheduleMap[schedule.Id]; !ok {
+		// cancelled
+		scheduleLock.Unlock()
+		return
+	}
+
+	if phase == phaseStart && schedule.Until != nil && !schedule.Until.After(now) {
+		// The whole window passed while TN-Manager was not running
+		schedule.record(phaseStart, resultMissed, "", now)
+		schedule.record(phaseEnd, resultMissed, "", now)
+		scheduleLock.Unlock()
+		return
+	}
+	if phase == phaseEnd {
+		if start := schedule.execution(phaseStart); start == nil || start.Result != resultApplied {
+			schedule.record(phaseEnd, resultSkipped, "", now)
+			scheduleLock.Unlock()
+			return
+		}
+	}
+	scheduleLock.Unlock()
+
+	sysLogger.Println("Run schedule ", schedule.Id, "Action", schedule.Action, "Phase", phase, "S-NSSAI", schedule.Snssai, "Bridge", schedule.Bridge)
+	undo, err := schedule.apply(phase)
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	if undo.revert != nil {
+		schedule.Revert = undo.revert
+	}
+	if undo.restore != nil {
+		schedule.Restore = undo.restore
+		schedule.RestoreTenant = undo.restoreTenant
+	}
+
+	if err != nil {
+		sysLogger.Println("Failed to run schedule: ", err)
+		schedule.record(phase, resultFailed, err.Error(), time.Now())
+		return
+	}
+	schedule.record(phase, resultApplied, "", time.Now())
+}
+
+// runScheduler runs schedules when they are due, until the process exits
+func runScheduler() {
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+		case <-scheduleWake:
+		}
+
+		next := runDueSchedules(time.Now())
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// wakeScheduler makes the scheduler check schedules again
+func wakeScheduler() {
+	select {
+	case scheduleWake <- struct{}{}:
+	default:
+	}
+}
+
+// loadSchedules reads the schedules kept in path. Phases due while
+// TN-Manager was not running are run once the scheduler starts.
+func loadSchedules(path string) error {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	scheduleFile = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	schedules := []*Schedule{}
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		ScheduleMap[schedule.Id] = schedule
+	}
+	sysLogger.Println("Load schedules, ", len(schedules), "from", path)
+	return nil
+}
+
+// saveSchedules writes the schedules to the schedule file, replacing it at
+// once. The caller must hold scheduleLock.
+func saveSchedules() {
+	if scheduleFile == "" {
+		return
+	}
+
+	schedules := []*Schedule{}
+	for _, schedule := range ScheduleMap {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].At.Before(schedules[j].At)
+	})
+
+	data, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		sysLogger.Println("Failed to encode schedules: ", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(scheduleFile), 0755); err != nil {
+		sysLogger.Println("Failed to save schedules: ", err)
+		return
+	}
+
+	tmpFile := scheduleFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		sysLogger.Println("Failed to save schedules: ", err)
+		return
+	}
+	if err := os.Rename(tmpFile, scheduleFile); err != nil {
+		sysLogger.Println("Failed to save schedules: ", err)
+	}
+}
+
+// addSchedule handles the POST /api/v1/slice/:bridge_name/:snssai/schedule endpoint.
+// It schedules an action on the slice.
+//
+// @Summary Schedule slice action
+// @Description Activate, update or deactivate the slice at At, and undo it at Until if set
+// @Tags schedule
+// @Accept json
+// @Produce json
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Param request body ScheduleRequest true "Schedule request"
+// @Param dryRun query bool false "Validate the request without adding the schedule"
+// @Success 201 {object} Schedule
+// @Success 200 {object} DryRunResponse "No changes of dry run"
+// @Failure 400 {string} string "Invalid request body"
+// @Router /api/v1/slice/{bridge_name}/{snssai}/schedule [post]
+func addSchedule(c *gin.Context) {
+	bridgeName := c.Param("bridge_name")
+
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	snssai, ok, err := parseSnssai(c.Param("snssai"))
+	if err != nil || !ok {
+		c.String(http.StatusBadRequest, "Invalid S-NSSAI: <SST>-<SD> or <SST> is required")
+		return
+	}
+
+	var request ScheduleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := request.validate(snssai); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sliceLock.Lock()
+	ok = hasSlices(bridgeName)
+	sliceLock.Unlock()
+	if !ok {
+		c.String(http.StatusNotFound, "Vxlan bridge not existed")
+		return
+	}
+	if plan != nil {
+		// A schedule changes the kernel when it runs, nothing now
+		respondPlan(c, plan)
+		return
+	}
+
+	schedule := &Schedule{
+		Id:         generateRandomString(8),
+		Bridge:     bridgeName,
+		Snssai:     snssai.String(),
+		Action:     request.Action,
+		At:         request.At,
+		Until:      request.Until,
+		Slice:      request.Slice,
+		Update:     request.Update,
+		Executions: []ScheduleExecution{},
+	}
+
+	scheduleLock.Lock()
+	ScheduleMap[schedule.Id] = schedule
+	saveSchedules()
+	scheduleLock.Unlock()
+
+	sysLogger.Println("Add schedule ", schedule.Id, "Action", schedule.Action, "S-NSSAI", schedule.Snssai, "At", schedule.At)
+	wakeScheduler()
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// listSliceSchedule handles the GET /api/v1/slice/:bridge_name/:snssai/schedule endpoint.
+// It lists the schedules of the slice.
+//
+// @Summary List slice schedules
+// @Description
+// @Tags schedule
+// @Produce json
+// @Param bridge_name path string true "Bridge name, or interface of end-to-end slice hops"
+// @Param snssai path string true "S-NSSAI, <SST>-<SD> or <SST>"
+// @Success 200 {array} Schedule
+// @Failure 400 {string} string "Invalid S-NSSAI"
+// @Router /api/v1/slice/{bridge_name}/{snssai}/schedule [get]
+func listSliceSchedule(c *gin.Context) {
+	bridgeName := c.Param("bridge_name")
+
+	snssai, ok, err := parseSnssai(c.Param("snssai"))
+	if err != nil || !ok {
+		c.String(http.StatusBadRequest, "Invalid S-NSSAI: <SST>-<SD> or <SST> is required")
+		return
+	}
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedules := []Schedule{}
+	for _, schedule := range ScheduleMap {
+		if schedule.Bridge == bridgeName && schedule.Snssai == snssai.String() {
+			schedules = append(schedules, *schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].At.Before(schedules[j].At)
+	})
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+// listSchedule handles the GET /api/v1/schedule endpoint.
+// It lists upcoming and past executions of all schedules.
+//
+// @Summary List schedule executions
+// @Description
+// @Tags schedule
+// @Produce json
+// @Success 200 {object} ScheduleOverview
+// @Router /api/v1/schedule [get]
+func listSchedule(c *gin.Context) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	overview := ScheduleOverview{
+		Upcoming: []ScheduleExecution{},
+		Past:     []ScheduleExecution{},
+	}
+	for _, schedule := range ScheduleMap {
+		overview.Upcoming = append(overview.Upcoming, schedule.upcoming()...)
+		overview.Past = append(overview.Past, schedule.Executions...)
+	}
+	sort.Slice(overview.Upcoming, func(i, j int) bool {
+		return overview.Upcoming[i].Planned.Before(overview.Upcoming[j].Planned)
+	})
+	sort.Slice(overview.Past, func(i, j int) bool {
+		return overview.Past[i].Executed.After(*overview.Past[j].Executed)
+	})
+
+	c.JSON(http.StatusOK, overview)
+}
+
+// retrieveSchedule handles the GET /api/v1/schedule/:schedule_id endpoint.
+// It retrieve the schedule.
+//
+// @Summary Retrieve schedule
+// @Description
+// @Tags schedule
+// @Produce json
+// @Param schedule_id path string true "Schedule ID"
+// @Success 200 {object} Schedule
+// @Failure 404 {string} string "Schedule not found"
+// @Router /api/v1/schedule/{schedule_id} [get]
+func retrieveSchedule(c *gin.Context) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, ok := ScheduleMap[c.Param("schedule_id")]
+	if !ok {
+		c.String(http.StatusNotFound, "Schedule not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// delSchedule handles the DELETE /api/v1/schedule/:schedule_id endpoint.
+// It cancels the phases of the schedule which have not run, applied phases
+// are not undone.
+//
+// @Summary Delete schedule
+// @Description
+// @Tags schedule
+// @Param schedule_id path string true "Schedule ID"
+// @Param dryRun query bool false "Check the schedule without deleting it"
+// @Success 204 {string} string "Schedule deleted"
+// @Success 200 {object} DryRunResponse "No changes of dry run"
+// @Failure 404 {string} string "Schedule not found"
+// @Router /api/v1/schedule/{schedule_id} [delete]
+func delSchedule(c *gin.Context) {
+	scheduleId := c.Param("schedule_id")
+
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	if _, ok := ScheduleMap[scheduleId]; !ok {
+		c.String(http.StatusNotFound, "Schedule not found")
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+	delete(ScheduleMap, scheduleId)
+	saveSchedules()
+
+	sysLogger.Println("Delete schedule ", scheduleId)
+	c.String(http.StatusNoContent, "Schedule deleted")
+}
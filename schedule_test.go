@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+func testSlice(t *testing.T, uplinkRate int) *Slice {
+	slice := &Slice{
+		Bridge:       "br0",
+		Sst:          1,
+		SliceSd:      "010203",
+		DstIp:        "10.0.0.1",
+		Matches:      []internal.Match{{DstIp: "10.0.0.1"}},
+		DownlinkRate: 1000,
+		FlowRate:     1000,
+		UplinkRate:   uplinkRate,
+		Prio:         3,
+	}
+	if err := slice.validateRate(); err != nil {
+		t.Fatalf("validateRate failed: %v", err)
+	}
+	return slice
+}
+
+func TestRevert(t *testing.T) {
+	prio, oldPrio := uint32(0), uint32(3)
+	dscp := uint8(46)
+	tests := []struct {
+		name   string
+		uplink int
+		update SliceUpdateRequest
+		want   SliceUpdateRequest
+	}{
+		{
+			name:   "downlink rate",
+			update: SliceUpdateRequest{DownlinkRate: 5000},
+			want:   SliceUpdateRequest{DownlinkRate: 1000, DownlinkCeil: 1000},
+		},
+		{
+			name:   "downlink ceil",
+			update: SliceUpdateRequest{DownlinkCeil: 5000},
+			want:   SliceUpdateRequest{DownlinkRate: 1000, DownlinkCeil: 1000},
+		},
+		{
+			name:   "uplink rate",
+			uplink: 500,
+			update: SliceUpdateRequest{UplinkRate: 800, UplinkCeil: 900},
+			want:   SliceUpdateRequest{UplinkRate: 500, UplinkCeil: 500},
+		},
+		{
+			name:   "uplink added",
+			update: SliceUpdateRequest{UplinkRate: 800},
+			want:   SliceUpdateRequest{RemoveUplink: true},
+		},
+		{
+			name:   "uplink removed",
+			uplink: 500,
+			update: SliceUpdateRequest{RemoveUplink: true},
+			want:   SliceUpdateRequest{UplinkRate: 500, UplinkCeil: 500},
+		},
+		{
+			name:   "prio",
+			update: SliceUpdateRequest{Prio: &prio},
+			want:   SliceUpdateRequest{Prio: &oldPrio},
+		},
+		{
+			name:   "netem added",
+			update: SliceUpdateRequest{Netem: &internal.NetemSpec{Delay: 20}},
+			want:   SliceUpdateRequest{Netem: &internal.NetemSpec{}},
+		},
+		{
+			name:   "remark added",
+			update: SliceUpdateRequest{Remark: &internal.Remark{Dscp: &dscp}},
+			want:   SliceUpdateRequest{Remark: &internal.Remark{}},
+		},
+		{
+			name:   "matches",
+			update: SliceUpdateRequest{DstIp: "10.0.0.2"},
+			want:   SliceUpdateRequest{DstIp: "10.0.0.1", Matches: []internal.Match{}},
+		},
+	}
+
+	for _, test := range tests {
+		slice := testSlice(t, test.uplink)
+		revert := test.update.revert(slice)
+		if !reflect.DeepEqual(*revert, test.want) {
+			t.Errorf("%s: revert = %+v, want %+v", test.name, *revert, test.want)
+		}
+	}
+}
+
+// Applying an update and then its revert gives back the slice
+func TestRevertRoundTrip(t *testing.T) {
+	prio := uint32(0)
+	dscp, pcp := uint8(46), uint8(5)
+	tests := []struct {
+		name   string
+		uplink int
+		remark *internal.Remark
+		update SliceUpdateRequest
+	}{
+		{name: "rates", uplink: 500, update: SliceUpdateRequest{DownlinkRate: 5000, DownlinkCeil: 8000, UplinkRate: 600}},
+		{name: "ceil only", update: SliceUpdateRequest{DownlinkCeil: 8000}},
+		{name: "uplink added", update: SliceUpdateRequest{UplinkRate: 800, UplinkCeil: 900}},
+		{name: "uplink removed", uplink: 500, update: SliceUpdateRequest{RemoveUplink: true}},
+		{name: "prio and netem", update: SliceUpdateRequest{Prio: &prio, Netem: &internal.NetemSpec{Delay: 20, Loss: 1}}},
+		{name: "matches", update: SliceUpdateRequest{SrcIp: "10.1.0.0/16", Matches: []internal.Match{{DstIp: "10.0.0.9"}}}},
+		{name: "remark added", update: SliceUpdateRequest{Remark: &internal.Remark{Dscp: &dscp, Pcp: &pcp}}},
+		{name: "remark changed", remark: &internal.Remark{Pcp: &pcp}, update: SliceUpdateRequest{Remark: &internal.Remark{Dscp: &dscp}}},
+		{name: "remark removed", remark: &internal.Remark{Dscp: &dscp}, update: SliceUpdateRequest{Remark: &internal.Remark{}}},
+	}
+
+	for _, test := range tests {
+		slice := testSlice(t, test.uplink)
+		slice.Remark = test.remark
+		revert := test.update.revert(slice)
+
+		updated, err := test.update.apply(slice)
+		if err != nil {
+			t.Errorf("%s: apply failed: %v", test.name, err)
+			continue
+		}
+		reverted, err := revert.apply(updated)
+		if err != nil {
+			t.Errorf("%s: apply of revert failed: %v", test.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(reverted, slice) {
+			t.Errorf("%s: reverted to %+v, want %+v", test.name, *reverted, *slice)
+		}
+	}
+}
+
+// A schedule file edited by hand or kept by an older version may miss the
+// fields a phase takes, the phase fails instead of panicking
+func TestRunSchedulePhaseMissing(t *testing.T) {
+	if sysLogger == nil {
+		sysLogger = log.New(io.Discard, "", 0)
+	}
+
+	tests := []struct {
+		action ScheduleAction
+		phase  string
+		start  bool
+	}{
+		{action: ScheduleActivate, phase: phaseStart},
+		{action: ScheduleUpdate, phase: phaseStart},
+		{action: ScheduleUpdate, phase: phaseEnd, start: true},
+		{action: ScheduleDeactivate, phase: phaseEnd, start: true},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		until := now.Add(time.Hour)
+		schedule := &Schedule{Id: "test", Bridge: "br0", Snssai: "1-010203", Action: test.action, At: now, Until: &until}
+		if test.start {
+			schedule.record(phaseStart, resultApplied, "", now)
+		}
+
+		scheduleLock.Lock()
+		ScheduleMap[schedule.Id] = schedule
+		scheduleLock.Unlock()
+		runSchedulePhase(schedule, test.phase, now)
+		scheduleLock.Lock()
+		delete(ScheduleMap, schedule.Id)
+		scheduleLock.Unlock()
+
+		execution := schedule.execution(test.phase)
+		if execution == nil || execution.Result != resultFailed {
+			t.Errorf("%s %s: execution = %+v, want %s", test.action, test.phase, execution, resultFailed)
+		}
+	}
+}
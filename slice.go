@@ -0,0 +1,714 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Slice records the tc classes and filters installed for a slice. Downlink is
+// shaped on egress of the vxlan interface, uplink on the ifb device which
+// ingress traffic of the vxlan interface is redirected to.
+type Slice struct {
+	Bridge         string           `json:"Bridge"`
+	VxlanInterface string           `json:"VxlanInterface"`
+	Sst            uint8            `json:"SST"`
+	SliceSd        string           `json:"SliceSD,omitempty"`
+	Profile        string           `json:"Profile,omitempty"`
+	ClassId        uint16           `json:"ClassId"`
+	FilterHandles  []uint32         `json:"FilterHandles"`
+	FilterPrio     uint16           `json:"FilterPrio"`
+	DstIp          string           `json:"DstIP"`
+	SrcIp          string           `json:"SrcIP"`
+	Matches        []internal.Match `json:"Matches"`
+	FlowRate       int              `json:"FlowRate"`
+	DownlinkRate   int              `json:"DownlinkRate"`
+	DownlinkCeil   int              `json:"DownlinkCeil"`
+	UplinkRate     int              `json:"UplinkRate"`
+	UplinkCeil     int              `json:"UplinkCeil"`
+	Burst          uint32           `json:"Burst"`
+	Cburst         uint32           `json:"Cburst"`
+	Prio           uint32           `json:"Prio"`
+	// Latency and impairments of each shaped direction
+	Netem *internal.NetemSpec `json:"Netem,omitempty"`
+	// DSCP / 802.1p rewrite of downlink traffic
+	Remark *internal.Remark `json:"Remark,omitempty"`
+
+	IfbInterface        string   `json:"IfbInterface,omitempty"`
+	UplinkClassId       uint16   `json:"UplinkClassId,omitempty"`
+	UplinkFilterHandles []uint32 `json:"UplinkFilterHandles,omitempty"`
+	UplinkFilterPrio    uint16   `json:"UplinkFilterPrio,omitempty"`
+
+	// S-NSSAI of the end-to-end slice the slice is a hop of
+	EndToEnd string `json:"EndToEnd,omitempty"`
+
+	// Tenant the slice is nested under, with the class IDs of the tenant
+	Tenant              string `json:"Tenant,omitempty"`
+	ParentClassId       uint16 `json:"ParentClassId,omitempty"`
+	UplinkParentClassId uint16 `json:"UplinkParentClassId,omitempty"`
+}
+
+// Snssai returns the S-NSSAI of slice
+func (slice *Slice) Snssai() Snssai {
+	return Snssai{Sst: slice.Sst, Sd: slice.SliceSd}
+}
+
+// request returns the request which installs slice again
+func (slice *Slice) request() SliceRequest {
+	sst, prio := slice.Sst, slice.Prio
+	request := SliceRequest{
+		Sst:          &sst,
+		SliceSd:      slice.SliceSd,
+		DownlinkRate: slice.DownlinkRate,
+		DownlinkCeil: slice.DownlinkCeil,
+		UplinkRate:   slice.UplinkRate,
+		UplinkCeil:   slice.UplinkCeil,
+		Burst:        slice.Burst,
+		Cburst:       slice.Cburst,
+		Prio:         &prio,
+		Matches:      slice.Matches,
+	}
+	if slice.Netem != nil {
+		netem := *slice.Netem
+		request.Netem = &netem
+	}
+	if slice.Remark != nil {
+		remark := *slice.Remark
+		request.Remark = &remark
+	}
+
+	// DstIP and SrcIP are the first rule of matches, unless a profile set
+	// its Dscp
+	if len(slice.Matches) > 0 && reflect.DeepEqual(slice.Matches[0], internal.Match{DstIp: slice.DstIp, SrcIp: slice.SrcIp}) {
+		request.DstIp, request.SrcIp = slice.DstIp, slice.SrcIp
+		request.Matches = slice.Matches[1:]
+	}
+
+	return request
+}
+
+// validateRate checks the rates of slice, ceil defaults to the rate
+func (slice *Slice) validateRate() error {
+	if slice.DownlinkRate <= 0 {
+		return errors.New("FlowRate, DownlinkRate or Profile is required")
+	}
+	if slice.UplinkRate < 0 {
+		return errors.New("Invalid UplinkRate")
+	}
+
+	if slice.DownlinkCeil == 0 {
+		slice.DownlinkCeil = slice.DownlinkRate
+	}
+	if slice.UplinkCeil == 0 {
+		slice.UplinkCeil = slice.UplinkRate
+	}
+
+	if slice.DownlinkCeil < slice.DownlinkRate || slice.UplinkCeil < slice.UplinkRate {
+		return errors.New("Ceil should not be less than rate")
+	}
+
+	if slice.Prio > 7 {
+		return errors.New("Prio should be 0-7")
+	}
+
+	if slice.Remark.IsZero() {
+		slice.Remark = nil
+	} else if err := slice.Remark.Validate(); err != nil {
+		return fmt.Errorf("Invalid remark: %s", err.Error())
+	}
+
+	if slice.Netem != nil {
+		if slice.Netem.IsZero() {
+			slice.Netem = nil
+		} else if err := slice.Netem.Validate(); err != nil {
+			return fmt.Errorf("Invalid netem: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// downlinkSpec returns the htb class spec of slice downlink
+func (slice *Slice) downlinkSpec() internal.ClassSpec {
+	return internal.ClassSpec{
+		Rate:   slice.DownlinkRate,
+		Ceil:   slice.DownlinkCeil,
+		Burst:  slice.Burst,
+		Cburst: slice.Cburst,
+		Prio:   slice.Prio,
+		Parent: slice.ParentClassId,
+	}
+}
+
+// uplinkSpec returns the htb class spec of slice uplink
+func (slice *Slice) uplinkSpec() internal.ClassSpec {
+	return internal.ClassSpec{
+		Rate:   slice.UplinkRate,
+		Ceil:   slice.UplinkCeil,
+		Burst:  slice.Burst,
+		Cburst: slice.Cburst,
+		Prio:   slice.Prio,
+		Parent: slice.UplinkParentClassId,
+	}
+}
+
+// AdmissionResponse explains why a slice is rejected by admission control,
+// rates are in KB/Sec
+type AdmissionResponse struct {
+	Message   string `json:"message"`
+	Direction string `json:"direction"`
+	Capacity  int    `json:"capacity"`
+	Allocated int    `json:"allocated"`
+	Remaining int    `json:"remaining"`
+	Requested int    `json:"requested"`
+}
+
+// admitSlice checks the guaranteed rates of slices on the vxlan interface,
+// with slice replacing the one of the same S-NSSAI, fit in the link capacity.
+// A slice of a tenant is checked against the rates of the tenant instead. It
+// returns nil if the slice is admitted. The caller must hold sliceLock.
+func admitSlice(slice *Slice) *AdmissionResponse {
+	if slice.Tenant != "" {
+		return admitTenantSlice(slice)
+	}
+
+	capacity := internal.LinkCapacity(slice.VxlanInterface)
+	downlink, uplink := allocatedRates(slice.Bridge, slice.Snssai().String())
+
+	if downlink+slice.DownlinkRate > capacity {
+		return newAdmissionResponse("downlink", capacity, downlink, slice.DownlinkRate)
+	}
+	if slice.UplinkRate > 0 && uplink+slice.UplinkRate > capacity {
+		return newAdmissionResponse("uplink", capacity, uplink, slice.UplinkRate)
+	}
+
+	return nil
+}
+
+// allocatedRates sums the guaranteed rates of the classes under the parent
+// class of bridge: the default class, tenants and slices not of a tenant,
+// except the slice of S-NSSAI snssai. The caller must hold sliceLock.
+func allocatedRates(bridgeName, snssai string) (downlink, uplink int) {
+	// Default class is guaranteed as well
+	downlink = internal.DefaultLinkConfig.DefaultRate
+	uplink = internal.DefaultLinkConfig.DefaultRate
+	for key, other := range SliceMap[bridgeName] {
+		if key == snssai || other.Tenant != "" {
+			continue
+		}
+		downlink += other.DownlinkRate
+		uplink += other.UplinkRate
+	}
+
+	for _, tenant := range TenantMap[bridgeName] {
+		downlink += tenant.DownlinkRate
+		uplink += tenant.UplinkRate
+	}
+
+	for _, other := range DiscoveredSliceMap[bridgeName] {
+		if other.Tenant == "" {
+			downlink += other.DownlinkRate
+			uplink += other.UplinkRate
+		}
+	}
+
+	return downlink, uplink
+}
+
+func newAdmissionResponse(direction string, capacity, allocated, requested int) *AdmissionResponse {
+	remaining := capacity - allocated
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &AdmissionResponse{
+		Message: fmt.Sprintf("Not enough %s bandwidth, requested %d KB/Sec, remaining %d KB/Sec of capacity %d KB/Sec",
+			direction, requested, remaining, capacity),
+		Direction: direction,
+		Capacity:  capacity,
+		Allocated: allocated,
+		Remaining: remaining,
+		Requested: requested,
+	}
+}
+
+// installSlice installs the classes and filters of slice, and records their
+// handles in slice. Nothing is left installed if it fails.
+func installSlice(k internal.Kernel, slice *Slice) error {
+	classId, err := k.AddQdisc(slice.VxlanInterface, slice.downlinkSpec())
+	if err != nil {
+		return fmt.Errorf("failed to add qdisc, %w", err)
+	}
+
+	filterHandles, filterPrio, err := k.AddFilter(slice.VxlanInterface, slice.Matches, classId, slice.Remark)
+	if err != nil {
+		k.DelQdisc(slice.VxlanInterface, classId)
+		return fmt.Errorf("failed to add filter, %w", err)
+	}
+
+	slice.ClassId = classId
+	slice.FilterHandles = filterHandles
+	slice.FilterPrio = filterPrio
+
+	if slice.Netem != nil {
+		if err := k.SetNetem(slice.VxlanInterface, classId, slice.Netem, slice.DownlinkCeil); err != nil {
+			k.DelFilter(slice.VxlanInterface, slice.FilterPrio)
+			k.DelQdisc(slice.VxlanInterface, slice.ClassId)
+			return fmt.Errorf("failed to set netem, %w", err)
+		}
+	}
+
+	if slice.UplinkRate == 0 {
+		return nil
+	}
+
+	if err := installUplink(k, slice); err != nil {
+		k.DelFilter(slice.VxlanInterface, slice.FilterPrio)
+		k.DelQdisc(slice.VxlanInterface, slice.ClassId)
+		return err
+	}
+
+	return nil
+}
+
+// installUplink installs the uplink class and filters of slice on ifb device
+func installUplink(k internal.Kernel, slice *Slice) error {
+	ifbInterface, err := k.SetupIfb(slice.VxlanInterface)
+	if err != nil {
+		return fmt.Errorf("failed to setup ifb, %w", err)
+	}
+
+	classId, err := k.AddQdisc(ifbInterface, slice.uplinkSpec())
+	if err != nil {
+		return fmt.Errorf("failed to add uplink qdisc, %w", err)
+	}
+
+	// Uplink packets of the flows have source and destination swapped
+	filterHandles, filterPrio, err := k.AddFilter(ifbInterface, internal.ReverseMatches(slice.Matches), classId, nil)
+	if err != nil {
+		k.DelQdisc(ifbInterface, classId)
+		return fmt.Errorf("failed to add uplink filter, %w", err)
+	}
+
+	if slice.Netem != nil {
+		if err := k.SetNetem(ifbInterface, classId, slice.Netem, slice.UplinkCeil); err != nil {
+			k.DelFilter(ifbInterface, filterPrio)
+			k.DelQdisc(ifbInterface, classId)
+			return fmt.Errorf("failed to set uplink netem, %w", err)
+		}
+	}
+
+	slice.IfbInterface = ifbInterface
+	slice.UplinkClassId = classId
+	slice.UplinkFilterHandles = filterHandles
+	slice.UplinkFilterPrio = filterPrio
+	return nil
+}
+
+// changeSlice changes the installed classes and filters of slice to updated
+// in place, and records the new handles in updated. The class changes are
+// reverted if a later step fails.
+func changeSlice(k internal.Kernel, slice, updated *Slice) (err error) {
+	var reverts []func()
+	defer func() {
+		if err != nil {
+			for i := len(reverts) - 1; i >= 0; i-- {
+				reverts[i]()
+			}
+		}
+	}()
+
+	if slice.downlinkSpec() != updated.downlinkSpec() {
+		err := k.ChangeQdisc(slice.VxlanInterface, slice.ClassId, updated.downlinkSpec())
+		if err != nil {
+			return fmt.Errorf("failed to change class, %w", err)
+		}
+		reverts = append(reverts, func() {
+			k.ChangeQdisc(slice.VxlanInterface, slice.ClassId, slice.downlinkSpec())
+		})
+	}
+
+	if updated.UplinkRate > 0 && slice.IfbInterface != "" &&
+		slice.uplinkSpec() != updated.uplinkSpec() {
+		err := k.ChangeQdisc(slice.IfbInterface, slice.UplinkClassId, updated.uplinkSpec())
+		if err != nil {
+			return fmt.Errorf("failed to change uplink class, %w", err)
+		}
+		reverts = append(reverts, func() {
+			k.ChangeQdisc(slice.IfbInterface, slice.UplinkClassId, slice.uplinkSpec())
+		})
+	}
+
+	matchesChanged := !reflect.DeepEqual(slice.Matches, updated.Matches)
+	if matchesChanged || !reflect.DeepEqual(slice.Remark, updated.Remark) {
+		handles, err := k.ReplaceFilter(slice.VxlanInterface, updated.Matches, slice.ClassId, slice.FilterPrio, slice.FilterHandles, updated.Remark)
+		if err != nil {
+			return fmt.Errorf("failed to replace filter, %w", err)
+		}
+		updated.FilterHandles = handles
+	}
+
+	// Uplink is not remarked
+	if matchesChanged && slice.IfbInterface != "" {
+		handles, err := k.ReplaceFilter(slice.IfbInterface, internal.ReverseMatches(updated.Matches), slice.UplinkClassId, slice.UplinkFilterPrio, slice.UplinkFilterHandles, nil)
+		if err != nil {
+			return fmt.Errorf("failed to replace uplink filter, %w", err)
+		}
+		updated.UplinkFilterHandles = handles
+	}
+
+	if !reflect.DeepEqual(slice.Netem, updated.Netem) {
+		if err := k.SetNetem(slice.VxlanInterface, slice.ClassId, updated.Netem, updated.DownlinkCeil); err != nil {
+			return fmt.Errorf("failed to set netem, %w", err)
+		}
+
+		if slice.IfbInterface != "" && updated.UplinkRate > 0 {
+			if err := k.SetNetem(slice.IfbInterface, slice.UplinkClassId, updated.Netem, updated.UplinkCeil); err != nil {
+				return fmt.Errorf("failed to set uplink netem, %w", err)
+			}
+		}
+	}
+
+	if updated.UplinkRate > 0 && slice.IfbInterface == "" {
+		// uplink was not shaped
+		if err := installUplink(k, updated); err != nil {
+			return err
+		}
+	}
+
+	// Last, so no change is left to revert after the uplink is removed
+	if updated.UplinkRate == 0 && slice.IfbInterface != "" {
+		if err := removeUplink(k, updated); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeUplink removes the uplink class and filters of slice, and clears
+// them in slice
+func removeUplink(k internal.Kernel, slice *Slice) error {
+	if err := k.DelFilter(slice.IfbInterface, slice.UplinkFilterPrio); err != nil {
+		return fmt.Errorf("failed to delete uplink filter, %w", err)
+	}
+
+	if err := k.DelQdisc(slice.IfbInterface, slice.UplinkClassId); err != nil {
+		return fmt.Errorf("failed to delete uplink class, %w", err)
+	}
+
+	if err := releaseIfb(k, slice.VxlanInterface, slice.IfbInterface, slice.uplinkOwner()); err != nil {
+		return err
+	}
+
+	slice.IfbInterface = ""
+	slice.UplinkClassId = 0
+	slice.UplinkFilterHandles = nil
+	slice.UplinkFilterPrio = 0
+	return nil
+}
+
+// removeSlice removes the classes and filters of slice
+func removeSlice(k internal.Kernel, slice *Slice) error {
+	if slice.IfbInterface != "" {
+		if err := k.DelFilter(slice.IfbInterface, slice.UplinkFilterPrio); err != nil {
+			return fmt.Errorf("failed to delete uplink filter, %w", err)
+		}
+
+		if err := k.DelQdisc(slice.IfbInterface, slice.UplinkClassId); err != nil {
+			return fmt.Errorf("failed to delete uplink class, %w", err)
+		}
+
+		if err := releaseIfb(k, slice.VxlanInterface, slice.IfbInterface, slice.uplinkOwner()); err != nil {
+			return err
+		}
+
+		// Recorded so a retry after a later failure skips the uplink, a dry
+		// run leaves the record as it is
+		if !isPlan(k) {
+			slice.IfbInterface = ""
+		}
+	}
+
+	if err := k.DelFilter(slice.VxlanInterface, slice.FilterPrio); err != nil {
+		return fmt.Errorf("failed to delete filter, %w", err)
+	}
+
+	if err := k.DelQdisc(slice.VxlanInterface, slice.ClassId); err != nil {
+		return fmt.Errorf("failed to delete class, %w", err)
+	}
+
+	return nil
+}
+
+// uplinkOwner names slice among the users of ifb devices, see releaseIfb
+func (slice *Slice) uplinkOwner() string {
+	return "slice " + slice.Bridge + "/" + slice.Snssai().String()
+}
+
+// releaseIfb deletes ifb device ifbInterface of vxlan interface vxlanIf and
+// its ingress redirect, once no recorded slice or tenant other than owner,
+// nor slice class found at startup, has an uplink class on it. The caller
+// must hold sliceLock.
+func releaseIfb(k internal.Kernel, vxlanIf, ifbInterface, owner string) error {
+	for _, tenants := range TenantMap {
+		for _, tenant := range tenants {
+			if tenant.IfbInterface == ifbInterface && tenant.uplinkOwner() != owner {
+				return nil
+			}
+		}
+	}
+	for _, slices := range SliceMap {
+		for _, slice := range slices {
+			if slice.IfbInterface == ifbInterface && slice.uplinkOwner() != owner {
+				return nil
+			}
+		}
+	}
+	// Uplink classes found at startup are left in kernel
+	for _, slices := range DiscoveredSliceMap {
+		for _, slice := range slices {
+			if slice.IfbInterface == ifbInterface {
+				return nil
+			}
+		}
+	}
+
+	if err := k.TeardownIfb(vxlanIf); err != nil {
+		return fmt.Errorf("failed to delete ifb device, %w", err)
+	}
+	return nil
+}
+
+// SliceStats reports the traffic of a slice, read from its htb classes
+type SliceStats struct {
+	Bridge   string                 `json:"Bridge"`
+	Sst      uint8                  `json:"SST"`
+	SliceSd  string                 `json:"SliceSD,omitempty"`
+	Downlink *internal.TrafficStats `json:"Downlink"`
+	// Not set if uplink is not shaped
+	Uplink *internal.TrafficStats `json:"Uplink,omitempty"`
+}
+
+// Read statistics of the downlink class and, if shaped, the uplink class of
+// slice
+func sliceStats(slice *Slice) (*SliceStats, error) {
+	downlink, err := internal.ClassStats(slice.VxlanInterface, slice.ClassId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read downlink statistics, %w", err)
+	}
+
+	stats := &SliceStats{
+		Bridge:   slice.Bridge,
+		Sst:      slice.Sst,
+		SliceSd:  slice.SliceSd,
+		Downlink: downlink,
+	}
+
+	if slice.IfbInterface != "" {
+		uplink, err := internal.ClassStats(slice.IfbInterface, slice.UplinkClassId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uplink statistics, %w", err)
+		}
+		stats.Uplink = uplink
+	}
+
+	return stats, nil
+}
+
+// sliceError is a failed slice operation, with the status code and body to
+// respond
+type sliceError struct {
+	status  int
+	message string
+	// JSON body instead of message, if set
+	body interface{}
+}
+
+func (e *sliceError) Error() string {
+	return e.message
+}
+
+func newSliceError(status int, message string) error {
+	return &sliceError{status: status, message: message}
+}
+
+// respondSliceError writes err returned by slice operations to c
+func respondSliceError(c *gin.Context, err error) {
+	var sliceErr *sliceError
+	if !errors.As(err, &sliceErr) {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if sliceErr.body != nil {
+		c.JSON(sliceErr.status, sliceErr.body)
+		return
+	}
+	c.String(sliceErr.status, sliceErr.message)
+}
+
+// createSlice installs the slice of request on bridge and records it, nested
+// under tenant tenantId if it is not empty. API and scheduled activations both
+// take this path. If plan is not nil, the changes are recorded in plan
+// instead.
+func createSlice(bridgeName, tenantId string, request SliceRequest, plan *internal.Plan) (*Slice, error) {
+	k := kernel(plan)
+
+	var profile *SliceProfile
+	if request.Profile != "" {
+		var ok bool
+		if profile, ok = lookupProfile(request.Profile); !ok {
+			return nil, newSliceError(http.StatusBadRequest, "Profile not found")
+		}
+	}
+
+	slice, err := request.newSlice(profile)
+	if err != nil {
+		return nil, newSliceError(http.StatusBadRequest, err.Error())
+	}
+	if len(slice.Matches) == 0 {
+		return nil, newSliceError(http.StatusBadRequest, "DstIP, SrcIP or Matches is required")
+	}
+
+	if err := internal.ValidateMatches(slice.Matches); err != nil {
+		return nil, newSliceError(http.StatusBadRequest, fmt.Sprintf("Invalid match: %s", err.Error()))
+	}
+
+	if err := slice.validateRate(); err != nil {
+		return nil, newSliceError(http.StatusBadRequest, err.Error())
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	if _, exist := SliceMap[bridgeName][slice.Snssai().String()]; exist {
+		return nil, newSliceError(http.StatusConflict, "Slice existed")
+	}
+
+	sysLogger.Println("bridgeName, ", bridgeName)
+	vxlanInterface, ok := BridgeMap[bridgeName]
+
+	if !ok {
+		sysLogger.Println("Failed to find interface")
+		return nil, newSliceError(http.StatusInternalServerError, "Failed to find interface")
+	}
+
+	slice.Bridge = bridgeName
+	slice.VxlanInterface = vxlanInterface
+	slice.Tenant = tenantId
+	if err := slice.setTenant(); err != nil {
+		return nil, err
+	}
+
+	if rejected := admitSlice(slice); rejected != nil {
+		sysLogger.Println("Reject slice: ", rejected.Message)
+		notifySlice(plan, NotifySliceFailed, bridgeName, slice.Snssai().String(), rejected.Message)
+		return nil, &sliceError{status: http.StatusConflict, message: rejected.Message, body: rejected}
+	}
+
+	sysLogger.Println("Add slice on interface, ", vxlanInterface)
+	if err := installSlice(k, slice); err != nil {
+		sysLogger.Println("Failed to install slice: ", err)
+		notifySlice(plan, NotifySliceFailed, bridgeName, slice.Snssai().String(), fmt.Sprintf("Failed to install slice: %s", err.Error()))
+		return nil, newSliceError(http.StatusInternalServerError, fmt.Sprintf("Failed to install slice: %s", err.Error()))
+	}
+	if plan != nil {
+		return slice, nil
+	}
+
+	if SliceMap[bridgeName] == nil {
+		SliceMap[bridgeName] = make(map[string]*Slice)
+	}
+	SliceMap[bridgeName][slice.Snssai().String()] = slice
+	saveState()
+	notifySlice(nil, NotifySliceCreated, bridgeName, slice.Snssai().String(), "")
+
+	sysLogger.Println("Install slice successful, ", "S-NSSAI", slice.Snssai(), "DownlinkRate (KB/Sec)", slice.DownlinkRate, "UplinkRate (KB/Sec)", slice.UplinkRate)
+	return slice, nil
+}
+
+// modifySlice applies request to the slice of S-NSSAI snssai on bridge in
+// place, and returns the updated slice. If plan is not nil, the changes are
+// recorded in plan instead.
+func modifySlice(bridgeName, snssai string, request SliceUpdateRequest, plan *internal.Plan) (*Slice, error) {
+	k := kernel(plan)
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	slice, err := lookupSlice(bridgeName, snssai)
+	if err != nil {
+		return nil, newSliceError(lookupStatus(err), err.Error())
+	}
+	if slice.EndToEnd != "" {
+		return nil, newSliceError(http.StatusConflict, fmt.Sprintf("Slice is a hop of end-to-end slice %s", slice.EndToEnd))
+	}
+
+	updated, err := request.apply(slice)
+	if err != nil {
+		return nil, newSliceError(http.StatusBadRequest, err.Error())
+	}
+	if err := updated.setTenant(); err != nil {
+		return nil, err
+	}
+
+	if rejected := admitSlice(updated); rejected != nil {
+		sysLogger.Println("Reject slice update: ", rejected.Message)
+		notifySlice(plan, NotifySliceFailed, bridgeName, slice.Snssai().String(), rejected.Message)
+		return nil, &sliceError{status: http.StatusConflict, message: rejected.Message, body: rejected}
+	}
+
+	sysLogger.Println("Update slice ", "S-NSSAI", slice.Snssai(), "Bridge", bridgeName)
+	if err := changeSlice(k, slice, updated); err != nil {
+		sysLogger.Println("Failed to update slice: ", err)
+		notifySlice(plan, NotifySliceFailed, bridgeName, slice.Snssai().String(), fmt.Sprintf("Failed to update slice: %s", err.Error()))
+		return nil, newSliceError(http.StatusInternalServerError, fmt.Sprintf("Failed to update slice: %s", err.Error()))
+	}
+	if plan != nil {
+		return updated, nil
+	}
+
+	SliceMap[bridgeName][slice.Snssai().String()] = updated
+	saveState()
+	notifySlice(nil, NotifySliceUpdated, bridgeName, slice.Snssai().String(), "")
+	return updated, nil
+}
+
+// deleteSlice removes the slice of S-NSSAI snssai from bridge. If plan is not
+// nil, the changes are recorded in plan instead.
+func deleteSlice(bridgeName, snssai string, plan *internal.Plan) error {
+	k := kernel(plan)
+
+	sysLogger.Println("Delete slice ", "S-NSSAI", snssai, "Bridge", bridgeName)
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	slice, err := lookupSlice(bridgeName, snssai)
+	if err != nil {
+		return newSliceError(lookupStatus(err), err.Error())
+	}
+	if slice.EndToEnd != "" {
+		return newSliceError(http.StatusConflict, fmt.Sprintf("Slice is a hop of end-to-end slice %s", slice.EndToEnd))
+	}
+
+	if err := removeSlice(k, slice); err != nil {
+		sysLogger.Println("Failed to delete slice: ", err)
+		notifySlice(plan, NotifySliceFailed, bridgeName, slice.Snssai().String(), fmt.Sprintf("Failed to delete slice: %s", err.Error()))
+		return newSliceError(http.StatusInternalServerError, fmt.Sprintf("Failed to delete slice: %s", err.Error()))
+	}
+	if plan != nil {
+		return nil
+	}
+
+	delete(SliceMap[bridgeName], slice.Snssai().String())
+	saveState()
+	notifySlice(nil, NotifySliceDeleted, bridgeName, slice.Snssai().String(), "")
+	return nil
+}
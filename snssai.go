@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SD value which means no SD is associated with the SST, 3GPP TS 23.003 28.4.2
+const noSd = "ffffff"
+
+var errSliceNotFound = errors.New("Slice not found")
+
+// Snssai identifies a slice, 3GPP TS 23.003 28.4.2
+type Snssai struct {
+	// Slice/service type, 0-255
+	Sst uint8 `json:"SST"`
+	// Slice differentiator, 6 hex digits, empty if not associated
+	Sd string `json:"SD,omitempty"`
+}
+
+// String formats s as <SST>-<SD>, or <SST> if no SD associated
+func (s Snssai) String() string {
+	if s.Sd == "" {
+		return strconv.Itoa(int(s.Sst))
+	}
+	return fmt.Sprintf("%d-%s", s.Sst, s.Sd)
+}
+
+// less orders S-NSSAI by SST then SD, no SD first
+func (s Snssai) less(other Snssai) bool {
+	if s.Sst != other.Sst {
+		return s.Sst < other.Sst
+	}
+	return s.Sd < other.Sd
+}
+
+// normalizeSd validates sd as 24-bit hex, and returns it in lower case. The
+// reserved value ffffff is taken as no SD.
+func normalizeSd(sd string) (string, error) {
+	if sd == "" {
+		return "", nil
+	}
+
+	if len(sd) != 6 {
+		return "", errors.New("SD should be 6 hex digits")
+	}
+	if _, err := strconv.ParseUint(sd, 16, 32); err != nil {
+		return "", errors.New("SD should be 6 hex digits")
+	}
+
+	sd = strings.ToLower(sd)
+	if sd == noSd {
+		return "", nil
+	}
+	return sd, nil
+}
+
+// parseSnssai parses the S-NSSAI in path, <SST>-<SD> or <SST> with SST in
+// decimal. A bare SD of 6 hex digits is also taken, for slices created before
+// slices had SST; ok is false for it. A bare ffffff is rejected, it would
+// select the slices without SD of any SST.
+func parseSnssai(id string) (snssai Snssai, ok bool, err error) {
+	parts := strings.SplitN(id, "-", 2)
+	sstPart, sdPart, hasSd := parts[0], "", len(parts) == 2
+	if hasSd {
+		sdPart = parts[1]
+	}
+
+	if !hasSd && len(id) == 6 {
+		sd, err := normalizeSd(id)
+		if err != nil {
+			return Snssai{}, false, err
+		}
+		if sd == "" {
+			return Snssai{}, false, errors.New("SST is required for a slice without SD")
+		}
+		return Snssai{Sd: sd}, false, nil
+	}
+
+	sst, err := strconv.ParseUint(sstPart, 10, 8)
+	if err != nil {
+		return Snssai{}, false, errors.New("SST should be 0-255")
+	}
+
+	sd, err := normalizeSd(sdPart)
+	if err != nil {
+		return Snssai{}, false, err
+	}
+	if hasSd && sdPart == "" {
+		return Snssai{}, false, errors.New("SD should be 6 hex digits")
+	}
+
+	return Snssai{Sst: uint8(sst), Sd: sd}, true, nil
+}
+
+// lookupSlice finds the slice of S-NSSAI id on bridge. If id only has SD, the
+// slice is found if it is the only one with the SD on bridge.
+// The caller must hold sliceLock.
+func lookupSlice(bridgeName, id string) (*Slice, error) {
+	snssai, ok, err := parseSnssai(id)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid S-NSSAI: %s", err.Error())
+	}
+
+	if ok {
+		slice, exist := SliceMap[bridgeName][snssai.String()]
+		if !exist {
+			return nil, errSliceNotFound
+		}
+		return slice, nil
+	}
+
+	var found *Slice
+	for _, slice := range SliceMap[bridgeName] {
+		if slice.SliceSd != snssai.Sd {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("Invalid S-NSSAI: slices with different SST share the SD, SST is required")
+		}
+		found = slice
+	}
+
+	if found == nil {
+		return nil, errSliceNotFound
+	}
+	return found, nil
+}
+
+// Status code of the error returned by lookupSlice
+func lookupStatus(err error) int {
+	if errors.Is(err, errSliceNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestNormalizeSd(t *testing.T) {
+	tests := []struct {
+		sd   string
+		want string
+		err  bool
+	}{
+		{sd: "", want: ""},
+		{sd: "010203", want: "010203"},
+		{sd: "ABCDEF", want: "abcdef"},
+		{sd: "ffffff", want: ""},
+		{sd: "FFFFFF", want: ""},
+		{sd: "12345", err: true},
+		{sd: "1234567", err: true},
+		{sd: "12345g", err: true},
+		{sd: "-12345", err: true},
+	}
+
+	for _, test := range tests {
+		sd, err := normalizeSd(test.sd)
+		if test.err {
+			if err == nil {
+				t.Errorf("normalizeSd(%q) = %q, want error", test.sd, sd)
+			}
+			continue
+		}
+		if err != nil || sd != test.want {
+			t.Errorf("normalizeSd(%q) = %q, %v, want %q", test.sd, sd, err, test.want)
+		}
+	}
+}
+
+func TestParseSnssai(t *testing.T) {
+	tests := []struct {
+		id     string
+		snssai Snssai
+		ok     bool
+		err    bool
+	}{
+		{id: "1-010203", snssai: Snssai{Sst: 1, Sd: "010203"}, ok: true},
+		{id: "2-ABCDEF", snssai: Snssai{Sst: 2, Sd: "abcdef"}, ok: true},
+		{id: "1", snssai: Snssai{Sst: 1}, ok: true},
+		{id: "255", snssai: Snssai{Sst: 255}, ok: true},
+		{id: "1-ffffff", snssai: Snssai{Sst: 1}, ok: true},
+		{id: "010203", snssai: Snssai{Sd: "010203"}, ok: false},
+		{id: "ffffff", err: true},
+		{id: "256", err: true},
+		{id: "x-010203", err: true},
+		{id: "1-", err: true},
+		{id: "1-0102", err: true},
+		{id: "", err: true},
+	}
+
+	for _, test := range tests {
+		snssai, ok, err := parseSnssai(test.id)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseSnssai(%q) = %v, want error", test.id, snssai)
+			}
+			continue
+		}
+		if err != nil || snssai != test.snssai || ok != test.ok {
+			t.Errorf("parseSnssai(%q) = %v, %v, %v, want %v, %v", test.id, snssai, ok, err, test.snssai, test.ok)
+		}
+	}
+}
+
+func TestSnssaiString(t *testing.T) {
+	tests := []struct {
+		snssai Snssai
+		want   string
+	}{
+		{snssai: Snssai{Sst: 1, Sd: "010203"}, want: "1-010203"},
+		{snssai: Snssai{Sst: 3}, want: "3"},
+	}
+
+	for _, test := range tests {
+		if got := test.snssai.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.snssai, got, test.want)
+		}
+		parsed, ok, err := parseSnssai(test.want)
+		if err != nil || !ok || parsed != test.snssai {
+			t.Errorf("parseSnssai(%q) = %v, %v, %v, want %v", test.want, parsed, ok, err, test.snssai)
+		}
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Backends of the state store
+const (
+	storeNone = "none"
+	storeFile = "file"
+	storeBolt = "bolt"
+)
+
+// State is what the state store keeps of the vxlan bridges, the linux bridges
+// created by the bridge api, veth links, tenants and slices, with the kernel
+// handles of their classes and filters, and of the slice profiles. Profiles
+// is nil in the state kept before profiles were, the default profiles are
+// taken then.
+type State struct {
+	Bridges      []BridgeRecord  `json:"Bridges"`
+	LinuxBridges []string        `json:"LinuxBridges"`
+	Veths        []*VethLink     `json:"Veths"`
+	Tenants      []*Tenant       `json:"Tenants"`
+	Slices       []*Slice        `json:"Slices"`
+	E2eSlices    []*E2eSlice     `json:"E2eSlices"`
+	Profiles     []*SliceProfile `json:"Profiles"`
+}
+
+// BridgeRecord is a vxlan bridge kept by the state store
+type BridgeRecord struct {
+	Bridge         string `json:"Bridge"`
+	VxlanInterface string `json:"VxlanInterface"`
+	// Capacity (KB/Sec) shared by the slices on the vxlan interface
+	Capacity int `json:"Capacity"`
+	// Request the bridge is created with, read back from kernel for the
+	// bridges adopted by discovery
+	Config *VxlanInterfaceRequest `json:"Config,omitempty"`
+}
+
+// Store keeps the state across restarts. Save replaces the kept state as a
+// whole, so a crash leaves either the old or the new state.
+type Store interface {
+	Load() (*State, error)
+	Save(state *State) error
+	Close() error
+}
+
+// State store of TN-Manager, nil if the state is not kept
+var stateStore Store
+
+// openStore opens the state store of backend kind at path, a default path is
+// taken if path is empty. It returns nil if kind is none.
+func openStore(kind, path string) (Store, error) {
+	switch kind {
+	case storeNone, "":
+		return nil, nil
+	case storeFile:
+		if path == "" {
+			path = "/var/lib/tn-manager/state.json"
+		}
+		return &fileStore{path: path}, nil
+	case storeBolt:
+		if path == "" {
+			path = "/var/lib/tn-manager/state.db"
+		}
+		return openBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown state store %s, file, bolt or none is required", kind)
+	}
+}
+
+// saveState writes the bridges, veth links, tenants and slices to the state
+// store. The caller must hold sliceLock.
+func saveState() {
+	if stateStore == nil {
+		return
+	}
+
+	state := &State{
+		Bridges:      []BridgeRecord{},
+		LinuxBridges: []string{},
+		Veths:        []*VethLink{},
+		Tenants:      []*Tenant{},
+		Slices:       []*Slice{},
+		E2eSlices:    []*E2eSlice{},
+		Profiles:     []*SliceProfile{},
+	}
+
+	for bridgeName, vxlanIf := range BridgeMap {
+		record := BridgeRecord{
+			Bridge:         bridgeName,
+			VxlanInterface: vxlanIf,
+			Capacity:       internal.LinkCapacity(vxlanIf),
+		}
+		if config, ok := BridgeConfigMap[bridgeName]; ok {
+			record.Config = &config
+		}
+		state.Bridges = append(state.Bridges, record)
+	}
+	sort.Slice(state.Bridges, func(i, j int) bool {
+		return state.Bridges[i].Bridge < state.Bridges[j].Bridge
+	})
+
+	for bridgeName := range LinuxBridgeMap {
+		state.LinuxBridges = append(state.LinuxBridges, bridgeName)
+	}
+	sort.Strings(state.LinuxBridges)
+
+	for _, veth := range VethMap {
+		state.Veths = append(state.Veths, veth)
+	}
+	sort.Slice(state.Veths, func(i, j int) bool {
+		return state.Veths[i].Name < state.Veths[j].Name
+	})
+
+	for _, tenants := range TenantMap {
+		for _, tenant := range tenants {
+			state.Tenants = append(state.Tenants, tenant)
+		}
+	}
+	sort.Slice(state.Tenants, func(i, j int) bool {
+		if state.Tenants[i].Bridge != state.Tenants[j].Bridge {
+			return state.Tenants[i].Bridge < state.Tenants[j].Bridge
+		}
+		return state.Tenants[i].Id < state.Tenants[j].Id
+	})
+
+	for _, slices := range SliceMap {
+		for _, slice := range slices {
+			state.Slices = append(state.Slices, slice)
+		}
+	}
+	sort.Slice(state.Slices, func(i, j int) bool {
+		if state.Slices[i].Bridge != state.Slices[j].Bridge {
+			return state.Slices[i].Bridge < state.Slices[j].Bridge
+		}
+		return state.Slices[i].Snssai().less(state.Slices[j].Snssai())
+	})
+
+	for _, e2e := range E2eSliceMap {
+		state.E2eSlices = append(state.E2eSlices, e2e)
+	}
+	sort.Slice(state.E2eSlices, func(i, j int) bool {
+		return state.E2eSlices[i].Snssai().less(state.E2eSlices[j].Snssai())
+	})
+
+	for _, profile := range ProfileMap {
+		state.Profiles = append(state.Profiles, profile)
+	}
+	sort.Slice(state.Profiles, func(i, j int) bool {
+		return state.Profiles[i].Name < state.Profiles[j].Name
+	})
+
+	if err := stateStore.Save(state); err != nil {
+		sysLogger.Println("Failed to save state: ", err)
+	}
+}
+
+// restoreState loads the state store, and restores the records found in
+// kernel into BridgeMap, LinuxBridgeMap, VethMap, TenantMap, SliceMap and
+// E2eSliceMap, and the profiles into ProfileMap. A record is found if its links are in place and its classes
+// and filters are installed with the handles recorded. Records not found are reported as
+// stale and dropped. The caller must hold sliceLock.
+func restoreState(ports []internal.VxlanPort, report *DiscoveryReport) {
+	state, err := stateStore.Load()
+	if err != nil {
+		report.stale("store", "", fmt.Sprintf("failed to load state, %s", err))
+		return
+	}
+
+	if state.Profiles != nil {
+		profiles := make(map[string]*SliceProfile)
+		for _, profile := range state.Profiles {
+			if err := profile.validate(); err != nil {
+				report.stale("profile", profile.Name, err.Error())
+				continue
+			}
+			profiles[profile.Name] = profile
+			report.restored("profile " + profile.Name)
+		}
+
+		profileLock.Lock()
+		ProfileMap = profiles
+		profileLock.Unlock()
+	}
+
+	vxlanPorts := make(map[string]string)
+	for _, port := range ports {
+		vxlanPorts[port.Bridge] = port.Vxlan
+	}
+
+	recorded := make(map[string]bool)
+	for _, bridge := range state.Bridges {
+		recorded[bridge.Bridge] = true
+		if vxlanPorts[bridge.Bridge] != bridge.VxlanInterface {
+			report.stale("bridge", bridge.Bridge, fmt.Sprintf("vxlan interface %s is not a port of the bridge", bridge.VxlanInterface))
+			continue
+		}
+
+		BridgeMap[bridge.Bridge] = bridge.VxlanInterface
+		if bridge.Config != nil {
+			BridgeConfigMap[bridge.Bridge] = *bridge.Config
+		}
+		internal.SetLinkCapacity(bridge.VxlanInterface, "", bridge.Capacity)
+		if ifb := internal.IfbOf(bridge.VxlanInterface); ifb != "" {
+			internal.SetLinkCapacity(ifb, "", bridge.Capacity)
+		}
+		report.restored(bridge.Bridge)
+	}
+
+	for _, bridgeName := range state.LinuxBridges {
+		bridge, err := internal.ReadBridge(bridgeName)
+		if err != nil || bridge == nil {
+			report.stale("bridge", bridgeName, "linux bridge not found")
+			continue
+		}
+
+		LinuxBridgeMap[bridgeName] = true
+		report.restored(bridgeName)
+	}
+
+	for _, veth := range state.Veths {
+		master, err := internal.LinkMaster(veth.Name)
+		peerMaster, peerErr := internal.LinkMaster(veth.Peer)
+		if err != nil || peerErr != nil || master != veth.Bridge || peerMaster != veth.PeerBridge {
+			report.stale("veth", veth.Name, fmt.Sprintf("veth pair %s %s is not between %s and %s", veth.Name, veth.Peer, veth.Bridge, veth.PeerBridge))
+			continue
+		}
+
+		VethMap[veth.Name] = veth
+		report.restored("veth " + veth.Name)
+	}
+
+	tcs := make(linkTcs)
+	for _, tenant := range state.Tenants {
+		name := tenant.Bridge + "/" + tenant.Id
+		if BridgeMap[tenant.Bridge] == "" {
+			report.stale("tenant", name, "bridge not restored")
+			continue
+		}
+
+		err := tcs.verifyClass(tenant.VxlanInterface, tenant.ClassId, 0)
+		if err == nil && tenant.UplinkClassId != 0 {
+			err = tcs.verifyClass(tenant.IfbInterface, tenant.UplinkClassId, 0)
+		}
+		if err != nil {
+			report.stale("tenant", name, err.Error())
+			continue
+		}
+
+		if TenantMap[tenant.Bridge] == nil {
+			TenantMap[tenant.Bridge] = make(map[string]*Tenant)
+		}
+		TenantMap[tenant.Bridge][tenant.Id] = tenant
+		report.restored("tenant " + name)
+	}
+
+	for _, slice := range state.Slices {
+		name := slice.Bridge + "/" + slice.Snssai().String()
+		// Slices of an end-to-end slice may be on an interface, which is
+		// not a recorded bridge
+		if recorded[slice.Bridge] && BridgeMap[slice.Bridge] == "" {
+			report.stale("slice", name, "bridge not restored")
+			continue
+		}
+		if slice.Tenant != "" && TenantMap[slice.Bridge][slice.Tenant] == nil {
+			report.stale("slice", name, fmt.Sprintf("tenant %s not restored", slice.Tenant))
+			continue
+		}
+
+		if err := tcs.verifySlice(slice); err != nil {
+			report.stale("slice", name, err.Error())
+			continue
+		}
+
+		if SliceMap[slice.Bridge] == nil {
+			SliceMap[slice.Bridge] = make(map[string]*Slice)
+		}
+		SliceMap[slice.Bridge][slice.Snssai().String()] = slice
+		report.restored("slice " + name)
+	}
+
+	// An end-to-end slice is kept while any of its hops is, the hops not
+	// restored are reported missing by its status
+	for _, e2e := range state.E2eSlices {
+		snssai := e2e.Snssai().String()
+		found := false
+		for _, hop := range e2e.Path {
+			_, ok := SliceMap[hop.name()][snssai]
+			found = found || ok
+		}
+		if !found {
+			report.stale("e2eslice", snssai, "no hop restored")
+			continue
+		}
+
+		E2eSliceMap[snssai] = e2e
+		report.restored("e2eslice " + snssai)
+	}
+}
+
+// linkTcs caches the tc read from kernel by link name
+type linkTcs map[string]*internal.LinkTc
+
+func (tcs linkTcs) get(linkName string) (*internal.LinkTc, error) {
+	if tc, ok := tcs[linkName]; ok {
+		return tc, nil
+	}
+
+	tc, err := internal.ListTc(linkName)
+	if err != nil {
+		return nil, err
+	}
+	if tc == nil {
+		return nil, fmt.Errorf("no root htb qdisc on %s", linkName)
+	}
+
+	tcs[linkName] = tc
+	return tc, nil
+}
+
+// verifyClass checks class 1:classId is on link under parent 1:parentId, the
+// parent class 1:1 if parentId is 0
+func (tcs linkTcs) verifyClass(linkName string, classId, parentId uint16) error {
+	tc, err := tcs.get(linkName)
+	if err != nil {
+		return err
+	}
+
+	if parentId == 0 {
+		parentId = internal.ParentClassId
+	}
+	for _, class := range tc.Classes {
+		if class.ClassId == classId && class.Parent == parentId {
+			return nil
+		}
+	}
+	return fmt.Errorf("class %s under %s not found on %s", classHandle(classId), classHandle(parentId), linkName)
+}
+
+// verifyFilters checks the filters of handles are on link with priority
+// prio, and classify traffic into class 1:classId
+func (tcs linkTcs) verifyFilters(linkName string, classId, prio uint16, handles []uint32) error {
+	tc, err := tcs.get(linkName)
+	if err != nil {
+		return err
+	}
+
+	for _, handle := range handles {
+		found := false
+		for _, filter := range tc.Filters {
+			found = found || (filter.Handle == handle && filter.Prio == prio && filter.ClassId == classId)
+		}
+		if !found {
+			return fmt.Errorf("filter %s prio %d of class %s not found on %s", netlink.HandleStr(handle), prio, classHandle(classId), linkName)
+		}
+	}
+	return nil
+}
+
+// verifySlice checks the classes and filters of slice are installed
+func (tcs linkTcs) verifySlice(slice *Slice) error {
+	if err := tcs.verifyClass(slice.VxlanInterface, slice.ClassId, slice.ParentClassId); err != nil {
+		return err
+	}
+	if err := tcs.verifyFilters(slice.VxlanInterface, slice.ClassId, slice.FilterPrio, slice.FilterHandles); err != nil {
+		return err
+	}
+
+	if slice.UplinkClassId == 0 {
+		return nil
+	}
+	if err := tcs.verifyClass(slice.IfbInterface, slice.UplinkClassId, slice.UplinkParentClassId); err != nil {
+		return err
+	}
+	return tcs.verifyFilters(slice.IfbInterface, slice.UplinkClassId, slice.UplinkFilterPrio, slice.UplinkFilterHandles)
+}
+
+// fileStore keeps the state as a json file, which is replaced at once by
+// renaming a synced temp file over it
+type fileStore struct {
+	path string
+}
+
+func (store *fileStore) Load() (*State, error) {
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (store *fileStore) Save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.path), 0755); err != nil {
+		return err
+	}
+
+	tmpFile := store.path + ".tmp"
+	file, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, store.path)
+}
+
+func (store *fileStore) Close() error {
+	return nil
+}
+
+// Buckets of the bolt store, each keeps records as json keyed by their name
+var (
+	bridgeBucket      = []byte("bridges")
+	linuxBridgeBucket = []byte("linuxbridges")
+	vethBucket        = []byte("veths")
+	tenantBucket      = []byte("tenants")
+	sliceBucket       = []byte("slices")
+	e2eSliceBucket    = []byte("e2eslices")
+	profileBucket     = []byte("profiles")
+)
+
+// boltStore keeps the state in an embedded bbolt database, replaced in one
+// transaction
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	// Fail instead of waiting if another TN-Manager holds the database
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (store *boltStore) Load() (*State, error) {
+	state := &State{}
+	buckets := []struct {
+		name []byte
+		add  func(value []byte) error
+	}{
+		{bridgeBucket, func(value []byte) error {
+			var record BridgeRecord
+			err := json.Unmarshal(value, &record)
+			state.Bridges = append(state.Bridges, record)
+			return err
+		}},
+		{linuxBridgeBucket, func(value []byte) error {
+			var bridgeName string
+			err := json.Unmarshal(value, &bridgeName)
+			state.LinuxBridges = append(state.LinuxBridges, bridgeName)
+			return err
+		}},
+		{vethBucket, func(value []byte) error {
+			veth := &VethLink{}
+			state.Veths = append(state.Veths, veth)
+			return json.Unmarshal(value, veth)
+		}},
+		{tenantBucket, func(value []byte) error {
+			tenant := &Tenant{}
+			state.Tenants = append(state.Tenants, tenant)
+			return json.Unmarshal(value, tenant)
+		}},
+		{sliceBucket, func(value []byte) error {
+			slice := &Slice{}
+			state.Slices = append(state.Slices, slice)
+			return json.Unmarshal(value, slice)
+		}},
+		{e2eSliceBucket, func(value []byte) error {
+			e2e := &E2eSlice{}
+			state.E2eSlices = append(state.E2eSlices, e2e)
+			return json.Unmarshal(value, e2e)
+		}},
+		{profileBucket, func(value []byte) error {
+			profile := &SliceProfile{}
+			state.Profiles = append(state.Profiles, profile)
+			return json.Unmarshal(value, profile)
+		}},
+	}
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		// Profiles are kept, even if all are deleted
+		if tx.Bucket(profileBucket) != nil {
+			state.Profiles = []*SliceProfile{}
+		}
+
+		for _, b := range buckets {
+			bucket := tx.Bucket(b.name)
+			if bucket == nil {
+				continue
+			}
+
+			err := bucket.ForEach(func(key, value []byte) error {
+				if err := b.add(value); err != nil {
+					return fmt.Errorf("invalid record %s of %s, %w", key, b.name, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (store *boltStore) Save(state *State) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		records := map[string]map[string]interface{}{
+			string(bridgeBucket):      {},
+			string(linuxBridgeBucket): {},
+			string(vethBucket):        {},
+			string(tenantBucket):      {},
+			string(sliceBucket):       {},
+			string(e2eSliceBucket):    {},
+			string(profileBucket):     {},
+		}
+		for _, bridge := range state.Bridges {
+			records[string(bridgeBucket)][bridge.Bridge] = bridge
+		}
+		for _, bridgeName := range state.LinuxBridges {
+			records[string(linuxBridgeBucket)][bridgeName] = bridgeName
+		}
+		for _, veth := range state.Veths {
+			records[string(vethBucket)][veth.Name] = veth
+		}
+		for _, tenant := range state.Tenants {
+			records[string(tenantBucket)][tenant.Bridge+"/"+tenant.Id] = tenant
+		}
+		for _, slice := range state.Slices {
+			records[string(sliceBucket)][slice.Bridge+"/"+slice.Snssai().String()] = slice
+		}
+		for _, e2e := range state.E2eSlices {
+			records[string(e2eSliceBucket)][e2e.Snssai().String()] = e2e
+		}
+		for _, profile := range state.Profiles {
+			records[string(profileBucket)][profile.Name] = profile
+		}
+
+		for name, bucketRecords := range records {
+			if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			bucket, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+
+			for key, record := range bucketRecords {
+				value, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// TenantRequest represents the request body for the addTenant endpoint
+type TenantRequest struct {
+	// Aggregate guaranteed downlink rate (KB/Sec) of the slices of the tenant
+	DownlinkRate int `json:"DownlinkRate"`
+	// Aggregate downlink limit (KB/Sec), defaults to downlink rate
+	DownlinkCeil int `json:"DownlinkCeil,omitempty"`
+	// Aggregate uplink rate (KB/Sec), uplink of the slices is not shaped if not set
+	UplinkRate int `json:"UplinkRate,omitempty"`
+	// Aggregate uplink limit (KB/Sec), defaults to uplink rate
+	UplinkCeil int `json:"UplinkCeil,omitempty"`
+	// Priority to borrow idle bandwidth, 0 (highest) - 7
+	Prio uint32 `json:"Prio,omitempty"`
+}
+
+// Tenant is a htb class on the vxlan interface of a bridge, with the classes
+// of the slices of the tenant nested under it. Slices share the rate and are
+// limited by the ceil of the tenant as a whole.
+type Tenant struct {
+	Id             string `json:"Id"`
+	Bridge         string `json:"Bridge"`
+	VxlanInterface string `json:"VxlanInterface"`
+	ClassId        uint16 `json:"ClassId"`
+	DownlinkRate   int    `json:"DownlinkRate"`
+	DownlinkCeil   int    `json:"DownlinkCeil"`
+	UplinkRate     int    `json:"UplinkRate"`
+	UplinkCeil     int    `json:"UplinkCeil"`
+	Prio           uint32 `json:"Prio"`
+
+	IfbInterface  string `json:"IfbInterface,omitempty"`
+	UplinkClassId uint16 `json:"UplinkClassId,omitempty"`
+
+	// Adopted from kernel at startup, the ID is named after the class ID
+	Discovered bool `json:"Discovered,omitempty"`
+}
+
+// TenantResponse reports a tenant with its slices, rates are in KB/Sec
+type TenantResponse struct {
+	Tenant
+	// S-NSSAI of the slices of the tenant
+	Slices []string `json:"Slices"`
+	// Guaranteed rates of the slices of the tenant, and of the slice classes
+	// found under it at startup
+	DownlinkAllocated int `json:"DownlinkAllocated"`
+	UplinkAllocated   int `json:"UplinkAllocated"`
+}
+
+// Map bridge name to map tenant ID to tenant, guarded by sliceLock
+var TenantMap map[string]map[string]*Tenant = make(map[string]map[string]*Tenant)
+
+// newTenant builds the tenant of request
+func (r TenantRequest) newTenant() (*Tenant, error) {
+	tenant := &Tenant{
+		DownlinkRate: r.DownlinkRate,
+		DownlinkCeil: r.DownlinkCeil,
+		UplinkRate:   r.UplinkRate,
+		UplinkCeil:   r.UplinkCeil,
+		Prio:         r.Prio,
+	}
+
+	if tenant.DownlinkRate <= 0 {
+		return nil, errors.New("DownlinkRate is required")
+	}
+	if tenant.UplinkRate < 0 {
+		return nil, errors.New("Invalid UplinkRate")
+	}
+
+	if tenant.DownlinkCeil == 0 {
+		tenant.DownlinkCeil = tenant.DownlinkRate
+	}
+	if tenant.UplinkCeil == 0 {
+		tenant.UplinkCeil = tenant.UplinkRate
+	}
+
+	if tenant.DownlinkCeil < tenant.DownlinkRate || tenant.UplinkCeil < tenant.UplinkRate {
+		return nil, errors.New("Ceil should not be less than rate")
+	}
+	if tenant.Prio > 7 {
+		return nil, errors.New("Prio should be 0-7")
+	}
+
+	return tenant, nil
+}
+
+// downlinkSpec returns the htb class spec of tenant downlink
+func (tenant *Tenant) downlinkSpec() internal.ClassSpec {
+	return internal.ClassSpec{
+		Rate: tenant.DownlinkRate,
+		Ceil: tenant.DownlinkCeil,
+		Prio: tenant.Prio,
+	}
+}
+
+// uplinkSpec returns the htb class spec of tenant uplink
+func (tenant *Tenant) uplinkSpec() internal.ClassSpec {
+	return internal.ClassSpec{
+		Rate: tenant.UplinkRate,
+		Ceil: tenant.UplinkCeil,
+		Prio: tenant.Prio,
+	}
+}
+
+// slices returns the slices of tenant sorted by S-NSSAI.
+// The caller must hold sliceLock.
+func (tenant *Tenant) slices() []*Slice {
+	slices := []*Slice{}
+	for _, slice := range SliceMap[tenant.Bridge] {
+		if slice.Tenant == tenant.Id {
+			slices = append(slices, slice)
+		}
+	}
+	sort.Slice(slices, func(i, j int) bool {
+		return slices[i].Snssai().less(slices[j].Snssai())
+	})
+
+	return slices
+}
+
+// discoveredSlices returns the slice classes found under tenant at startup.
+// The caller must hold sliceLock.
+func (tenant *Tenant) discoveredSlices() []*DiscoveredSlice {
+	slices := []*DiscoveredSlice{}
+	for _, slice := range DiscoveredSliceMap[tenant.Bridge] {
+		if slice.Tenant == tenant.Id {
+			slices = append(slices, slice)
+		}
+	}
+
+	return slices
+}
+
+// response reports tenant with its slices. The caller must hold sliceLock.
+func (tenant *Tenant) response() TenantResponse {
+	response := TenantResponse{Tenant: *tenant, Slices: []string{}}
+	for _, slice := range tenant.slices() {
+		response.Slices = append(response.Slices, slice.Snssai().String())
+		response.DownlinkAllocated += slice.DownlinkRate
+		response.UplinkAllocated += slice.UplinkRate
+	}
+	for _, slice := range tenant.discoveredSlices() {
+		response.DownlinkAllocated += slice.DownlinkRate
+		response.UplinkAllocated += slice.UplinkRate
+	}
+
+	return response
+}
+
+// setTenant nests slice under the classes of its tenant on the bridge of
+// slice. The caller must hold sliceLock.
+func (slice *Slice) setTenant() error {
+	if slice.Tenant == "" {
+		return nil
+	}
+
+	tenant, ok := TenantMap[slice.Bridge][slice.Tenant]
+	if !ok {
+		return newSliceError(http.StatusNotFound, fmt.Sprintf("Tenant %s not found on %s", slice.Tenant, slice.Bridge))
+	}
+
+	slice.ParentClassId = tenant.ClassId
+	if slice.UplinkRate > 0 {
+		if tenant.UplinkClassId == 0 {
+			return newSliceError(http.StatusBadRequest, "UplinkRate requires UplinkRate of the tenant")
+		}
+		slice.UplinkParentClassId = tenant.UplinkClassId
+	}
+
+	return nil
+}
+
+// admitTenantSlice checks the guaranteed rates of the slices of the tenant of
+// slice, with slice replacing the one of the same S-NSSAI, fit in the rates of
+// the tenant. The caller must hold sliceLock.
+func admitTenantSlice(slice *Slice) *AdmissionResponse {
+	tenant, ok := TenantMap[slice.Bridge][slice.Tenant]
+	if !ok {
+		return &AdmissionResponse{Message: fmt.Sprintf("Tenant %s not found", slice.Tenant)}
+	}
+
+	downlink, uplink := 0, 0
+	for _, other := range tenant.slices() {
+		if other.Snssai() == slice.Snssai() {
+			continue
+		}
+		downlink += other.DownlinkRate
+		uplink += other.UplinkRate
+	}
+	for _, other := range tenant.discoveredSlices() {
+		downlink += other.DownlinkRate
+		uplink += other.UplinkRate
+	}
+
+	var rejected *AdmissionResponse
+	if downlink+slice.DownlinkRate > tenant.DownlinkRate {
+		rejected = newAdmissionResponse("downlink", tenant.DownlinkRate, downlink, slice.DownlinkRate)
+	} else if slice.UplinkRate > 0 && uplink+slice.UplinkRate > tenant.UplinkRate {
+		rejected = newAdmissionResponse("uplink", tenant.UplinkRate, uplink, slice.UplinkRate)
+	}
+
+	if rejected != nil {
+		rejected.Message = fmt.Sprintf("%s of tenant %s", rejected.Message, tenant.Id)
+	}
+	return rejected
+}
+
+// installTenant adds the classes of tenant under the parent class of the
+// vxlan interface and its ifb device
+func installTenant(k internal.Kernel, tenant *Tenant) error {
+	classId, err := k.AddQdisc(tenant.VxlanInterface, tenant.downlinkSpec())
+	if err != nil {
+		return fmt.Errorf("failed to add qdisc, %w", err)
+	}
+	tenant.ClassId = classId
+
+	if tenant.UplinkRate == 0 {
+		return nil
+	}
+
+	ifbInterface, err := k.SetupIfb(tenant.VxlanInterface)
+	if err != nil {
+		k.DelQdisc(tenant.VxlanInterface, classId)
+		return fmt.Errorf("failed to setup ifb, %w", err)
+	}
+
+	uplinkClassId, err := k.AddQdisc(ifbInterface, tenant.uplinkSpec())
+	if err != nil {
+		k.DelQdisc(tenant.VxlanInterface, classId)
+		return fmt.Errorf("failed to add uplink qdisc, %w", err)
+	}
+
+	tenant.IfbInterface = ifbInterface
+	tenant.UplinkClassId = uplinkClassId
+	return nil
+}
+
+// uplinkOwner names tenant among the users of ifb devices, see releaseIfb
+func (tenant *Tenant) uplinkOwner() string {
+	return "tenant " + tenant.Bridge + "/" + tenant.Id
+}
+
+// removeTenant removes the classes of tenant, its slices should be removed
+// first
+func removeTenant(k internal.Kernel, tenant *Tenant) error {
+	if tenant.IfbInterface != "" {
+		if err := k.DelQdisc(tenant.IfbInterface, tenant.UplinkClassId); err != nil {
+			return fmt.Errorf("failed to delete uplink class, %w", err)
+		}
+		if err := releaseIfb(k, tenant.VxlanInterface, tenant.IfbInterface, tenant.uplinkOwner()); err != nil {
+			return err
+		}
+		if !isPlan(k) {
+			tenant.IfbInterface = ""
+		}
+	}
+
+	if err := k.DelQdisc(tenant.VxlanInterface, tenant.ClassId); err != nil {
+		return fmt.Errorf("failed to delete class, %w", err)
+	}
+
+	return nil
+}
+
+// addTenant handles the POST /api/v1/tenant/:tenant_id/bridge/:bridge_name endpoint.
+// It adds the tenant class on the vxlan interface of bridge.
+//
+// @Summary Add tenant on bridge
+// @Description
+// @Tags tenant
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param bridge_name path string true "Bridge name"
+// @Param request body TenantRequest true "Tenant request"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 201 {object} TenantResponse
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 400 {string} string "Invalid tenant"
+// @Failure 404 {string} string "Bridge not found"
+// @Failure 409 {object} AdmissionResponse "Tenant existed, or not enough bandwidth"
+// @Router /api/v1/tenant/{tenant_id}/bridge/{bridge_name} [post]
+func addTenant(c *gin.Context) {
+	tenantId, bridgeName := c.Param("tenant_id"), c.Param("bridge_name")
+
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	var request TenantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	tenant, err := request.newTenant()
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Invalid tenant: %s", err.Error()))
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	vxlanInterface, ok := BridgeMap[bridgeName]
+	if !ok {
+		c.String(http.StatusNotFound, "Bridge not found")
+		return
+	}
+	if _, exist := TenantMap[bridgeName][tenantId]; exist {
+		c.String(http.StatusConflict, "Tenant existed")
+		return
+	}
+
+	tenant.Id = tenantId
+	tenant.Bridge = bridgeName
+	tenant.VxlanInterface = vxlanInterface
+
+	// Tenant takes its guaranteed rates from the link as a slice does
+	capacity := internal.LinkCapacity(vxlanInterface)
+	downlink, uplink := allocatedRates(bridgeName, "")
+	var rejected *AdmissionResponse
+	if downlink+tenant.DownlinkRate > capacity {
+		rejected = newAdmissionResponse("downlink", capacity, downlink, tenant.DownlinkRate)
+	} else if tenant.UplinkRate > 0 && uplink+tenant.UplinkRate > capacity {
+		rejected = newAdmissionResponse("uplink", capacity, uplink, tenant.UplinkRate)
+	}
+	if rejected != nil {
+		sysLogger.Println("Reject tenant: ", rejected.Message)
+		notifyTenant(plan, NotifyTenantFailed, bridgeName, tenantId, rejected.Message)
+		c.JSON(http.StatusConflict, rejected)
+		return
+	}
+
+	sysLogger.Println("Add tenant on interface, ", vxlanInterface, "Tenant", tenantId)
+	if err := installTenant(kernel(plan), tenant); err != nil {
+		sysLogger.Println("Failed to install tenant: ", err)
+		notifyTenant(plan, NotifyTenantFailed, bridgeName, tenantId, fmt.Sprintf("Failed to install tenant: %s", err.Error()))
+		c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to install tenant: %s", err.Error()))
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	if TenantMap[bridgeName] == nil {
+		TenantMap[bridgeName] = make(map[string]*Tenant)
+	}
+	TenantMap[bridgeName][tenantId] = tenant
+	saveState()
+	notifyTenant(nil, NotifyTenantCreated, bridgeName, tenantId, "")
+
+	c.JSON(http.StatusCreated, tenant.response())
+}
+
+// listTenant handles the GET /api/v1/tenant endpoint.
+// It lists tenants on all vxlan bridges.
+//
+// @Summary List tenants
+// @Description
+// @Tags tenant
+// @Produce json
+// @Success 200 {array} TenantResponse
+// @Router /api/v1/tenant [get]
+func listTenant(c *gin.Context) {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	c.JSON(http.StatusOK, tenantResponses(""))
+}
+
+// retrieveTenant handles the GET /api/v1/tenant/:tenant_id endpoint.
+// It retrieve the tenant on each vxlan bridge it has a class on.
+//
+// @Summary Retrieve tenant
+// @Description
+// @Tags tenant
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Success 200 {array} TenantResponse
+// @Failure 404 {string} string "Tenant not found"
+// @Router /api/v1/tenant/{tenant_id} [get]
+func retrieveTenant(c *gin.Context) {
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	responses := tenantResponses(c.Param("tenant_id"))
+	if len(responses) == 0 {
+		c.String(http.StatusNotFound, "Tenant not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+// tenantResponses reports the tenants of ID tenantId, or all tenants if
+// tenantId is empty, sorted by ID and bridge. The caller must hold sliceLock.
+func tenantResponses(tenantId string) []TenantResponse {
+	responses := []TenantResponse{}
+	for _, tenants := range TenantMap {
+		for id, tenant := range tenants {
+			if tenantId == "" || id == tenantId {
+				responses = append(responses, tenant.response())
+			}
+		}
+	}
+	sort.Slice(responses, func(i, j int) bool {
+		if responses[i].Id != responses[j].Id {
+			return responses[i].Id < responses[j].Id
+		}
+		return responses[i].Bridge < responses[j].Bridge
+	})
+
+	return responses
+}
+
+// delTenant handles the DELETE /api/v1/tenant/:tenant_id/bridge/:bridge_name endpoint.
+// It removes the tenant class from the vxlan interface of bridge.
+//
+// @Summary Delete tenant on bridge
+// @Description
+// @Tags tenant
+// @Param tenant_id path string true "Tenant ID"
+// @Param bridge_name path string true "Bridge name"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 204 {string} string "Tenant deleted"
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 404 {string} string "Tenant not found"
+// @Failure 409 {string} string "Tenant has slices"
+// @Router /api/v1/tenant/{tenant_id}/bridge/{bridge_name} [delete]
+func delTenant(c *gin.Context) {
+	tenantId, bridgeName := c.Param("tenant_id"), c.Param("bridge_name")
+
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	sliceLock.Lock()
+	defer sliceLock.Unlock()
+
+	tenant, ok := TenantMap[bridgeName][tenantId]
+	if !ok {
+		c.String(http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if len(tenant.slices()) > 0 {
+		c.String(http.StatusConflict, "Tenant has slices, delete them first")
+		return
+	}
+	if len(tenant.discoveredSlices()) > 0 {
+		c.String(http.StatusConflict, "Tenant has slice classes found at startup")
+		return
+	}
+
+	sysLogger.Println("Delete tenant ", tenantId, "Bridge", bridgeName)
+	if err := removeTenant(kernel(plan), tenant); err != nil {
+		sysLogger.Println("Failed to delete tenant: ", err)
+		notifyTenant(plan, NotifyTenantFailed, bridgeName, tenantId, fmt.Sprintf("Failed to delete tenant: %s", err.Error()))
+		c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to delete tenant: %s", err.Error()))
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	delete(TenantMap[bridgeName], tenantId)
+	saveState()
+	notifyTenant(nil, NotifyTenantDeleted, bridgeName, tenantId, "")
+	c.String(http.StatusNoContent, "Tenant deleted")
+}
+
+// addTenantSlice handles the POST /api/v1/tenant/:tenant_id/slice/:bridge_name endpoint.
+// It adds slice nested under the tenant class on the vxlan interface of bridge.
+//
+// @Summary Add slice of tenant on bridge
+// @Description
+// @Tags tenant
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param bridge_name path string true "Bridge name"
+// @Param request body SliceRequest true "Slice request"
+// @Param dryRun query bool false "Return the planned changes without making them"
+// @Success 202 {string} string "Slice Installed"
+// @Success 200 {object} DryRunResponse "Planned changes of dry run"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 404 {string} string "Tenant not found"
+// @Failure 409 {object} AdmissionResponse "Slice existed, or not enough bandwidth of tenant"
+// @Router /api/v1/tenant/{tenant_id}/slice/{bridge_name} [post]
+func addTenantSlice(c *gin.Context) {
+	plan, ok := dryRunPlan(c)
+	if !ok {
+		return
+	}
+
+	var request SliceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if _, err := createSlice(c.Param("bridge_name"), c.Param("tenant_id"), request, plan); err != nil {
+		respondSliceError(c, err)
+		return
+	}
+	if plan != nil {
+		respondPlan(c, plan)
+		return
+	}
+
+	c.String(http.StatusAccepted, "Install Slice successful")
+}
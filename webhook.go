@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ast9501/TN-Manager/internal"
+)
+
+// Webhook subscriptions are called back with the notifications of bridges,
+// tenants and slices they select. A notification is delivered at least once: it is posted
+// again until the subscriber answers 2xx, and after the last attempt it is
+// kept in the dead letters of the subscription. Subscribers tell the
+// deliveries of the same notification by its Id. Subscriptions are kept in
+// memory only, they are not in the state store.
+
+// Types of Notification
+const (
+	NotifyBridgeCreated  = "bridge.created"
+	NotifyBridgeDeleted  = "bridge.deleted"
+	NotifyBridgeFailed   = "bridge.failed"
+	NotifyBridgeDegraded = "bridge.degraded"
+	NotifySliceCreated   = "slice.created"
+	NotifySliceUpdated   = "slice.updated"
+	NotifySliceDeleted   = "slice.deleted"
+	NotifySliceFailed    = "slice.failed"
+	NotifySliceDegraded  = "slice.degraded"
+	NotifyTenantCreated  = "tenant.created"
+	NotifyTenantDeleted  = "tenant.deleted"
+	NotifyTenantFailed   = "tenant.failed"
+	NotifyTenantDegraded = "tenant.degraded"
+)
+
+// NotificationTypes are the types of Notification
+var NotificationTypes = []string{
+	NotifyBridgeCreated, NotifyBridgeDeleted, NotifyBridgeFailed, NotifyBridgeDegraded,
+	NotifySliceCreated, NotifySliceUpdated, NotifySliceDeleted, NotifySliceFailed, NotifySliceDegraded,
+	NotifyTenantCreated, NotifyTenantDeleted, NotifyTenantFailed, NotifyTenantDegraded,
+}
+
+// Groups of NotificationTypes a subscription selects with <group>.*
+var notificationGroups = []string{"bridge", "slice", "tenant"}
+
+// Attempts of a delivery, the wait before a retry doubles from webhookBackoff
+const (
+	webhookAttempts = 6
+	webhookBackoff  = time.Second
+)
+
+// Timeout of a webhook call
+const webhookTimeout = 10 * time.Second
+
+// Notifications queued for a subscription, what does not fit is dead lettered
+const webhookQueue = 256
+
+// Dead letters kept per subscription, the oldest are dropped
+const deadLetterLimit = 100
+
+// Notification is a change of a bridge, tenant or slice, posted to the
+// subscribers
+type Notification struct {
+	// Id of the notification, the same on every delivery attempt
+	Id   string    `json:"Id"`
+	Time time.Time `json:"Time"`
+	// bridge.created, bridge.deleted, bridge.failed, bridge.degraded,
+	// slice.created, slice.updated, slice.deleted, slice.failed,
+	// slice.degraded, tenant.created, tenant.deleted, tenant.failed or
+	// tenant.degraded
+	Type string `json:"Type"`
+	// Bridge, or interface of the slices on interfaces
+	Bridge string `json:"Bridge"`
+	// S-NSSAI of slice notifications
+	Snssai string `json:"Snssai,omitempty"`
+	// ID of tenant notifications
+	Tenant string `json:"Tenant,omitempty"`
+	// The error of failures, the drift or kernel event of degradations
+	Message string `json:"Message,omitempty"`
+}
+
+// SubscriptionRequest represents the request of a webhook subscription
+type SubscriptionRequest struct {
+	// http or https URL the notifications are posted to
+	Url string `json:"Url" example:"http://10.0.0.5:9000/tn-events"`
+	// Notification types, bridge.*, slice.* or tenant.* selects a group,
+	// empty selects all
+	Events []string `json:"Events,omitempty" example:"bridge.*,slice.failed"`
+	// Bridges selected, empty selects all
+	Bridges []string `json:"Bridges,omitempty" example:"br0"`
+	// Key of the X-TN-Signature HMAC-SHA256 of the body, no signature if empty
+	Secret string `json:"Secret,omitempty"`
+}
+
+// Subscription is a webhook subscription. The secret is not returned.
+type Subscription struct {
+	Id      string    `json:"Id"`
+	Url     string    `json:"Url"`
+	Events  []string  `json:"Events"`
+	Bridges []string  `json:"Bridges"`
+	Signed  bool      `json:"Signed"`
+	Created time.Time `json:"Created"`
+	// Notifications delivered, queued and dead lettered
+	Delivered   int `json:"Delivered"`
+	Pending     int `json:"Pending"`
+	DeadLetters int `json:"DeadLetters"`
+
+	secret      []byte
+	queue       chan Notification
+	done        chan struct{}
+	deadLetters []DeadLetter
+}
+
+// DeadLetter is a notification which could not be delivered
+type DeadLetter struct {
+	Notification Notification `json:"Notification"`
+	Attempts     int          `json:"Attempts"`
+	LastError    string       `json:"LastError"`
+	Time         time.Time    `json:"Time"`
+}
+
+// Subscriptions by ID, guarded by subscriptionLock. sliceLock may be held
+// when subscriptionLock is taken, never the other way around.
+var subscriptions = make(map[string]*Subscription)
+var subscriptionLock sync.Mutex
+
+// Drifts last notified, by object and name, guarded by sliceLock. A drift is
+// notified again only when the drifts of its object change, so the drifts
+// which can not be fixed are not notified on every reconcile.
+var notifiedDrifts = make(map[string]string)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// notify queues notification n for the subscriptions which select it, it
+// does not block
+func notify(n Notification) {
+	n.Id = generateRandomString(16)
+	n.Time = time.Now()
+
+	subscriptionLock.Lock()
+	defer subscriptionLock.Unlock()
+
+	for _, sub := range subscriptions {
+		if !sub.match(n) {
+			continue
+		}
+		select {
+		case sub.queue <- n:
+		default:
+			sub.deadLetter(n, 0, "delivery queue full")
+		}
+	}
+}
+
+// notifyBridge notifies a change of bridge, nothing is notified for the dry
+// runs of plan
+func notifyBridge(plan *internal.Plan, notificationType, bridgeName, message string) {
+	if plan != nil {
+		return
+	}
+	notify(Notification{Type: notificationType, Bridge: bridgeName, Message: message})
+}
+
+// notifySlice notifies a change of the slice of S-NSSAI snssai on bridge,
+// nothing is notified for the dry runs of plan
+func notifySlice(plan *internal.Plan, notificationType, bridgeName, snssai, message string) {
+	if plan != nil {
+		return
+	}
+	notify(Notification{Type: notificationType, Bridge: bridgeName, Snssai: snssai, Message: message})
+}
+
+// notifyTenant notifies a change of tenant on bridge, nothing is notified for
+// the dry runs of plan
+func notifyTenant(plan *internal.Plan, notificationType, bridgeName, tenantId, message string) {
+	if plan != nil {
+		return
+	}
+	notify(Notification{Type: notificationType, Bridge: bridgeName, Tenant: tenantId, Message: message})
+}
+
+// notifyDrifts notifies the bridges, tenants and slices the reconcile found
+// drifted, one notification each, unless their drifts are the ones last
+// notified. Interfaces are notified as bridges. The caller must hold
+// sliceLock.
+func notifyDrifts(report *ReconcileReport) {
+	drifts := make(map[string][]string)
+	names := []string{}
+	for _, action := range report.Actions {
+		key := action.Object + " " + action.Name
+		if _, ok := drifts[key]; !ok {
+			names = append(names, key)
+		}
+		drift := action.Drift
+		if action.Error != "" {
+			drift += ", not fixed: " + action.Error
+		}
+		drifts[key] = append(drifts[key], drift)
+	}
+
+	notified := make(map[string]string)
+	for _, key := range names {
+		message := strings.Join(drifts[key], "; ")
+		notified[key] = message
+		if notifiedDrifts[key] == message {
+			continue
+		}
+
+		object, name := splitKey(key, " ")
+		bridgeName, id := splitKey(name, "/")
+		switch object {
+		case "slice":
+			notifySlice(nil, NotifySliceDegraded, bridgeName, id, message)
+		case "tenant":
+			notifyTenant(nil, NotifyTenantDegraded, bridgeName, id, message)
+		default:
+			notifyBridge(nil, NotifyBridgeDegraded, bridgeName, message)
+		}
+	}
+	notifiedDrifts = notified
+}
+
+func splitKey(key, sep string) (string, string) {
+	if i := strings.Index(key, sep); i >= 0 {
+		return key[:i], key[i+len(sep):]
+	}
+	return key, ""
+}
+
+// notifyEvent notifies the kernel events which degrade a managed bridge: the
+// bridge or its vxlan interface going down or removed, or the bridge losing
+// a port
+func notifyEvent(event internal.Event) {
+	switch event.Type {
+	case internal.EventLinkDown, internal.EventLinkRemoved, internal.EventPortRemoved:
+	default:
+		return
+	}
+
+	sliceLock.Lock()
+	bridgeName := ""
+	for name, vxlanIf := range BridgeMap {
+		if event.Link == name || event.Bridge == name ||
+			(event.Link == vxlanIf && event.Type != internal.EventPortRemoved) {
+			bridgeName = name
+			break
+		}
+	}
+	sliceLock.Unlock()
+
+	if bridgeName != "" {
+		notifyBridge(nil, NotifyBridgeDegraded, bridgeName, event.Message)
+	}
+}
+
+func (sub *Subscription) match(n Notification) bool {
+	if len(sub.Bridges) > 0 && !contains(sub.Bridges, n.Bridge) {
+		return false
+	}
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, event := range sub.Events {
+		if event == n.Type || strings.HasSuffix(event, ".*") && strings.HasPrefix(n.Type, strings.TrimSuffix(event, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// deadLetter keeps n which could not be delivered. The caller must hold
+// subscriptionLock.
+func (sub *Subscription) deadLetter(n Notification, attempts int, lastError string) {
+	sysLogger.Println("Failed to deliver notification ", n.Id, " to ", sub.Url, ": ", lastError)
+	sub.deadLetters = append(sub.deadLetters, DeadLetter{
+		Notification: n,
+		Attempts:     attempts,
+		LastError:    lastError,
+		Time:         time.Now(),
+	})
+	if len(sub.deadLetters) > deadLetterLimit {
+		sub.deadLetters = sub.deadLetters[len(sub.deadLetters)-deadLetterLimit:]
+	}
+}
+
+// run delivers the queued notifications in order, until the subscription is
+// deleted
+func (sub *Subscription) run() {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case n := <-sub.queue:
+			sub.deliver(n)
+		}
+	}
+}
+
+// deliver posts n until it is accepted or the attempts run out
+func (sub *Subscription) deliver(n Notification) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		sysLogger.Println("Failed to encode notification: ", err)
+		return
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = sub.post(n, body)
+		if err == nil {
+			subscriptionLock.Lock()
+			sub.Delivered++
+			subscriptionLock.Unlock()
+			return
+		}
+		if attempt == webhookAttempts {
+			subscriptionLock.Lock()
+			sub.deadLetter(n, attempt, err.Error())
+			subscriptionLock.Unlock()
+			return
+		}
+
+		select {
+		case <-sub.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (sub *Subscription) post(n Notification, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, sub.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-TN-Event", n.Type)
+	request.Header.Set("X-TN-Delivery", n.Id)
+	if len(sub.secret) > 0 {
+		mac := hmac.New(sha256.New, sub.secret)
+		mac.Write(body)
+		request.Header.Set("X-TN-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("subscriber answered %s", response.Status)
+	}
+	return nil
+}
+
+// view copies the subscription with its counters. The caller must hold
+// subscriptionLock.
+func (sub *Subscription) view() Subscription {
+	return Subscription{
+		Id:          sub.Id,
+		Url:         sub.Url,
+		Events:      sub.Events,
+		Bridges:     sub.Bridges,
+		Signed:      sub.Signed,
+		Created:     sub.Created,
+		Delivered:   sub.Delivered,
+		Pending:     len(sub.queue),
+		DeadLetters: len(sub.deadLetters),
+	}
+}
+
+func (request SubscriptionRequest) validate() error {
+	target, err := url.Parse(request.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("Invalid Url, http or https URL is required")
+	}
+
+	for _, event := range request.Events {
+		if contains(NotificationTypes, event) || strings.HasSuffix(event, ".*") && contains(notificationGroups, strings.TrimSuffix(event, ".*")) {
+			continue
+		}
+		return fmt.Errorf("Unknown event %s, bridge.*, slice.*, tenant.* or one of %s is required", event, strings.Join(NotificationTypes, ", "))
+	}
+	return nil
+}
+
+// addSubscription handles the POST /api/v1/subscriptions endpoint.
+// It subscribes a webhook to the notifications of bridges and slices.
+//
+// @Summary Add webhook subscription
+// @Description Subscribe a URL to the notifications of bridges and slices: created, deleted, failed or degraded, and slice updated. Each notification is posted as JSON with headers X-TN-Event, X-TN-Delivery holding the notification Id, and X-TN-Signature sha256=<hex HMAC-SHA256 of body> if Secret is set. A notification is posted again with backoff until the subscriber answers 2xx, after the last attempt it is kept in the dead letters. The same notification may be delivered more than once.
+// @Tags subscription
+// @Accept json
+// @Produce json
+// @Param request body SubscriptionRequest true "Subscription request"
+// @Success 201 {object} Subscription
+// @Failure 400 {string} string "Invalid request body, Url or event"
+// @Router /api/v1/subscriptions [post]
+func addSubscription(c *gin.Context) {
+	var request SubscriptionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := request.validate(); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sub := &Subscription{
+		Id:      generateRandomString(8),
+		Url:     request.Url,
+		Events:  request.Events,
+		Bridges: request.Bridges,
+		Signed:  request.Secret != "",
+		Created: time.Now(),
+		secret:  []byte(request.Secret),
+		queue:   make(chan Notification, webhookQueue),
+		done:    make(chan struct{}),
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	if sub.Bridges == nil {
+		sub.Bridges = []string{}
+	}
+
+	subscriptionLock.Lock()
+	subscriptions[sub.Id] = sub
+	view := sub.view()
+	subscriptionLock.Unlock()
+
+	go sub.run()
+
+	sysLogger.Println("Add subscription ", sub.Id, " of ", sub.Url)
+	c.JSON(http.StatusCreated, view)
+}
+
+// listSubscription handles the GET /api/v1/subscriptions endpoint.
+// It lists the webhook subscriptions.
+//
+// @Summary List webhook subscriptions
+// @Description List the webhook subscriptions with their delivered, pending and dead lettered notifications
+// @Tags subscription
+// @Produce json
+// @Success 200 {array} Subscription
+// @Router /api/v1/subscriptions [get]
+func listSubscription(c *gin.Context) {
+	subscriptionLock.Lock()
+	defer subscriptionLock.Unlock()
+
+	list := []Subscription{}
+	for _, sub := range subscriptions {
+		list = append(list, sub.view())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	c.JSON(http.StatusOK, list)
+}
+
+// retrieveSubscription handles the GET /api/v1/subscriptions/:subscription_id endpoint.
+// It returns a webhook subscription.
+//
+// @Summary Retrieve webhook subscription
+// @Description Retrieve the webhook subscription of the given ID
+// @Tags subscription
+// @Produce json
+// @Param subscription_id path string true "Subscription ID"
+// @Success 200 {object} Subscription
+// @Failure 404 {string} string "Subscription not found"
+// @Router /api/v1/subscriptions/{subscription_id} [get]
+func retrieveSubscription(c *gin.Context) {
+	subscriptionLock.Lock()
+	defer subscriptionLock.Unlock()
+
+	sub, ok := subscriptions[c.Param("subscription_id")]
+	if !ok {
+		c.String(http.StatusNotFound, "Subscription not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, sub.view())
+}
+
+// listDeadLetter handles the GET /api/v1/subscriptions/:subscription_id/deadletters endpoint.
+// It lists the notifications which could not be delivered.
+//
+// @Summary List dead letters
+// @Description List the notifications of the subscription which could not be delivered after the last attempt, or did not fit in its queue, the oldest first
+// @Tags subscription
+// @Produce json
+// @Param subscription_id path string true "Subscription ID"
+// @Success 200 {array} DeadLetter
+// @Failure 404 {string} string "Subscription not found"
+// @Router /api/v1/subscriptions/{subscription_id}/deadletters [get]
+func listDeadLetter(c *gin.Context) {
+	subscriptionLock.Lock()
+	defer subscriptionLock.Unlock()
+
+	sub, ok := subscriptions[c.Param("subscription_id")]
+	if !ok {
+		c.String(http.StatusNotFound, "Subscription not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, append([]DeadLetter{}, sub.deadLetters...))
+}
+
+// delSubscription handles the DELETE /api/v1/subscriptions/:subscription_id endpoint.
+// It deletes a webhook subscription, the pending notifications are dropped.
+//
+// @Summary Delete webhook subscription
+// @Description Delete the webhook subscription of the given ID, its pending notifications and dead letters are dropped
+// @Tags subscription
+// @Param subscription_id path string true "Subscription ID"
+// @Success 204 {string} string "Subscription deleted"
+// @Failure 404 {string} string "Subscription not found"
+// @Router /api/v1/subscriptions/{subscription_id} [delete]
+func delSubscription(c *gin.Context) {
+	subscriptionLock.Lock()
+	defer subscriptionLock.Unlock()
+
+	sub, ok := subscriptions[c.Param("subscription_id")]
+	if !ok {
+		c.String(http.StatusNotFound, "Subscription not found")
+		return
+	}
+
+	delete(subscriptions, sub.Id)
+	close(sub.done)
+
+	sysLogger.Println("Delete subscription ", sub.Id)
+	c.String(http.StatusNoContent, "Subscription deleted")
+}